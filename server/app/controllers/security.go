@@ -5,14 +5,14 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
 	"time"
-	"app/db"
-	"app/db/models"
+	"app/permissions"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
@@ -23,12 +23,14 @@ var jwtKey = []byte(os.Getenv("JWT_KEY"))
 
 type CustomClaims struct {
 	jwt.RegisteredClaims
-	Pseudo string `json:"pseudo"`
-	Role   string `json:"role"`
+	Pseudo    string `json:"pseudo"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
 }
 
-func GenerateJWT(userID uuid.UUID, email, role, pseudo string) (string, error) {
-	expirationTime := time.Now().Add(10000 * time.Hour)
+// GenerateJWT signs a short-lived access token for the session sessionID.
+func GenerateJWT(userID uuid.UUID, email, role, pseudo string, sessionID uuid.UUID) (string, error) {
+	expirationTime := time.Now().Add(accessTokenTTL)
 	claims := CustomClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        fmt.Sprintf("%v", userID),
@@ -36,8 +38,9 @@ func GenerateJWT(userID uuid.UUID, email, role, pseudo string) (string, error) {
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			Audience:  []string{role},
 		},
-		Pseudo: pseudo,
-		Role:   role,
+		Pseudo:    pseudo,
+		Role:      role,
+		SessionID: sessionID.String(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -59,6 +62,29 @@ func getJwt(c *gin.Context) (string, error) {
 	return splitToken[1], nil
 }
 
+// authenticate reads and validates the access token of the request, answering
+// 401 when it is missing, invalid, expired or revoked.
+func authenticate(c *gin.Context) (jwt.MapClaims, bool) {
+	reqToken, err := getJwt(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		c.Abort()
+		return nil, false
+	}
+
+	claims, err := ParseAccessToken(reqToken)
+	if err != nil {
+		status := http.StatusUnauthorized
+		if !errors.Is(err, ErrInvalidToken) && !errors.Is(err, ErrSessionRevoked) {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		c.Abort()
+		return nil, false
+	}
+	return claims, true
+}
+
 func GenerateVerificationToken() (string, error) {
 	bytes := make([]byte, 16)
 	if _, err := rand.Read(bytes); err != nil {
@@ -69,42 +95,20 @@ func GenerateVerificationToken() (string, error) {
 
 func TokenAuthMiddleware(requiredRole string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		reqToken, err := getJwt(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			c.Abort()
+		claims, ok := authenticate(c)
+		if !ok {
 			return
 		}
 
-		token, err := jwt.Parse(reqToken, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNotSupported
-			}
-			return jwtKey, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		roles := claims["aud"].([]interface{})
+		userRole, _ := roles[0].(string)
+		if userRole != "admin" && userRole != requiredRole {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
 			c.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			roles := claims["aud"].([]interface{})
-			userRole := roles[0].(string)
-
-			if userRole != "admin" && userRole != requiredRole {
-				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
-				c.Abort()
-				return
-			}
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		c.Set("jwt_claims", token.Claims)
+		c.Set("jwt_claims", claims)
 		c.Next()
 	}
 }
@@ -167,27 +171,7 @@ func FilterBodyMiddleware(fieldsToFilter ...string) gin.HandlerFunc {
 
 func PermissionMiddleware(requiredPermission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		reqToken, err := getJwt(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			c.Abort()
-			return
-		}
-
-		token, err := jwt.Parse(reqToken, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNotSupported
-			}
-			return jwtKey, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		if claims, ok := authenticate(c); ok {
 			userIDStr, ok := claims["jti"].(string)
 			if !ok {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -242,71 +226,24 @@ func PermissionMiddleware(requiredPermission string) gin.HandlerFunc {
 				return
 			}
 
-			var roleUser models.RoleUser
-			// Joindre avec la table Role pour filtrer par server_id
-			if err := db.GetDB().Joins("JOIN roles ON roles.id = role_users.role_id").Where("role_users.user_id = ? AND roles.server_id = ?", userID, serverID).First(&roleUser).Error; err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "User role not found"})
-				c.Abort()
-				return
-			}
-
-			var rolePermissions []models.RolePermissions
-			if err := db.GetDB().Where("role_id = ?", roleUser.RoleID).Preload("Permissions").Find(&rolePermissions).Error; err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Role permissions not found"})
-				c.Abort()
+			decision, err := permissions.Check(userID, serverID, requiredPermission)
+			if permissionDenied(c, decision, err) {
 				return
 			}
 
-			isAuthorized := false
-			for _, rp := range rolePermissions {
-				if rp.Permissions.Label == requiredPermission {
-					if requiredPermission == "admin" && roleUser.Role.Label == "admin" {
-						isAuthorized = true
-					} else if rp.Power == 1 {
-						isAuthorized = true
-					}
-					break
-				}
-			}
-
-			if !isAuthorized {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Insufficient permissions"})
-				c.Abort()
+			if twoFactorDenied(c, serverID, userID, requiredPermission) {
 				return
 			}
 
-			c.Set("jwt_claims", token.Claims)
+			c.Set("jwt_claims", claims)
 			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
 		}
 	}
 }
 
 func PermissionChannelMiddleware(requiredPermission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		reqToken, err := getJwt(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			c.Abort()
-			return
-		}
-
-		token, err := jwt.Parse(reqToken, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNotSupported
-			}
-			return jwtKey, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		if claims, ok := authenticate(c); ok {
 			userIDStr, ok := claims["jti"].(string)
 			if !ok {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -358,48 +295,119 @@ func PermissionChannelMiddleware(requiredPermission string) gin.HandlerFunc {
 				return
 			}
 
-			var roleUser models.RoleUser
-			if err := db.GetDB().Joins("JOIN roles ON roles.id = role_users.role_id").Where("role_users.user_id = ?", userID).First(&roleUser).Error; err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "User role not found"})
-				c.Abort()
+			// Same check as permissions.CheckChannel, keeping the channel for
+			// the 2FA requirement of its server.
+			channel, err := permissions.LoadChannel(channelID)
+			if err != nil {
+				permissionDenied(c, permissions.Decision{Permission: requiredPermission}, err)
 				return
 			}
-
-			var rolePermissions []models.RolePermissions
-			if err := db.GetDB().Where("role_id = ?", roleUser.RoleID).Preload("Permissions").Find(&rolePermissions).Error; err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Role permissions not found"})
-				c.Abort()
+			if channel.ServerID == uuid.Nil {
+				if !groupChannelDenied(c, userID, channelID, requiredPermission) {
+					c.Set("jwt_claims", claims)
+					c.Next()
+				}
 				return
 			}
-
-			var channelPermissions models.ChannelChannelPermissions
-			if err := db.GetDB().Joins("JOIN channel_permissions ON channel_permissions.id = channel_channel_permissions.channel_permission_id").
-				Where("channel_channel_permissions.channel_id = ? AND channel_permissions.label = ?", channelID, requiredPermission).
-				First(&channelPermissions).Error; err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Channel permissions not found"})
-				c.Abort()
+			member, err := permissions.LoadMember(userID, channel.ServerID)
+			if err != nil {
+				permissionDenied(c, permissions.Decision{Permission: requiredPermission}, err)
 				return
 			}
-
-			isAuthorized := false
-			for _, rp := range rolePermissions {
-				if rp.Permissions.Label == requiredPermission && rp.Power >= channelPermissions.Power {
-					isAuthorized = true
-					break
-				}
+			if permissionDenied(c, member.Evaluate(requiredPermission, channel), nil) {
+				return
 			}
 
-			if !isAuthorized {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Insufficient permissions"})
-				c.Abort()
+			if twoFactorDenied(c, channel.ServerID, userID, requiredPermission) {
 				return
 			}
 
-			c.Set("jwt_claims", token.Claims)
+			c.Set("jwt_claims", claims)
 			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		}
+	}
+}
+
+// PermissionRoleMiddleware checks requiredPermission on the server of the role
+// given by the id parameter.
+func PermissionRoleMiddleware(requiredPermission string) gin.HandlerFunc {
+	return permissionOnMiddleware(requiredPermission, "Invalid role ID", permissions.RoleServer)
+}
+
+// PermissionReportMiddleware checks requiredPermission on the server of the
+// report given by the id parameter.
+func PermissionReportMiddleware(requiredPermission string) gin.HandlerFunc {
+	return permissionOnMiddleware(requiredPermission, "Invalid report ID", permissions.ReportServer)
+}
+
+// permissionOnMiddleware checks requiredPermission on the server that
+// serverOf finds for the id parameter.
+func permissionOnMiddleware(requiredPermission string, invalidID string, serverOf func(uuid.UUID) (uuid.UUID, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := authenticate(c)
+		if !ok {
+			return
+		}
+
+		userID, err := uuid.Parse(fmt.Sprintf("%v", claims["jti"]))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+			c.Abort()
+			return
+		}
+
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalidID})
 			c.Abort()
+			return
+		}
+
+		decision := permissions.Decision{Permission: requiredPermission}
+		serverID, err := serverOf(id)
+		if err == nil {
+			decision, err = permissions.Check(userID, serverID, requiredPermission)
+		}
+		if permissionDenied(c, decision, err) {
+			return
+		}
+
+		if twoFactorDenied(c, serverID, userID, requiredPermission) {
+			return
 		}
+
+		c.Set("jwt_claims", claims)
+		c.Next()
+	}
+}
+
+// groupChannelDenied checks requiredPermission in the channel of a group or
+// DM: its members may read it, nothing else is granted there.
+func groupChannelDenied(c *gin.Context, userID uuid.UUID, channelID uuid.UUID, requiredPermission string) bool {
+	decision := permissions.Decision{Permission: requiredPermission, Reason: permissions.ReasonNotMember}
+	member, err := permissions.GroupMember(userID, channelID)
+	if member {
+		decision.Allowed, decision.Reason = requiredPermission == "accessChannel", permissions.ReasonMember
+	}
+	return permissionDenied(c, decision, err)
+}
+
+// permissionDenied answers the request when the check failed or refused the
+// permission, and tells whether it did.
+func permissionDenied(c *gin.Context, decision permissions.Decision, err error) bool {
+	switch {
+	case errors.Is(err, permissions.ErrServerNotFound), errors.Is(err, permissions.ErrChannelNotFound),
+		errors.Is(err, permissions.ErrRoleNotFound), errors.Is(err, permissions.ErrReportNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	case decision.Reason == permissions.ReasonNotMember:
+		c.JSON(http.StatusForbidden, gin.H{"error": "User role not found"})
+	case !decision.Allowed:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	default:
+		return false
 	}
+	c.Abort()
+	return true
 }
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/hub"
+	"app/protocol"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrSessionRevoked      = errors.New("session revoked or expired")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token already used, session revoked")
+)
+
+// ParseAccessToken validates an access JWT and checks that the session it
+// belongs to has not been revoked.
+func ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, http.ErrNotSupported
+		}
+		return jwtKey, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := claims["jti"].(string); !ok {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := claims["aud"].([]interface{}); !ok {
+		return nil, ErrInvalidToken
+	}
+	sid, ok := claims["sid"].(string)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	sessionID, err := uuid.Parse(sid)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	active, err := sessionActive(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+// sessionActive reports whether the session is neither revoked nor expired.
+func sessionActive(sessionID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.GetDB().Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// IssueTokens opens a new session for user on device, from ip.
+func IssueTokens(user models.User, device string, ip string) (models.TokenResponse, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		Device:     device,
+		IP:         ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+	refresh, raw, err := newRefreshToken(user.ID, session.ID)
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		return tx.Create(&refresh).Error
+	})
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	return tokenResponse(user, session.ID, raw)
+}
+
+// RefreshTokens exchanges a refresh token for a new access and refresh token
+// and marks the session as seen from ip. Presenting a token that was already
+// exchanged revokes its session: either the client or an attacker holds a
+// stolen copy.
+func RefreshTokens(raw string, ip string) (models.TokenResponse, error) {
+	var (
+		user    models.User
+		current models.RefreshToken
+		next    models.RefreshToken
+		nextRaw string
+		reused  bool
+	)
+
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", HashToken(raw)).First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		if current.RevokedAt != nil {
+			return ErrSessionRevoked
+		}
+		if current.UsedAt != nil {
+			reused = true
+			return revokeSessions(tx, current.FamilyID)
+		}
+		if time.Now().After(current.ExpiresAt) {
+			return ErrRefreshTokenExpired
+		}
+		if err := tx.Where("id = ?", current.UserID).First(&user).Error; err != nil {
+			return err
+		}
+
+		var err error
+		next, nextRaw, err = newRefreshToken(current.UserID, current.FamilyID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&next).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&current).Updates(map[string]interface{}{"used_at": now, "replaced_by_id": next.ID}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Session{}).Where("id = ?", current.FamilyID).
+			Updates(map[string]interface{}{"last_seen_at": now, "ip": ip, "expires_at": next.ExpiresAt}).Error
+	})
+	if err != nil {
+		return models.TokenResponse{}, err
+	}
+	if reused {
+		closeSessionSockets(current.FamilyID)
+		return models.TokenResponse{}, ErrRefreshTokenReused
+	}
+
+	return tokenResponse(user, next.FamilyID, nextRaw)
+}
+
+// RevokeRefreshToken ends the session raw belongs to.
+func RevokeRefreshToken(raw string) error {
+	var token models.RefreshToken
+	if err := db.GetDB().Where("token_hash = ?", HashToken(raw)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+	return RevokeSessions(token.FamilyID)
+}
+
+// RevokeSessions ends the given sessions: their refresh tokens stop working,
+// their access tokens are rejected and their sockets are closed.
+func RevokeSessions(sessionIDs ...uuid.UUID) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		return revokeSessions(tx, sessionIDs...)
+	})
+	if err != nil {
+		return err
+	}
+	closeSessionSockets(sessionIDs...)
+	return nil
+}
+
+func revokeSessions(tx *gorm.DB, sessionIDs ...uuid.UUID) error {
+	now := time.Now()
+	if err := tx.Model(&models.Session{}).
+		Where("id IN ? AND revoked_at IS NULL", sessionIDs).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id IN ? AND revoked_at IS NULL", sessionIDs).
+		Update("revoked_at", now).Error
+}
+
+func closeSessionSockets(sessionIDs ...uuid.UUID) {
+	h := hub.GetHub()
+	for _, sessionID := range sessionIDs {
+		topic := hub.AuthTopic(sessionID)
+		h.Publish(topic, protocol.NewEvent(protocol.EventSessionRevoked, protocol.SessionRevoked{SessionID: sessionID}))
+		h.CloseTopic(topic)
+	}
+}
+
+// HashToken is how opaque tokens are stored: only their SHA-256 digest.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken(userID uuid.UUID, familyID uuid.UUID) (models.RefreshToken, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return models.RefreshToken{}, "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+
+	return models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: HashToken(raw),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}, raw, nil
+}
+
+func tokenResponse(user models.User, sessionID uuid.UUID, refresh string) (models.TokenResponse, error) {
+	access, err := GenerateJWT(user.ID, user.Email, user.Role, user.Pseudo, sessionID)
+	if err != nil {
+		return models.TokenResponse{}, fmt.Errorf("generate access token: %w", err)
+	}
+	return models.TokenResponse{Token: access, RefreshToken: refresh, ExpiresIn: int(accessTokenTTL.Seconds())}, nil
+}
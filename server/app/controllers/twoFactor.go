@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"app/db"
+	"app/db/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SensitivePermissions are refused to members without two-factor
+// authentication on servers that require it.
+var SensitivePermissions = map[string]bool{
+	"banUser":     true,
+	"kickUser":    true,
+	"createRole":  true,
+	"editChannel": true,
+}
+
+// MissingTwoFactor reports whether userID must enable 2FA before using
+// permission on serverID.
+func MissingTwoFactor(serverID uuid.UUID, userID uuid.UUID, permission string) (bool, error) {
+	if !SensitivePermissions[permission] {
+		return false, nil
+	}
+
+	var server models.Server
+	if err := db.GetDB().Select("require_two_factor").First(&server, "id = ?", serverID).Error; err != nil {
+		return false, err
+	}
+	if !server.RequireTwoFactor {
+		return false, nil
+	}
+
+	var user models.User
+	if err := db.GetDB().Select("totp_enabled").First(&user, "id = ?", userID).Error; err != nil {
+		return false, err
+	}
+	return !user.TOTPEnabled, nil
+}
+
+// twoFactorDenied answers 403 when userID must enable 2FA before using
+// permission on serverID, and tells whether it did.
+func twoFactorDenied(c *gin.Context, serverID uuid.UUID, userID uuid.UUID, permission string) bool {
+	missing, err := MissingTwoFactor(serverID, userID, permission)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Abort()
+		return true
+	}
+	if missing {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication required", "code": "two_factor_required"})
+		c.Abort()
+		return true
+	}
+	return false
+}
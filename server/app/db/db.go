@@ -36,6 +36,10 @@ func MakeMigrations() {
 	if db == nil {
 		log.Fatal("Database not initialized. Call InitDB first.")
 	}
+	if err := migrateMessageSentAt(db); err != nil {
+		log.Fatalf("Failed to migrate messages.sent_at: %v", err)
+	}
+
 	err := db.AutoMigrate(
 		&models.User{},
 		&models.Rule{},
@@ -60,15 +64,33 @@ func MakeMigrations() {
 		&models.GroupMember{},
 		&models.ChannelChannelPermissions{},
 		&models.ChannelPermissions{},
+		&models.MessageRevision{},
+		&models.ReadState{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.UserToken{},
+		&models.OutboxEmail{},
+		&models.RecoveryCode{},
+		&models.ChannelOverwrite{},
+		&models.DataMigration{},
 	)
 
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	if err := runDataMigrations(db); err != nil {
+		log.Fatalf("Failed to migrate data: %v", err)
+	}
+
+	if err := createMessageIndexes(db); err != nil {
+		log.Fatalf("Failed to create message indexes: %v", err)
+	}
+
 	models.CreateInitialReaction(db)
 
 	models.CreateInitialPermissions(db)
+	models.BackfillRolePermissions(db)
 	models.CreateInitialChannelPermissions(db)
 	models.CreateInitialFeatures(db)
 
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"app/db/models"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// dataMigrations fix existing rows once, in order. Each one runs in the
+// transaction that records it in data_migrations.
+var dataMigrations = []struct {
+	name string
+	run  func(tx *gorm.DB) error
+}{
+	{"verify_legacy_users", VerifyLegacyUsers},
+	{"spread_role_positions", SpreadRolePositions},
+}
+
+func runDataMigrations(db *gorm.DB) error {
+	for _, migration := range dataMigrations {
+		var done models.DataMigration
+		err := db.Where("name = ?", migration.name).First(&done).Error
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		log.Printf("Running data migration %s", migration.name)
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.run(tx); err != nil {
+				return err
+			}
+			return tx.Create(&models.DataMigration{Name: migration.name}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", migration.name, err)
+		}
+	}
+	return nil
+}
+
+// VerifyLegacyUsers marks the accounts created before email verification as
+// verified: they never got a verification link and must keep logging in.
+func VerifyLegacyUsers(tx *gorm.DB) error {
+	return tx.Model(&models.User{}).Where("is_verified = ?", false).Update("is_verified", true).Error
+}
+
+// SpreadRolePositions orders the roles created before positions existed, which
+// all sat at 0: membre stays at 0, the other roles take 1..n in their current
+// order, then by creation date, and admin goes on top.
+func SpreadRolePositions(tx *gorm.DB) error {
+	var roles []models.Role
+	if err := tx.Order("server_id, position, created_at, id").Find(&roles).Error; err != nil {
+		return err
+	}
+
+	servers := make(map[uuid.UUID][]models.Role)
+	for _, role := range roles {
+		servers[role.ServerID] = append(servers[role.ServerID], role)
+	}
+
+	for _, serverRoles := range servers {
+		var custom, admins []models.Role
+		for _, role := range serverRoles {
+			switch role.Label {
+			case "membre":
+				if err := setRolePosition(tx, role, 0); err != nil {
+					return err
+				}
+			case "admin":
+				admins = append(admins, role)
+			default:
+				custom = append(custom, role)
+			}
+		}
+		for i, role := range custom {
+			if err := setRolePosition(tx, role, i+1); err != nil {
+				return err
+			}
+		}
+		for _, role := range admins {
+			if err := setRolePosition(tx, role, len(custom)+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setRolePosition(tx *gorm.DB, role models.Role, position int) error {
+	return tx.Model(&models.Role{}).Where("id = ?", role.ID).UpdateColumn("position", position).Error
+}
+
+// migrateMessageSentAt turns the legacy text sent_at column into a timestamp.
+// Values that cannot be read as a date fall back to the row creation time, so
+// one bad row never stops the migration.
+func migrateMessageSentAt(db *gorm.DB) error {
+	var dataType string
+	err := db.Raw("SELECT data_type FROM information_schema.columns WHERE table_name = 'messages' AND column_name = 'sent_at'").Scan(&dataType).Error
+	if err != nil || dataType != "text" {
+		return err
+	}
+
+	log.Println("Migrating messages.sent_at to timestamptz")
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		statements := []string{
+			"ALTER TABLE messages ADD COLUMN sent_at_ts timestamptz",
+			// A cast error aborts the whole statement: try_timestamptz turns
+			// it into NULL for that row only.
+			`CREATE FUNCTION pg_temp.try_timestamptz(value text) RETURNS timestamptz AS $$
+			BEGIN
+				RETURN value::timestamptz;
+			EXCEPTION WHEN others THEN
+				RETURN NULL;
+			END;
+			$$ LANGUAGE plpgsql`,
+			`UPDATE messages SET sent_at_ts = pg_temp.try_timestamptz(sent_at)
+			WHERE sent_at ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}'`,
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		fallback := tx.Exec("UPDATE messages SET sent_at_ts = created_at WHERE sent_at_ts IS NULL")
+		if fallback.Error != nil {
+			return fallback.Error
+		}
+		if fallback.RowsAffected > 0 {
+			log.Printf("%d messages had an unreadable sent_at and now use their creation time", fallback.RowsAffected)
+		}
+
+		for _, statement := range []string{
+			"ALTER TABLE messages DROP COLUMN sent_at",
+			"ALTER TABLE messages RENAME COLUMN sent_at_ts TO sent_at",
+		} {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// createMessageIndexes adds the keyset pagination index of channel history.
+func createMessageIndexes(db *gorm.DB) error {
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_channel_created_id ON messages (channel_id, created_at, id)").Error
+}
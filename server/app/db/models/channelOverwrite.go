@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ChannelOverwrite allows or denies a permission in a channel to a role or to
+// a single user, whatever their roles give. Exactly one of RoleID and UserID
+// is set.
+type ChannelOverwrite struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	ChannelID  uuid.UUID  `gorm:"type:uuid;index;not null"`
+	RoleID     *uuid.UUID `gorm:"type:uuid"`
+	UserID     *uuid.UUID `gorm:"type:uuid"`
+	Permission string     `gorm:"not null"`
+	Allow      bool
+}
+
+func (co *ChannelOverwrite) BeforeCreate(tx *gorm.DB) (err error) {
+	co.ID = uuid.New()
+	return nil
+}
+
+// ChannelOverwritePayload represents the payload for setting an overwrite.
+type ChannelOverwritePayload struct {
+	RoleID     *uuid.UUID `json:"role_id"`
+	UserID     *uuid.UUID `json:"user_id"`
+	Permission string     `json:"permission" binding:"required"`
+	Allow      *bool      `json:"allow" binding:"required"`
+}
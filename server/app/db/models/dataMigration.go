@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DataMigration records a one-time data migration that already ran, so it
+// never runs again.
+type DataMigration struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	Name string `gorm:"uniqueIndex;not null"`
+}
+
+func (dm *DataMigration) BeforeCreate(tx *gorm.DB) (err error) {
+	dm.ID = uuid.New()
+	return nil
+}
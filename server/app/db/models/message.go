@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -10,11 +12,19 @@ type Message struct {
 	gorm.Model
 	Content   string    `gorm:"validate:required"`
 	Type      string    `gorm:"validate:required"`
-	SentAt    string    `gorm:"validate:required"`
+	SentAt    time.Time `gorm:"validate:required"`
 	UserID    uuid.UUID `gorm:"validate:required"`
 	User      User      `gorm:"foreignKey:UserID;references:ID;"`
 	ChannelID uuid.UUID `gorm:"validate:required"`
 	Channel   Channel   `gorm:"foreignKey:ChannelID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	EditedAt  *time.Time
+	Revisions []MessageRevision `gorm:"foreignKey:MessageID;references:ID;"`
+	// ReplyToID quotes another message, ThreadID files the message under a
+	// thread root instead of the channel history.
+	ReplyToID   *uuid.UUID `gorm:"type:uuid"`
+	ThreadID    *uuid.UUID `gorm:"type:uuid;index"`
+	ReplyCount  int
+	LastReplyAt *time.Time
 }
 
 func (m *Message) BeforeCreate(tx *gorm.DB) (err error) {
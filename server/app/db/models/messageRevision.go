@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MessageRevision keeps the content a message had before one of its edits.
+type MessageRevision struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	Content   string    `gorm:"validate:required"`
+	MessageID uuid.UUID `gorm:"type:uuid;index;validate:required"`
+	EditorID  uuid.UUID `gorm:"validate:required"`
+	Editor    User      `gorm:"foreignKey:EditorID;references:ID;"`
+}
+
+func (mr *MessageRevision) BeforeCreate(tx *gorm.DB) (err error) {
+	mr.ID = uuid.New()
+	return nil
+}
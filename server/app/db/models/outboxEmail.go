@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxEmail is a rendered email waiting to be sent. Failed attempts are
+// retried at NextAttemptAt until the outbox gives up and marks it failed.
+type OutboxEmail struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	Recipient     string    `gorm:"not null"`
+	Subject       string    `gorm:"not null"`
+	Text          string    `gorm:"type:text"`
+	HTML          string    `gorm:"type:text"`
+	Status        string    `gorm:"index;not null;default:pending"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"index;not null"`
+	LastError     string
+	SentAt        *time.Time
+}
+
+func (oe *OutboxEmail) BeforeCreate(tx *gorm.DB) (err error) {
+	oe.ID = uuid.New()
+	return nil
+}
@@ -28,6 +28,10 @@ func CreateInitialPermissions(db *gorm.DB) {
 		{Label: "accessReport"},
 		{Label: "profileServer"},
 		{Label: "editChannel"},
+		{Label: "manageMessages"},
+		{Label: "muteMembers"},
+		{Label: "moveMembers"},
+		{Label: "recordVoice"},
 	}
 
 	for _, perm := range initialPermissions {
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ReadState remembers the last message a user has read in a channel.
+type ReadState struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	UserID            uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_read_states_user_channel;not null"`
+	ChannelID         uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_read_states_user_channel;not null"`
+	LastReadMessageID uuid.UUID `gorm:"type:uuid;not null"`
+	LastReadAt        time.Time `gorm:"not null"`
+}
+
+func (rs *ReadState) BeforeCreate(tx *gorm.DB) (err error) {
+	rs.ID = uuid.New()
+	return nil
+}
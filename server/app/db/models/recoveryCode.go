@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the user has
+// lost their authenticator, stored as a SHA-256 hash.
+type RecoveryCode struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	UserID   uuid.UUID `gorm:"type:uuid;index;not null"`
+	CodeHash string    `gorm:"uniqueIndex;not null"`
+	UsedAt   *time.Time
+}
+
+func (rc *RecoveryCode) BeforeCreate(tx *gorm.DB) (err error) {
+	rc.ID = uuid.New()
+	return nil
+}
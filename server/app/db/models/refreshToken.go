@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshToken is one opaque refresh token, stored as a SHA-256 hash. Every
+// refresh replaces the token with a new one of the same family; FamilyID is
+// the Session the tokens were issued for.
+type RefreshToken struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	UserID       uuid.UUID `gorm:"type:uuid;index;not null"`
+	FamilyID     uuid.UUID `gorm:"type:uuid;index;not null"`
+	TokenHash    string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	UsedAt       *time.Time
+	RevokedAt    *time.Time
+	ReplacedByID *uuid.UUID `gorm:"type:uuid"`
+}
+
+func (rt *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
+	rt.ID = uuid.New()
+	return nil
+}
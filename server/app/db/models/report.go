@@ -24,3 +24,12 @@ func (r *Report) BeforeCreate(tx *gorm.DB) (err error) {
 	r.ID = uuid.New()
 	return nil
 }
+
+// ReportCreatePayload is what a user sends to report a message or a member.
+// The reporter is the logged-in user and new reports are always pending.
+type ReportCreatePayload struct {
+	Message    string     `json:"message" binding:"required"`
+	MessageID  *uuid.UUID `json:"messageID"`
+	ReportedID *uuid.UUID `json:"reportedID"`
+	ServerID   uuid.UUID  `json:"serverID" binding:"required"`
+}
@@ -11,6 +11,21 @@ type Role struct {
 	Label    string    `gorm:"validate:required"`
 	ServerID uuid.UUID `gorm:"validate:required"`
 	Server   Server    `gorm:"foreignKey:ServerID;references:ID;"`
+	// Position orders the roles of a server, the highest comes first.
+	Position int `gorm:"default:0"`
+}
+
+type RoleUpdatePayload struct {
+	Label string `json:"label" binding:"required"`
+}
+
+type RolePosition struct {
+	ID       uuid.UUID `json:"id" binding:"required"`
+	Position *int      `json:"position" binding:"required"`
+}
+
+type RolePositionsPayload struct {
+	Roles []RolePosition `json:"roles" binding:"required,dive"`
 }
 
 func (r *Role) BeforeCreate(tx *gorm.DB) (err error) {
@@ -25,22 +40,10 @@ func (r *Role) AfterCreate(tx *gorm.DB) (err error) {
 	}
 
 	for _, perm := range permissions {
-		var power int
-
-		if r.Label == "admin" {
-			if perm.Label == "editChannel" || perm.Label == "accessChannel" || perm.Label == "sendMessage" {
-				power = 99
-			} else {
-				power = 1
-			}
-		} else {
-			power = 0
-		}
-
 		rolePerm := RolePermissions{
 			RoleID:        r.ID,
 			PermissionsID: perm.ID,
-			Power:         power,
+			Power:         defaultPower(r.Label, perm.Label),
 		}
 
 		if err := tx.Create(&rolePerm).Error; err != nil {
@@ -50,3 +53,38 @@ func (r *Role) AfterCreate(tx *gorm.DB) (err error) {
 
 	return nil
 }
+
+func defaultPower(roleLabel string, permissionLabel string) int {
+	if roleLabel != "admin" {
+		return 0
+	}
+	if permissionLabel == "editChannel" || permissionLabel == "accessChannel" || permissionLabel == "sendMessage" {
+		return 99
+	}
+	return 1
+}
+
+// BackfillRolePermissions gives every existing role a row for permissions
+// added after the role was created.
+func BackfillRolePermissions(db *gorm.DB) {
+	var permissions []Permissions
+	if err := db.Find(&permissions).Error; err != nil {
+		return
+	}
+
+	var roles []Role
+	if err := db.Find(&roles).Error; err != nil {
+		return
+	}
+
+	for _, role := range roles {
+		for _, perm := range permissions {
+			var count int64
+			db.Model(&RolePermissions{}).Where("role_id = ? AND permissions_id = ?", role.ID, perm.ID).Count(&count)
+			if count > 0 {
+				continue
+			}
+			db.Create(&RolePermissions{RoleID: role.ID, PermissionsID: perm.ID, Power: defaultPower(role.Label, perm.Label)})
+		}
+	}
+}
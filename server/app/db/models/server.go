@@ -16,16 +16,19 @@ type Server struct {
 	Tags       []Tag     `gorm:"many2many:server_tags;"`
 	UserID     uuid.UUID `gorm:"not null"`
 	User       User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+	// RequireTwoFactor refuses sensitive permissions to members without 2FA.
+	RequireTwoFactor bool `gorm:"default:false"`
 }
 
 type ServerSwagger struct {
-	ID         uuid.UUID    `json:"id"`
-	Name       string       `json:"name"`
-	Visibility string       `json:"visibility"`
-	MediaID    uuid.UUID    `json:"media_id"`
-	UserID     uuid.UUID    `json:"user_id"`
-	Media      MediaSwagger `json:"media"`
-	Tags       []TagSwagger `json:"tags"`
+	ID               uuid.UUID    `json:"id"`
+	Name             string       `json:"name"`
+	Visibility       string       `json:"visibility"`
+	MediaID          uuid.UUID    `json:"media_id"`
+	UserID           uuid.UUID    `json:"user_id"`
+	Media            MediaSwagger `json:"media"`
+	Tags             []TagSwagger `json:"tags"`
+	RequireTwoFactor bool         `json:"require_two_factor"`
 }
 
 func (s *Server) BeforeCreate(tx *gorm.DB) (err error) {
@@ -42,3 +45,8 @@ type ErrorServerResponse struct {
 type SuccessServerResponse struct {
 	Message string `json:"message"`
 }
+
+// ServerTwoFactorPayload represents the payload for requiring 2FA on a server.
+type ServerTwoFactorPayload struct {
+	Required *bool `json:"required" binding:"required"`
+}
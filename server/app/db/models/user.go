@@ -3,6 +3,7 @@ package models
 import (
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -15,17 +16,28 @@ type User struct {
 	Pseudo            string    `gorm:"unique;validate:required"`
 	Email             string    `gorm:"unique;validate:required,email"`
 	Role              string    `gorm:"default:user"`
-	Password          string    `gorm:"validate:required,min=5,containsany=0123456789"`
+	Password          string    `validate:"required,password"`
 	VerificationToken string    `gorm:"size:255"`
 	IsVerified        bool      `gorm:"default:false"`
 	Provider          string
 	ProviderID        string
 	Profile           string    `gorm:"default:default.jpg"`
 	FcmToken          string    `gorm:"size:255"`
+	Language          string    `gorm:"size:5;default:fr"`
+	TOTPSecret        string    `gorm:"size:64" json:"-"`
+	TOTPEnabled       bool      `gorm:"default:false"`
+	TOTPLastStep      int64     `json:"-"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 }
 
+// ValidPassword is the one rule for passwords, at registration as on a change
+// or a reset: at least 5 characters, one of them a digit. It backs the
+// "password" validation tag.
+func ValidPassword(password string) bool {
+	return utf8.RuneCountInString(password) >= 5 && strings.ContainsAny(password, "0123456789")
+}
+
 func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	u.ID = uuid.New()
 	return nil
@@ -55,6 +67,8 @@ type UserSwagger struct {
 	ProviderID        string    `json:"provider_id"`
 	Profile           string    `json:"profile"`
 	FcmToken          string    `json:"fcm_token"`
+	Language          string    `json:"language"`
+	TOTPEnabled       bool      `json:"totp_enabled"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 }
@@ -78,7 +92,7 @@ type LoginPayload struct {
 // ChangePasswordPayload represents the payload for changing password.
 type ChangePasswordPayload struct {
 	CurrentPassword string `json:"currentPassword" binding:"required"`
-	NewPassword     string `json:"newPassword" binding:"required,min=6"`
+	NewPassword     string `json:"newPassword" binding:"required,password"`
 }
 
 // UpdateUserDataPayload represents the payload for updating user data.
@@ -87,9 +101,60 @@ type UpdateUserDataPayload struct {
 	Profile string `json:"profile"`
 }
 
-// TokenResponse represents the response containing a JWT token.
+// TokenResponse represents the response containing a short-lived access JWT
+// and the refresh token used to obtain the next one.
 type TokenResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// EmailPayload represents the payload for resending a verification email or
+// requesting a password reset.
+type EmailPayload struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordPayload represents the payload for choosing a new password.
+type ResetPasswordPayload struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"newPassword" binding:"required,password"`
+}
+
+// RefreshPayload represents the payload for refreshing or revoking a session.
+type RefreshPayload struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LoginChallengeResponse is returned by login instead of tokens when the user
+// has two-factor authentication enabled.
+type LoginChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+// TwoFactorLoginPayload represents the second login step. Code is a TOTP code
+// or a recovery code.
+type TwoFactorLoginPayload struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// TwoFactorCodePayload represents a TOTP or recovery code.
+type TwoFactorCodePayload struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorEnrollResponse holds the secret to add to an authenticator app.
+type TwoFactorEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResponse lists recovery codes. They are only shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 // FcmTokenPayload represents the payload for registering FCM token.
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	UserTokenVerifyEmail   = "verify_email"
+	UserTokenResetPassword = "reset_password"
+	// UserTokenLoginChallenge is handed out by login when a second factor
+	// is needed and is never mailed.
+	UserTokenLoginChallenge = "login_challenge"
+)
+
+// UserToken is a single-use token mailed to a user, stored as a SHA-256 hash.
+// Purpose tells which flow it belongs to.
+type UserToken struct {
+	ID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	gorm.Model
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	Purpose   string    `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	Attempts  int `gorm:"default:0"`
+}
+
+func (ut *UserToken) BeforeCreate(tx *gorm.DB) (err error) {
+	ut.ID = uuid.New()
+	return nil
+}
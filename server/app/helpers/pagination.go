@@ -0,0 +1,64 @@
+package helpers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+const (
+	CursorLatest = ""
+	CursorBefore = "before"
+	CursorAfter  = "after"
+	CursorAround = "around"
+)
+
+// Cursor is a keyset pagination request: at most Limit rows before, after or
+// around the row ID. Without a direction the latest rows are requested.
+type Cursor struct {
+	Direction string
+	ID        uuid.UUID
+	Limit     int
+}
+
+// ParseCursor reads the before/after/around and limit query parameters. At
+// most one direction may be given and limit is capped at MaxPageSize.
+func ParseCursor(c *gin.Context) (Cursor, error) {
+	cursor := Cursor{Limit: DefaultPageSize}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return Cursor{}, errors.New("limit must be a positive integer")
+		}
+		if limit > MaxPageSize {
+			limit = MaxPageSize
+		}
+		cursor.Limit = limit
+	}
+
+	for _, direction := range []string{CursorBefore, CursorAfter, CursorAround} {
+		raw := c.Query(direction)
+		if raw == "" {
+			continue
+		}
+		if cursor.Direction != CursorLatest {
+			return Cursor{}, errors.New("only one of before, after and around can be used")
+		}
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return Cursor{}, errors.New(direction + " must be a message ID")
+		}
+		cursor.Direction = direction
+		cursor.ID = id
+	}
+
+	return cursor, nil
+}
@@ -47,3 +47,24 @@ func GetLoggedInUserID(c *gin.Context) (uuid.UUID, error) {
 
 	return userID, nil
 }
+
+// GetLoggedInSessionID returns the login session of the access token.
+func GetLoggedInSessionID(c *gin.Context) (uuid.UUID, error) {
+	claims, exists := c.Get("jwt_claims")
+	if !exists {
+		return uuid.UUID{}, errors.New("Erreur lors de la récupération des revendications JWT")
+	}
+
+	jwtClaims, ok := claims.(jwt.MapClaims)
+	if !ok {
+		return uuid.UUID{}, errors.New("Erreur lors de l'extraction des revendications JWT")
+	}
+
+	sessionIDStr, _ := jwtClaims["sid"].(string)
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		return uuid.UUID{}, errors.New("Erreur lors de la récupération de la session")
+	}
+
+	return sessionID, nil
+}
@@ -0,0 +1,159 @@
+package hub
+
+import (
+	"app/protocol"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 64 * 1024
+
+	// SendBufferSize is how many outgoing frames a client may have queued
+	// before it is considered too slow and evicted.
+	SendBufferSize = 256
+
+	// BacklogSize is how many sent events a resumable client keeps for replay.
+	BacklogSize = 200
+)
+
+// Client is one websocket connection attached to the hub. Only WritePump
+// writes to the underlying connection.
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	send   chan []byte
+	topics map[string]bool
+
+	seq        uint64
+	backlog    []sentFrame
+	resumable  bool
+	detached   bool
+	detachedAt time.Time
+
+	UserID    uuid.UUID
+	SessionID string
+}
+
+type sentFrame struct {
+	seq  uint64
+	data []byte
+}
+
+func NewClient(h *Hub, conn *websocket.Conn, userID uuid.UUID) *Client {
+	return &Client{
+		hub:       h,
+		conn:      conn,
+		send:      make(chan []byte, SendBufferSize),
+		topics:    make(map[string]bool),
+		UserID:    userID,
+		SessionID: uuid.NewString(),
+	}
+}
+
+// EnableResume keeps the client's subscriptions and recent events around for
+// ResumeWindow after it disconnects. It must be called before Register.
+func (c *Client) EnableResume() {
+	c.resumable = true
+}
+
+// deliver stamps the next sequence number on event and queues it. It is only
+// called from the hub goroutine and returns false when the queue is full.
+func (c *Client) deliver(event protocol.Event) bool {
+	c.seq++
+	event.Seq = c.seq
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return true
+	}
+
+	if c.resumable {
+		c.backlog = append(c.backlog, sentFrame{seq: c.seq, data: data})
+		if len(c.backlog) > BacklogSize {
+			c.backlog = append([]sentFrame(nil), c.backlog[len(c.backlog)-BacklogSize:]...)
+		}
+	}
+	if c.detached {
+		return true
+	}
+	return c.enqueue(data)
+}
+
+func (c *Client) enqueue(data []byte) bool {
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// Send queues event for this client only.
+func (c *Client) Send(event protocol.Event) {
+	c.hub.SendTo(c, event)
+}
+
+// ReadPump reads frames until the connection fails, handing each one to
+// handle. It unregisters the client when it returns.
+func (c *Client) ReadPump(handle func([]byte)) {
+	defer func() {
+		c.hub.Unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Read message error:", err)
+			}
+			return
+		}
+		handle(message)
+	}
+}
+
+// WritePump drains the send queue to the connection and keeps it alive with
+// pings. It returns once the hub closes the queue or a write fails.
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Println("Write message error:", err)
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package hub
+
+import (
+	"app/protocol"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ResumeWindow is how long a resumable client that dropped its connection
+// keeps its subscriptions and backlog.
+const ResumeWindow = 2 * time.Minute
+
+// ErrInvalidSession is returned by Resume when the session is unknown, has
+// expired, belongs to someone else or can no longer be replayed without gaps.
+var ErrInvalidSession = errors.New("invalid session")
+
+// Hub owns every realtime connection. All of its state is only touched by the
+// Run goroutine; callers talk to it through channels.
+type Hub struct {
+	clients  map[*Client]bool
+	topics   map[string]map[*Client]bool
+	sessions map[string]*Client
+
+	register    chan *Client
+	unregister  chan *Client
+	subscribe   chan subscription
+	unsubscribe chan subscription
+	broadcast   chan broadcastMessage
+	queries     chan func()
+	done        chan struct{}
+	stopOnce    sync.Once
+}
+
+type subscription struct {
+	client *Client
+	userID uuid.UUID
+	topic  string
+}
+
+type broadcastMessage struct {
+	topic  string
+	client *Client
+	event  protocol.Event
+	close  bool
+}
+
+var (
+	defaultHub *Hub
+	once       sync.Once
+)
+
+// GetHub returns the process wide hub, starting it on first use.
+func GetHub() *Hub {
+	once.Do(func() {
+		defaultHub = New()
+		go defaultHub.Run()
+	})
+	return defaultHub
+}
+
+func New() *Hub {
+	return &Hub{
+		clients:     make(map[*Client]bool),
+		topics:      make(map[string]map[*Client]bool),
+		sessions:    make(map[string]*Client),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		subscribe:   make(chan subscription),
+		unsubscribe: make(chan subscription),
+		broadcast:   make(chan broadcastMessage, 256),
+		queries:     make(chan func()),
+		done:        make(chan struct{}),
+	}
+}
+
+func (h *Hub) Run() {
+	janitor := time.NewTicker(ResumeWindow / 4)
+	defer janitor.Stop()
+
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+			if client.resumable {
+				h.sessions[client.SessionID] = client
+			}
+		case client := <-h.unregister:
+			h.drop(client)
+		case sub := <-h.subscribe:
+			if !h.clients[sub.client] {
+				continue
+			}
+			h.join(sub.client, sub.topic)
+		case sub := <-h.unsubscribe:
+			if sub.client != nil {
+				h.leave(sub.client, sub.topic)
+				continue
+			}
+			for client := range h.topics[sub.topic] {
+				if client.UserID == sub.userID {
+					h.leave(client, sub.topic)
+				}
+			}
+		case message := <-h.broadcast:
+			if message.close && message.client != nil {
+				h.drop(message.client)
+				continue
+			}
+			if message.close {
+				// Closing a topic is final: resumable clients are not kept.
+				for client := range h.topics[message.topic] {
+					h.remove(client)
+				}
+				continue
+			}
+			if message.client != nil {
+				if h.clients[message.client] && !message.client.deliver(message.event) {
+					h.drop(message.client)
+				}
+				continue
+			}
+			for client := range h.topics[message.topic] {
+				if !client.deliver(message.event) {
+					// Slow consumer: its buffer is full, drop it rather than
+					// stalling every other subscriber of the topic.
+					h.drop(client)
+				}
+			}
+		case query := <-h.queries:
+			query()
+		case now := <-janitor.C:
+			for _, client := range h.sessions {
+				if client.detached && now.Sub(client.detachedAt) > ResumeWindow {
+					h.remove(client)
+				}
+			}
+		case <-h.done:
+			for client := range h.clients {
+				h.remove(client)
+			}
+			return
+		}
+	}
+}
+
+// Stop closes every client and ends the Run loop.
+func (h *Hub) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+func (h *Hub) Register(client *Client) {
+	select {
+	case h.register <- client:
+	case <-h.done:
+	}
+}
+
+func (h *Hub) Unregister(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
+func (h *Hub) Subscribe(client *Client, topic string) {
+	select {
+	case h.subscribe <- subscription{client: client, topic: topic}:
+	case <-h.done:
+	}
+}
+
+func (h *Hub) Unsubscribe(client *Client, topic string) {
+	select {
+	case h.unsubscribe <- subscription{client: client, topic: topic}:
+	case <-h.done:
+	}
+}
+
+// UnsubscribeUser removes every connection of userID from topic, e.g. when
+// the user leaves or is removed from a server.
+func (h *Hub) UnsubscribeUser(userID uuid.UUID, topic string) {
+	select {
+	case h.unsubscribe <- subscription{userID: userID, topic: topic}:
+	case <-h.done:
+	}
+}
+
+// Publish queues event for every client subscribed to topic. Each client
+// stamps its own sequence number on it.
+func (h *Hub) Publish(topic string, event protocol.Event) {
+	event, err := encodeData(event)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+	select {
+	case h.broadcast <- broadcastMessage{topic: topic, event: event}:
+	case <-h.done:
+	}
+}
+
+// SendTo queues event for a single client.
+func (h *Hub) SendTo(client *Client, event protocol.Event) {
+	select {
+	case h.broadcast <- broadcastMessage{client: client, event: event}:
+	case <-h.done:
+	}
+}
+
+// Close unregisters client once the events already published to it were
+// queued, so a last notice is not lost to the disconnect.
+func (h *Hub) Close(client *Client) {
+	select {
+	case h.broadcast <- broadcastMessage{client: client, close: true}:
+	case <-h.done:
+	}
+}
+
+// CloseTopic removes every client subscribed to topic once the events already
+// published to it were queued.
+func (h *Hub) CloseTopic(topic string) {
+	select {
+	case h.broadcast <- broadcastMessage{topic: topic, close: true}:
+	case <-h.done:
+	}
+}
+
+// Resume hands the session sessionID over to client, which must be a freshly
+// registered connection of the same user. The old connection's subscriptions
+// move to client and every event sent after seq is queued again. It returns
+// how many events were replayed.
+func (h *Hub) Resume(client *Client, sessionID string, seq uint64) (int, error) {
+	replayed := 0
+	err := ErrInvalidSession
+	h.query(func() {
+		previous := h.sessions[sessionID]
+		if previous == nil || previous == client || !h.clients[client] || previous.UserID != client.UserID {
+			return
+		}
+		if seq > previous.seq {
+			return
+		}
+		if seq < previous.seq && (len(previous.backlog) == 0 || previous.backlog[0].seq > seq+1) {
+			return
+		}
+
+		if !previous.detached {
+			previous.detached = true
+			close(previous.send)
+		}
+		for topic := range previous.topics {
+			h.leave(previous, topic)
+			h.join(client, topic)
+		}
+		delete(h.clients, previous)
+		delete(h.sessions, client.SessionID)
+
+		client.SessionID = previous.SessionID
+		client.seq = previous.seq
+		client.backlog = previous.backlog
+		client.resumable = true
+		h.sessions[sessionID] = client
+
+		for _, frame := range client.backlog {
+			if frame.seq <= seq {
+				continue
+			}
+			if !client.enqueue(frame.data) {
+				h.drop(client)
+				return
+			}
+			replayed++
+		}
+		err = nil
+	})
+	return replayed, err
+}
+
+// Subscribers returns how many clients currently listen on topic.
+func (h *Hub) Subscribers(topic string) int {
+	count := 0
+	h.query(func() {
+		count = len(h.topics[topic])
+	})
+	return count
+}
+
+// Connected returns how many clients currently have a live connection.
+func (h *Hub) Connected() int {
+	count := 0
+	h.query(func() {
+		for client := range h.clients {
+			if !client.detached {
+				count++
+			}
+		}
+	})
+	return count
+}
+
+func (h *Hub) query(fn func()) {
+	finished := make(chan struct{})
+	select {
+	case h.queries <- func() {
+		fn()
+		close(finished)
+	}:
+		<-finished
+	case <-h.done:
+	}
+}
+
+func (h *Hub) join(client *Client, topic string) {
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*Client]bool)
+	}
+	h.topics[topic][client] = true
+	client.topics[topic] = true
+}
+
+func (h *Hub) leave(client *Client, topic string) {
+	if subscribers, ok := h.topics[topic]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	delete(client.topics, topic)
+}
+
+// drop closes the connection of client. Resumable clients stay subscribed
+// and keep recording their backlog until they resume or the window expires.
+func (h *Hub) drop(client *Client) {
+	if !h.clients[client] || client.detached {
+		return
+	}
+	if !client.resumable {
+		h.remove(client)
+		return
+	}
+	client.detached = true
+	client.detachedAt = time.Now()
+	close(client.send)
+}
+
+func (h *Hub) remove(client *Client) {
+	if !h.clients[client] {
+		return
+	}
+	for topic := range client.topics {
+		h.leave(client, topic)
+	}
+	delete(h.clients, client)
+	if h.sessions[client.SessionID] == client {
+		delete(h.sessions, client.SessionID)
+	}
+	if !client.detached {
+		close(client.send)
+	}
+}
+
+// encodeData marshals the payload once so every subscriber only has to encode
+// the envelope.
+func encodeData(event protocol.Event) (protocol.Event, error) {
+	if _, ok := event.Data.(json.RawMessage); ok {
+		return event, nil
+	}
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return event, err
+	}
+	event.Data = json.RawMessage(data)
+	return event, nil
+}
+
+func ChannelTopic(channelID uuid.UUID) string {
+	return "channel:" + channelID.String()
+}
+
+func ServerTopic(serverID uuid.UUID) string {
+	return "server:" + serverID.String()
+}
+
+func GroupTopic(groupID uuid.UUID) string {
+	return "group:" + groupID.String()
+}
+
+// UserTopic reaches every gateway connection of one user.
+func UserTopic(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+// VoiceTopic reaches the voice sockets of one user.
+func VoiceTopic(userID uuid.UUID) string {
+	return "voice:" + userID.String()
+}
+
+// AuthTopic reaches every socket opened with the access tokens of one login
+// session.
+func AuthTopic(sessionID uuid.UUID) string {
+	return "auth:" + sessionID.String()
+}
@@ -0,0 +1,204 @@
+// Package mailer renders emails from templates and delivers them through a
+// persistent outbox, so a failing SMTP server delays emails instead of
+// losing them.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// Mailer delivers one message.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Message is an email with a plain text and an HTML alternative.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Bytes encodes msg as a multipart/alternative MIME message. Addresses are
+// parsed so they cannot smuggle extra headers.
+func (msg Message) Bytes() ([]byte, error) {
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: from: %v", ErrInvalidAddress, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("%w: to: %v", ErrInvalidAddress, err)
+	}
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	var out bytes.Buffer
+	for _, header := range [][2]string{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject))},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(from.Address)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + body.Boundary()},
+	} {
+		fmt.Fprintf(&out, "%s: %s\r\n", header[0], header[1])
+	}
+	out.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		writer, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+func messageID(from string) string {
+	random := make([]byte, 12)
+	rand.Read(random)
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">"
+}
+
+// SMTPMailer sends through an SMTP server, with STARTTLS when it offers it.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+func (m SMTPMailer) Send(msg Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	from, _ := mail.ParseAddress(msg.From)
+	to, _ := mail.ParseAddress(msg.To)
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, from.Address, []string{to.Address}, data)
+}
+
+// FileMailer drops every message as an .eml file in Dir instead of sending
+// it, for development and tests.
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(msg Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	random := make([]byte, 4)
+	rand.Read(random)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(random))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o644)
+}
+
+// FromEnv picks the mailer from the environment:
+//
+//	MAILER                 "smtp" or "file"; smtp when SMTP_HOST is set
+//	SMTP_HOST, SMTP_PORT   SMTP server
+//	SMTP_USER, SMTP_PASSWORD
+//	MAIL_DIR               directory of the file mailer (default "mail")
+//	MAIL_FROM              sender address (default SMTP_USER when it is one)
+//
+// It returns the mailer and the sender address.
+func FromEnv(getenv func(string) string) (Mailer, string, error) {
+	from := getenv("MAIL_FROM")
+	if from == "" {
+		// SMTP_USER is often a login rather than an address.
+		from = "noreply@localhost"
+		if _, err := mail.ParseAddress(getenv("SMTP_USER")); err == nil {
+			from = getenv("SMTP_USER")
+		}
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, "", fmt.Errorf("invalid MAIL_FROM: %w", err)
+	}
+
+	kind := getenv("MAILER")
+	if kind == "" {
+		kind = "file"
+		if getenv("SMTP_HOST") != "" {
+			kind = "smtp"
+		}
+	}
+
+	switch kind {
+	case "smtp":
+		mailer := SMTPMailer{
+			Host:     getenv("SMTP_HOST"),
+			Port:     getenv("SMTP_PORT"),
+			Username: getenv("SMTP_USER"),
+			Password: getenv("SMTP_PASSWORD"),
+		}
+		if mailer.Host == "" || mailer.Port == "" {
+			return nil, "", errors.New("SMTP_HOST and SMTP_PORT are required by the smtp mailer")
+		}
+		return mailer, from, nil
+	case "file":
+		dir := getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return FileMailer{Dir: dir}, from, nil
+	default:
+		return nil, "", fmt.Errorf("unknown MAILER %q", kind)
+	}
+}
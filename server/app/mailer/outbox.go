@@ -0,0 +1,188 @@
+package mailer
+
+import (
+	"app/db"
+	"app/db/models"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// MaxAttempts is how many times an email is tried before it is marked
+	// failed.
+	MaxAttempts = 8
+
+	pollInterval = 30 * time.Second
+	batchSize    = 20
+	// claimLease keeps an email claimed by one worker while it sends it.
+	claimLease  = 5 * time.Minute
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 2 * time.Hour
+)
+
+// Backoff is the delay before the next try of an email that failed attempts
+// times: 30s, 1m, 2m... capped at two hours.
+func Backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// Outbox stores emails before sending them so they survive SMTP outages and
+// restarts.
+type Outbox struct {
+	db     *gorm.DB
+	mailer Mailer
+	from   string
+	wake   chan struct{}
+}
+
+func NewOutbox(db *gorm.DB, mailer Mailer, from string) *Outbox {
+	return &Outbox{db: db, mailer: mailer, from: from, wake: make(chan struct{}, 1)}
+}
+
+var (
+	defaultOutbox *Outbox
+	once          sync.Once
+)
+
+// GetOutbox returns the process wide outbox, configured from the environment
+// and started on first use.
+func GetOutbox() *Outbox {
+	once.Do(func() {
+		mailer, from, err := FromEnv(os.Getenv)
+		if err != nil {
+			log.Println("Invalid mailer configuration, emails are written to ./mail:", err)
+			mailer, from = FileMailer{Dir: "mail"}, "noreply@localhost"
+		}
+		defaultOutbox = NewOutbox(db.GetDB(), mailer, from)
+		go defaultOutbox.Run(nil)
+	})
+	return defaultOutbox
+}
+
+// Send renders the template name in lang and queues it for to.
+func Send(to string, name string, lang string, data interface{}) error {
+	return GetOutbox().Enqueue(to, name, lang, data)
+}
+
+// Enqueue renders the template name in lang and stores it for delivery.
+func (o *Outbox) Enqueue(to string, name string, lang string, data interface{}) error {
+	msg, err := Render(name, lang, data)
+	if err != nil {
+		return err
+	}
+	email := models.OutboxEmail{
+		Recipient:     to,
+		Subject:       msg.Subject,
+		Text:          msg.Text,
+		HTML:          msg.HTML,
+		Status:        models.OutboxPending,
+		NextAttemptAt: time.Now(),
+	}
+	if err := o.db.Create(&email).Error; err != nil {
+		return err
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends due emails as they are queued and every pollInterval until stop
+// is closed.
+func (o *Outbox) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.Flush(); err != nil {
+			log.Println("Error flushing outbox:", err)
+		}
+		select {
+		case <-o.wake:
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Flush sends every email that is due and returns how many were sent.
+func (o *Outbox) Flush() (int, error) {
+	sent := 0
+	for {
+		batch, err := o.claim()
+		if err != nil || len(batch) == 0 {
+			return sent, err
+		}
+		for _, email := range batch {
+			if o.deliver(email) {
+				sent++
+			}
+		}
+	}
+}
+
+// claim picks due emails and pushes their next attempt back, so another
+// instance running the outbox does not send them too.
+func (o *Outbox) claim() ([]models.OutboxEmail, error) {
+	var batch []models.OutboxEmail
+	err := o.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.OutboxPending, time.Now()).
+			Order("next_attempt_at").Limit(batchSize).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]interface{}, 0, len(batch))
+		for _, email := range batch {
+			ids = append(ids, email.ID)
+		}
+		return tx.Model(&models.OutboxEmail{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(claimLease)).Error
+	})
+	return batch, err
+}
+
+func (o *Outbox) deliver(email models.OutboxEmail) bool {
+	err := o.mailer.Send(Message{
+		From:    o.from,
+		To:      email.Recipient,
+		Subject: email.Subject,
+		Text:    email.Text,
+		HTML:    email.HTML,
+	})
+
+	updates := map[string]interface{}{"attempts": email.Attempts + 1}
+	if err == nil {
+		updates["status"] = models.OutboxSent
+		updates["sent_at"] = time.Now()
+		updates["last_error"] = ""
+	} else {
+		log.Printf("Erreur lors de l'envoi de l'email à %s (tentative %d): %v", email.Recipient, email.Attempts+1, err)
+		updates["last_error"] = err.Error()
+		if email.Attempts+1 >= MaxAttempts || errors.Is(err, ErrInvalidAddress) {
+			updates["status"] = models.OutboxFailed
+		} else {
+			updates["next_attempt_at"] = time.Now().Add(Backoff(email.Attempts + 1))
+		}
+	}
+
+	if dbErr := o.db.Model(&models.OutboxEmail{}).Where("id = ?", email.ID).Updates(updates).Error; dbErr != nil {
+		log.Println("Error updating outbox email:", dbErr)
+	}
+	return err == nil
+}
@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLanguage is used when the recipient's language has no template.
+const DefaultLanguage = "fr"
+
+// Languages lists the languages templates exist in.
+var Languages = []string{"fr", "en"}
+
+// Every templates/<lang>/<name>.tmpl file defines a "subject", a "text" and
+// an "html" block. The HTML block goes through html/template so data is
+// escaped.
+//
+//go:embed templates/*/*.tmpl
+var templateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var templates = parseTemplates()
+
+func parseTemplates() map[string]emailTemplate {
+	files, err := fs.Glob(templateFS, "templates/*/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	result := make(map[string]emailTemplate, len(files))
+	for _, file := range files {
+		result[file] = emailTemplate{
+			text: texttemplate.Must(texttemplate.ParseFS(templateFS, file)),
+			html: htmltemplate.Must(htmltemplate.ParseFS(templateFS, file)),
+		}
+	}
+	return result
+}
+
+// Language picks the template language matching an Accept-Language header.
+func Language(acceptLanguage string) string {
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		tag = strings.SplitN(tag, "-", 2)[0]
+		for _, lang := range Languages {
+			if tag == lang {
+				return lang
+			}
+		}
+	}
+	return DefaultLanguage
+}
+
+// Render builds the subject and bodies of the email name in lang.
+func Render(name string, lang string, data interface{}) (Message, error) {
+	tmpl, ok := templates[path.Join("templates", lang, name+".tmpl")]
+	if !ok {
+		if tmpl, ok = templates[path.Join("templates", DefaultLanguage, name+".tmpl")]; !ok {
+			return Message{}, fmt.Errorf("unknown email template %q", name)
+		}
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return Message{}, err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "html", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    strings.TrimSpace(html.String()) + "\n",
+	}, nil
+}
@@ -4,7 +4,11 @@ import (
 	"app/controllers"
 	"app/db"
 	_ "app/docs"
+	"app/mailer"
 	"app/routes"
+	"log"
+	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
@@ -25,9 +29,21 @@ func main() {
 	gin.SetMode(gin.ReleaseMode)
 
 	initDb()
+	// Sends the emails left in the outbox by a previous run.
+	mailer.GetOutbox()
 
 	r := gin.Default()
 
+	// Rate limits are keyed by client IP: only trust X-Forwarded-For from
+	// the proxies listed in TRUSTED_PROXIES.
+	var trustedProxies []string
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		trustedProxies = strings.Split(proxies, ",")
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+
 	// Configuration CORS
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -51,28 +67,7 @@ func main() {
 		})
 	})
 
-	/*models*/
-	routes.MediaRoutes(r)
-	routes.UserRoutes(r)
-	routes.ChannelRoutes(r)
-	routes.FriendRoutes(r)
-	routes.InvitationRoutes(r)
-	routes.LogsRoutes(r)
-	routes.MessageRoutes(r)
-	routes.OnServerRoutes(r)
-	routes.PermissionsRoutes(r)
-	routes.ReactRoutes(r)
-	routes.ReactMessageRoutes(r)
-	routes.ReportRoutes(r)
-	routes.RoleRoutes(r)
-	routes.ServerRoutes(r)
-	routes.TagRoutes(r)
-	routes.FeatureRoutes(r)
-	routes.GroupRoutes(r)
-	/*workers*/
-	routes.WebSocketRoutes(r)
-	routes.AuthV2Routes(r)
-	routes.VocalRoutes(r)
+	routes.Register(r)
 
 	// Swagger
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -80,5 +75,10 @@ func main() {
 	//uploads
 	r.Static("/uploads", "./upload")
 
+	// Routes registered above without the routes package need a policy too.
+	if err := routes.Policies.Check(r.Routes()); err != nil {
+		log.Fatal(err)
+	}
+
 	r.Run(":8080")
 }
@@ -0,0 +1,168 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGitHubURL    = "https://github.com"
+	defaultGitHubAPIURL = "https://api.github.com"
+)
+
+// GitHubConfig configures the GitHub provider. BaseURL and APIURL default to
+// github.com and can point to a stand-in in tests.
+type GitHubConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	BaseURL      string
+	APIURL       string
+}
+
+type GitHub struct {
+	config GitHubConfig
+	client *http.Client
+}
+
+func NewGitHub(config GitHubConfig) *GitHub {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultGitHubURL
+	}
+	if config.APIURL == "" {
+		config.APIURL = defaultGitHubAPIURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+	return &GitHub{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (g *GitHub) Name() string {
+	return "github"
+}
+
+func (g *GitHub) AuthCodeURL(state string, challenge string) string {
+	query := url.Values{
+		"client_id":             {g.config.ClientID},
+		"redirect_uri":          {g.config.RedirectURL},
+		"scope":                 {"read:user user:email"},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+		"allow_signup":          {"true"},
+	}
+	return g.config.BaseURL + "/login/oauth/authorize?" + query.Encode()
+}
+
+func (g *GitHub) Exchange(ctx context.Context, code string, verifier string) (Identity, error) {
+	token, err := g.exchange(ctx, code, verifier)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := g.get(ctx, token, "/user", &user); err != nil {
+		return Identity{}, err
+	}
+	if user.ID == 0 {
+		return Identity{}, ErrIdentityFailed
+	}
+
+	// The public email may be unverified or hidden: only trust the primary
+	// verified address.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := g.get(ctx, token, "/user/emails", &emails); err != nil {
+		return Identity{}, err
+	}
+	email := ""
+	for _, candidate := range emails {
+		if candidate.Primary && candidate.Verified {
+			email = candidate.Email
+		}
+	}
+	if email == "" {
+		return Identity{}, ErrNoVerifiedEmail
+	}
+
+	return Identity{
+		Provider:   g.Name(),
+		ProviderID: strconv.FormatInt(user.ID, 10),
+		Email:      strings.ToLower(email),
+		Name:       user.Login,
+		AvatarURL:  user.AvatarURL,
+	}, nil
+}
+
+func (g *GitHub) exchange(ctx context.Context, code string, verifier string) (string, error) {
+	form := url.Values{
+		"client_id":     {g.config.ClientID},
+		"client_secret": {g.config.ClientSecret},
+		"code":          {code},
+		"redirect_uri":  {g.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.config.BaseURL+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchangeFailed, err)
+	}
+	defer resp.Body.Close()
+
+	// GitHub answers 200 with an error field when the code is rejected.
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: status %d", ErrExchangeFailed, resp.StatusCode)
+	}
+	if body.Error != "" || body.AccessToken == "" {
+		return "", fmt.Errorf("%w: %s %s", ErrExchangeFailed, body.Error, body.ErrorDescription)
+	}
+	return body.AccessToken, nil
+}
+
+func (g *GitHub) get(ctx context.Context, token string, path string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.config.APIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrIdentityFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrIdentityFailed, path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("%w: %v", ErrIdentityFailed, err)
+	}
+	return nil
+}
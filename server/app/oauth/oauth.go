@@ -0,0 +1,117 @@
+// Package oauth runs the OAuth2 authorization code flow with PKCE against
+// identity providers and returns who the user is on the provider.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidState    = errors.New("invalid or expired OAuth state")
+	ErrExchangeFailed  = errors.New("OAuth code exchange failed")
+	ErrIdentityFailed  = errors.New("could not fetch the identity from the provider")
+	ErrNoVerifiedEmail = errors.New("the provider account has no verified email")
+)
+
+// StateTTL is how long a user has to come back from the provider.
+const StateTTL = 10 * time.Minute
+
+// Identity is the provider account a user signed in with.
+type Identity struct {
+	Provider   string
+	ProviderID string
+	Email      string
+	Name       string
+	AvatarURL  string
+}
+
+// Provider is one identity provider.
+type Provider interface {
+	Name() string
+	// AuthCodeURL is where the browser is sent to sign in.
+	AuthCodeURL(state string, challenge string) string
+	// Exchange trades the code for an access token and fetches the identity.
+	Exchange(ctx context.Context, code string, verifier string) (Identity, error)
+}
+
+// Flow is a pending sign in, remembered between the redirect to the provider
+// and the callback.
+type Flow struct {
+	Provider string
+	Verifier string
+	// LinkUserID is set when a signed in user links the provider account to
+	// their own account instead of signing in.
+	LinkUserID uuid.UUID
+	// ClientRedirect is where the callback sends the tokens, if anywhere.
+	ClientRedirect string
+	ExpiresAt      time.Time
+}
+
+// StateStore keeps pending flows by their state parameter. Each state can
+// only be used once.
+type StateStore struct {
+	mu    sync.Mutex
+	flows map[string]Flow
+}
+
+func NewStateStore() *StateStore {
+	return &StateStore{flows: make(map[string]Flow)}
+}
+
+// Begin stores flow and returns its state and PKCE challenge.
+func (s *StateStore) Begin(flow Flow) (string, string, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	flow.Verifier, err = randomString()
+	if err != nil {
+		return "", "", err
+	}
+	flow.ExpiresAt = time.Now().Add(StateTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, pending := range s.flows {
+		if now.After(pending.ExpiresAt) {
+			delete(s.flows, key)
+		}
+	}
+	s.flows[state] = flow
+	return state, Challenge(flow.Verifier), nil
+}
+
+// Complete returns and forgets the flow of state.
+func (s *StateStore) Complete(provider string, state string) (Flow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flow, ok := s.flows[state]
+	delete(s.flows, state)
+	if !ok || flow.Provider != provider || time.Now().After(flow.ExpiresAt) {
+		return Flow{}, ErrInvalidState
+	}
+	return flow, nil
+}
+
+// Challenge is the S256 PKCE challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
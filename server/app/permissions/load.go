@@ -0,0 +1,236 @@
+package permissions
+
+import (
+	"app/db"
+	"app/db/models"
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrServerNotFound  = errors.New("server not found")
+	ErrChannelNotFound = errors.New("channel not found")
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrReportNotFound  = errors.New("report not found")
+)
+
+// LoadMember reads the roles of userID on serverID and their powers.
+func LoadMember(userID uuid.UUID, serverID uuid.UUID) (Member, error) {
+	member := Member{UserID: userID, ServerID: serverID}
+
+	var server models.Server
+	if err := db.GetDB().Select("id", "user_id").First(&server, "id = ?", serverID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return member, ErrServerNotFound
+		}
+		return member, err
+	}
+
+	var count int64
+	if err := db.GetDB().Model(&models.OnServer{}).Where("user_id = ? AND server_id = ?", userID, serverID).Count(&count).Error; err != nil {
+		return member, err
+	}
+	if count == 0 {
+		return member, nil
+	}
+	member.IsMember = true
+	member.IsOwner = server.UserID == userID
+
+	var roles []models.Role
+	if err := db.GetDB().Joins("JOIN role_users ON role_users.role_id = roles.id AND role_users.deleted_at IS NULL").
+		Where("role_users.user_id = ? AND roles.server_id = ?", userID, serverID).
+		Find(&roles).Error; err != nil {
+		return member, err
+	}
+	if len(roles) == 0 {
+		return member, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.ID)
+	}
+	var powers []struct {
+		RoleID uuid.UUID
+		Label  string
+		Power  int
+	}
+	if err := db.GetDB().Table("role_permissions").
+		Select("role_permissions.role_id, permissions.label, role_permissions.power").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permissions_id").
+		Where("role_permissions.role_id IN ? AND role_permissions.deleted_at IS NULL", ids).
+		Scan(&powers).Error; err != nil {
+		return member, err
+	}
+
+	byRole := make(map[uuid.UUID]map[string]int, len(roles))
+	for _, power := range powers {
+		if byRole[power.RoleID] == nil {
+			byRole[power.RoleID] = make(map[string]int)
+		}
+		byRole[power.RoleID][power.Label] = power.Power
+	}
+	for _, role := range roles {
+		member.Roles = append(member.Roles, Role{
+			ID:       role.ID,
+			Label:    role.Label,
+			Position: role.Position,
+			Powers:   byRole[role.ID],
+		})
+	}
+	return member, nil
+}
+
+// LoadChannels reads the thresholds and overwrites of channels.
+func LoadChannels(channels []models.Channel) (map[uuid.UUID]*Channel, error) {
+	result := make(map[uuid.UUID]*Channel, len(channels))
+	if len(channels) == 0 {
+		return result, nil
+	}
+	ids := make([]uuid.UUID, 0, len(channels))
+	for _, channel := range channels {
+		ids = append(ids, channel.ID)
+		result[channel.ID] = &Channel{ID: channel.ID, ServerID: channel.ServerID, Thresholds: make(map[string]int)}
+	}
+
+	var thresholds []struct {
+		ChannelID uuid.UUID
+		Label     string
+		Power     int
+	}
+	if err := db.GetDB().Table("channel_channel_permissions").
+		Select("channel_channel_permissions.channel_id, channel_permissions.label, channel_channel_permissions.power").
+		Joins("JOIN channel_permissions ON channel_permissions.id = channel_channel_permissions.channel_permission_id").
+		Where("channel_channel_permissions.channel_id IN ? AND channel_channel_permissions.deleted_at IS NULL", ids).
+		Scan(&thresholds).Error; err != nil {
+		return nil, err
+	}
+	for _, threshold := range thresholds {
+		result[threshold.ChannelID].Thresholds[threshold.Label] = threshold.Power
+	}
+
+	var overwrites []models.ChannelOverwrite
+	if err := db.GetDB().Where("channel_id IN ?", ids).Order("created_at").Find(&overwrites).Error; err != nil {
+		return nil, err
+	}
+	for _, overwrite := range overwrites {
+		result[overwrite.ChannelID].Overwrites = append(result[overwrite.ChannelID].Overwrites, toOverwrite(overwrite))
+	}
+	return result, nil
+}
+
+// LoadChannel reads one channel with its thresholds and overwrites.
+func LoadChannel(channelID uuid.UUID) (*Channel, error) {
+	var channel models.Channel
+	if err := db.GetDB().First(&channel, "id = ?", channelID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrChannelNotFound
+		}
+		return nil, err
+	}
+	channels, err := LoadChannels([]models.Channel{channel})
+	if err != nil {
+		return nil, err
+	}
+	return channels[channel.ID], nil
+}
+
+// RoleServer returns the server that roleID belongs to.
+func RoleServer(roleID uuid.UUID) (uuid.UUID, error) {
+	var role models.Role
+	if err := db.GetDB().Select("id", "server_id").First(&role, "id = ?", roleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return uuid.Nil, ErrRoleNotFound
+		}
+		return uuid.Nil, err
+	}
+	return role.ServerID, nil
+}
+
+// ReportServer returns the server that reportID was made on.
+func ReportServer(reportID uuid.UUID) (uuid.UUID, error) {
+	var report models.Report
+	if err := db.GetDB().Select("id", "server_id").First(&report, "id = ?", reportID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return uuid.Nil, ErrReportNotFound
+		}
+		return uuid.Nil, err
+	}
+	return report.ServerID, nil
+}
+
+// GroupMember tells whether userID belongs to the group or DM that owns
+// channelID. Those channels have no server, so no roles either.
+func GroupMember(userID uuid.UUID, channelID uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.GetDB().Model(&models.GroupMember{}).
+		Joins("JOIN groups ON groups.id = group_members.group_id").
+		Where("groups.channel_id = ? AND group_members.user_id = ?", channelID, userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Check decides whether userID has permission on serverID.
+func Check(userID uuid.UUID, serverID uuid.UUID, permission string) (Decision, error) {
+	member, err := LoadMember(userID, serverID)
+	if err != nil {
+		return Decision{Permission: permission}, err
+	}
+	return member.Evaluate(permission, nil), nil
+}
+
+// CheckChannel decides whether userID has permission in channelID, on the
+// server the channel belongs to.
+func CheckChannel(userID uuid.UUID, channelID uuid.UUID, permission string) (Decision, error) {
+	channel, err := LoadChannel(channelID)
+	if err != nil {
+		return Decision{Permission: permission}, err
+	}
+	member, err := LoadMember(userID, channel.ServerID)
+	if err != nil {
+		return Decision{Permission: permission}, err
+	}
+	return member.Evaluate(permission, channel), nil
+}
+
+// Explain decides each of perms for userID on serverID, in channelID if it
+// is not nil, the same way Check and CheckChannel do.
+func Explain(userID uuid.UUID, serverID uuid.UUID, channelID *uuid.UUID, perms []string) (Explanation, error) {
+	explanation := Explanation{UserID: userID, ServerID: serverID, ChannelID: channelID, Decisions: []Decision{}}
+
+	var channel *Channel
+	if channelID != nil {
+		var err error
+		if channel, err = LoadChannel(*channelID); err != nil {
+			return explanation, err
+		}
+		if channel.ServerID != serverID {
+			return explanation, ErrChannelNotFound
+		}
+	}
+
+	member, err := LoadMember(userID, serverID)
+	if err != nil {
+		return explanation, err
+	}
+	explanation.IsMember, explanation.IsOwner = member.IsMember, member.IsOwner
+	for _, permission := range perms {
+		explanation.Decisions = append(explanation.Decisions, member.Evaluate(permission, channel))
+	}
+	return explanation, nil
+}
+
+func toOverwrite(overwrite models.ChannelOverwrite) Overwrite {
+	result := Overwrite{ID: overwrite.ID, Permission: overwrite.Permission, Allow: overwrite.Allow}
+	if overwrite.RoleID != nil {
+		result.RoleID = *overwrite.RoleID
+	}
+	if overwrite.UserID != nil {
+		result.UserID = *overwrite.UserID
+	}
+	return result
+}
@@ -0,0 +1,258 @@
+// Package permissions computes what a member may do on a server or in one of
+// its channels, from all of their roles and the channel overwrites. Every
+// middleware and service checks permissions through it, so the HTTP API and
+// the WebSockets always agree.
+package permissions
+
+import (
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// Admin is not a stored permission: it is granted by a role labelled admin.
+const Admin = "admin"
+
+// AdminRole is the label of the role created with every server.
+const AdminRole = "admin"
+
+// Membership is not a stored permission either: every member of the server
+// has it, whatever their roles.
+const Membership = "member"
+
+// Channel permissions have a power from 0 to 99 compared with the threshold
+// of each channel. Every other permission is a boolean, granted by a power
+// of at least 1.
+var ChannelPermissions = map[string]bool{
+	"sendMessage":   true,
+	"accessChannel": true,
+	"editChannel":   true,
+}
+
+// Reasons of a Decision.
+const (
+	ReasonOwner         = "owner"
+	ReasonMember        = "member"
+	ReasonNotMember     = "not_member"
+	ReasonRoles         = "roles"
+	ReasonNoThreshold   = "no_threshold"
+	ReasonRoleOverwrite = "role_overwrite"
+	ReasonUserOverwrite = "user_overwrite"
+)
+
+// Role is one role of a member with the power it gives for each permission.
+type Role struct {
+	ID       uuid.UUID
+	Label    string
+	Position int
+	Powers   map[string]int
+}
+
+// Overwrite allows or denies a permission in a channel to a role or to a
+// single user, whatever their roles give.
+type Overwrite struct {
+	ID         uuid.UUID
+	RoleID     uuid.UUID
+	UserID     uuid.UUID
+	Permission string
+	Allow      bool
+}
+
+// Channel holds what a decision needs to know about a channel.
+type Channel struct {
+	ID         uuid.UUID
+	ServerID   uuid.UUID
+	Thresholds map[string]int
+	Overwrites []Overwrite
+}
+
+// Member is a user on a server. A user who is not on the server is a Member
+// without roles and with IsMember false.
+type Member struct {
+	UserID   uuid.UUID
+	ServerID uuid.UUID
+	IsMember bool
+	IsOwner  bool
+	Roles    []Role
+}
+
+// RoleContribution is what one role gives towards a decision.
+type RoleContribution struct {
+	RoleID   uuid.UUID `json:"role_id"`
+	Label    string    `json:"label"`
+	Position int       `json:"position"`
+	Power    int       `json:"power"`
+	Grants   bool      `json:"grants"`
+}
+
+// AppliedOverwrite is the overwrite that settled a decision.
+type AppliedOverwrite struct {
+	ID     uuid.UUID `json:"id"`
+	Kind   string    `json:"kind"`
+	Target uuid.UUID `json:"target_id"`
+	Allow  bool      `json:"allow"`
+}
+
+// Decision is the outcome of a check with the details of how it was reached.
+type Decision struct {
+	Permission string             `json:"permission"`
+	Allowed    bool               `json:"allowed"`
+	Reason     string             `json:"reason"`
+	Power      int                `json:"power"`
+	Threshold  *int               `json:"threshold,omitempty"`
+	Roles      []RoleContribution `json:"roles"`
+	Overwrite  *AppliedOverwrite  `json:"overwrite,omitempty"`
+}
+
+// Explanation is every decision for a member on a server, in a channel if
+// ChannelID is set.
+type Explanation struct {
+	UserID    uuid.UUID  `json:"user_id"`
+	ServerID  uuid.UUID  `json:"server_id"`
+	ChannelID *uuid.UUID `json:"channel_id,omitempty"`
+	IsMember  bool       `json:"is_member"`
+	IsOwner   bool       `json:"is_owner"`
+	Decisions []Decision `json:"decisions"`
+}
+
+// HighestPosition is the position of the highest role of m, -1 without any.
+func (m Member) HighestPosition() int {
+	highest := -1
+	for _, role := range m.Roles {
+		highest = max(highest, role.Position)
+	}
+	return highest
+}
+
+// Rank places m in the hierarchy of the server. The owner ranks above every
+// role, and a member without roles below all of them.
+func (m Member) Rank() int {
+	if m.IsOwner {
+		return math.MaxInt
+	}
+	return m.HighestPosition()
+}
+
+// Outranks tells whether m may kick, ban or change the roles of target, who
+// must rank strictly below m.
+func (m Member) Outranks(target Member) bool {
+	return m.IsMember && target.UserID != m.UserID && m.Rank() > target.Rank()
+}
+
+// CanManageRole tells whether m may edit, assign or remove a role at
+// position, which must be below the highest role of m.
+func (m Member) CanManageRole(position int) bool {
+	return m.IsMember && position < m.Rank()
+}
+
+// CanGrant tells whether m may set power for permission on a role: no more
+// than their own roles give them. The owner may give any power.
+func (m Member) CanGrant(permission string, power int) bool {
+	return m.IsMember && (m.IsOwner || power <= m.Evaluate(permission, nil).Power)
+}
+
+// Evaluate decides whether m has permission, in channel if it is not nil.
+//
+// The owner of the server may do anything. Otherwise the roles are combined:
+// the highest power of all roles counts, and must reach the threshold of the
+// channel for channel permissions or be at least 1 for the others. In a
+// channel, the overwrite of the highest role of m that has one for the
+// permission then replaces that result, allow winning over deny between roles
+// at the same position, and an overwrite for m itself comes last.
+func (m Member) Evaluate(permission string, channel *Channel) Decision {
+	decision := Decision{Permission: permission, Roles: []RoleContribution{}}
+
+	roles := append([]Role(nil), m.Roles...)
+	sort.SliceStable(roles, func(i, j int) bool { return roles[i].Position > roles[j].Position })
+
+	threshold := 1
+	if channel != nil && ChannelPermissions[permission] {
+		limit, ok := channel.Thresholds[permission]
+		if ok {
+			threshold = limit
+			decision.Threshold = &limit
+		} else {
+			threshold = -1
+		}
+	}
+
+	for _, role := range roles {
+		power := role.Powers[permission]
+		grants := threshold >= 0 && power >= threshold
+		if permission == Admin {
+			power, grants = 0, role.Label == AdminRole
+			if grants {
+				power = 1
+			}
+		}
+		decision.Roles = append(decision.Roles, RoleContribution{
+			RoleID:   role.ID,
+			Label:    role.Label,
+			Position: role.Position,
+			Power:    power,
+			Grants:   grants,
+		})
+		decision.Power = max(decision.Power, power)
+		decision.Allowed = decision.Allowed || grants
+	}
+
+	switch {
+	case !m.IsMember:
+		decision.Allowed, decision.Reason = false, ReasonNotMember
+		return decision
+	case m.IsOwner:
+		decision.Allowed, decision.Reason = true, ReasonOwner
+		return decision
+	case permission == Membership:
+		decision.Allowed, decision.Reason = true, ReasonMember
+		return decision
+	case threshold < 0:
+		decision.Allowed, decision.Reason = false, ReasonNoThreshold
+		return decision
+	default:
+		decision.Reason = ReasonRoles
+	}
+
+	if channel == nil || permission == Admin {
+		return decision
+	}
+
+	if overwrite, ok := m.roleOverwrite(permission, channel); ok {
+		decision.Allowed, decision.Reason = overwrite.Allow, ReasonRoleOverwrite
+		decision.Overwrite = &AppliedOverwrite{ID: overwrite.ID, Kind: "role", Target: overwrite.RoleID, Allow: overwrite.Allow}
+	}
+	for _, overwrite := range channel.Overwrites {
+		if overwrite.UserID != uuid.Nil && overwrite.UserID == m.UserID && overwrite.Permission == permission {
+			decision.Allowed, decision.Reason = overwrite.Allow, ReasonUserOverwrite
+			decision.Overwrite = &AppliedOverwrite{ID: overwrite.ID, Kind: "user", Target: overwrite.UserID, Allow: overwrite.Allow}
+			break
+		}
+	}
+	return decision
+}
+
+// roleOverwrite returns the overwrite of the highest role of m for
+// permission in channel.
+func (m Member) roleOverwrite(permission string, channel *Channel) (Overwrite, bool) {
+	positions := make(map[uuid.UUID]int, len(m.Roles))
+	for _, role := range m.Roles {
+		positions[role.ID] = role.Position
+	}
+
+	var found Overwrite
+	best, ok := 0, false
+	for _, overwrite := range channel.Overwrites {
+		if overwrite.RoleID == uuid.Nil || overwrite.Permission != permission {
+			continue
+		}
+		position, held := positions[overwrite.RoleID]
+		if !held {
+			continue
+		}
+		if !ok || position > best || (position == best && overwrite.Allow && !found.Allow) {
+			found, best, ok = overwrite, position, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,170 @@
+// Package policy declares who may call each route of the API. Routes are
+// registered through a Router, which runs the checks of their policy before
+// any other handler and refuses to register a route that has no policy.
+package policy
+
+import (
+	"app/controllers"
+	"app/permissions"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scope tells where the permission of a policy is checked.
+type Scope int
+
+const (
+	NoScope Scope = iota
+	// ServerScope is the server of the id parameter, or serverId in the body.
+	ServerScope
+	// ChannelScope is the channel of the id parameter, on its own server.
+	ChannelScope
+	// RoleScope is the server of the role of the id parameter.
+	RoleScope
+	// ReportScope is the server of the report of the id parameter.
+	ReportScope
+)
+
+// Policy is what a caller needs to reach a route.
+type Policy struct {
+	// Auth is the account role required, "user" or "admin". A public route
+	// has none.
+	Auth string
+	// Self requires the id parameter to be the caller, unless they are a site
+	// admin.
+	Self bool
+	// Scope and Permission are the server permission required, if any.
+	Scope      Scope
+	Permission string
+}
+
+// Public routes are open to anyone. WebSockets and routes taking a token in
+// their body are public here and check it themselves.
+func Public() Policy { return Policy{} }
+
+// User routes need a valid access token. The handler may check more.
+func User() Policy { return Policy{Auth: "user"} }
+
+// Admin routes are for site admins.
+func Admin() Policy { return Policy{Auth: "admin"} }
+
+// Self routes are for the user of the id parameter.
+func Self() Policy { return Policy{Auth: "user", Self: true} }
+
+// Member routes are for the members of the server of the route.
+func Member() Policy { return Server(permissions.Membership) }
+
+// Server routes need permission on the server of the route.
+func Server(permission string) Policy {
+	return Policy{Auth: "user", Scope: ServerScope, Permission: permission}
+}
+
+// Channel routes need permission in the channel of the route.
+func Channel(permission string) Policy {
+	return Policy{Auth: "user", Scope: ChannelScope, Permission: permission}
+}
+
+// Role routes need permission on the server of the role of the route.
+func Role(permission string) Policy {
+	return Policy{Auth: "user", Scope: RoleScope, Permission: permission}
+}
+
+// Report routes need permission on the server of the report of the route.
+func Report(permission string) Policy {
+	return Policy{Auth: "user", Scope: ReportScope, Permission: permission}
+}
+
+// Handlers are the middlewares enforcing p. The permission middlewares
+// authenticate the caller themselves.
+func (p Policy) Handlers() []gin.HandlerFunc {
+	switch p.Scope {
+	case ServerScope:
+		return []gin.HandlerFunc{controllers.PermissionMiddleware(p.Permission)}
+	case ChannelScope:
+		return []gin.HandlerFunc{controllers.PermissionChannelMiddleware(p.Permission)}
+	case RoleScope:
+		return []gin.HandlerFunc{controllers.PermissionRoleMiddleware(p.Permission)}
+	case ReportScope:
+		return []gin.HandlerFunc{controllers.PermissionReportMiddleware(p.Permission)}
+	}
+
+	var handlers []gin.HandlerFunc
+	if p.Auth != "" {
+		handlers = append(handlers, controllers.TokenAuthMiddleware(p.Auth))
+	}
+	if p.Self {
+		handlers = append(handlers, controllers.IsOwner())
+	}
+	return handlers
+}
+
+// Table maps "METHOD /path" to the policy of each route.
+type Table map[string]Policy
+
+// Key is the entry of a route in a Table.
+func Key(method string, path string) string {
+	return method + " " + path
+}
+
+// Lookup returns the policy of a route.
+func (t Table) Lookup(method string, path string) (Policy, bool) {
+	p, ok := t[Key(method, path)]
+	return p, ok
+}
+
+// Check returns an error naming every route of routes without a policy, for
+// the routes registered on the engine without a Router.
+func (t Table) Check(routes gin.RoutesInfo) error {
+	var missing []string
+	for _, route := range routes {
+		if _, ok := t.Lookup(route.Method, route.Path); !ok {
+			missing = append(missing, Key(route.Method, route.Path))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("routes without policy: %s", strings.Join(missing, ", "))
+}
+
+// Router registers routes with the policy a Table gives them.
+type Router struct {
+	engine *gin.Engine
+	table  Table
+}
+
+// Router returns a Router registering on engine.
+func (t Table) Router(engine *gin.Engine) *Router {
+	return &Router{engine: engine, table: t}
+}
+
+// Handle registers a route behind its policy. Like gin does for conflicting
+// routes, it panics when the route has no policy so the server never starts
+// with an unguarded route.
+func (r *Router) Handle(method string, path string, handlers ...gin.HandlerFunc) {
+	p, ok := r.table.Lookup(method, path)
+	if !ok {
+		panic(fmt.Sprintf("policy: no policy for %s", Key(method, path)))
+	}
+	r.engine.Handle(method, path, append(p.Handlers(), handlers...)...)
+}
+
+func (r *Router) GET(path string, handlers ...gin.HandlerFunc) {
+	r.Handle("GET", path, handlers...)
+}
+
+func (r *Router) POST(path string, handlers ...gin.HandlerFunc) {
+	r.Handle("POST", path, handlers...)
+}
+
+func (r *Router) PUT(path string, handlers ...gin.HandlerFunc) {
+	r.Handle("PUT", path, handlers...)
+}
+
+func (r *Router) DELETE(path string, handlers ...gin.HandlerFunc) {
+	r.Handle("DELETE", path, handlers...)
+}
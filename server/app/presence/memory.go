@@ -0,0 +1,52 @@
+package presence
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is the in-process Store used by default.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	users map[uuid.UUID]map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{users: make(map[uuid.UUID]map[string]bool)}
+}
+
+func (m *MemoryStore) Set(userID uuid.UUID, connID string, idle bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.users[userID] == nil {
+		m.users[userID] = make(map[string]bool)
+	}
+	m.users[userID][connID] = idle
+	return nil
+}
+
+func (m *MemoryStore) Remove(userID uuid.UUID, connID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users[userID], connID)
+	if len(m.users[userID]) == 0 {
+		delete(m.users, userID)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Status(userID uuid.UUID) (Status, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conns := m.users[userID]
+	if len(conns) == 0 {
+		return Offline, nil
+	}
+	for _, idle := range conns {
+		if !idle {
+			return Online, nil
+		}
+	}
+	return Idle, nil
+}
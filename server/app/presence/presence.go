@@ -0,0 +1,115 @@
+package presence
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+type Status string
+
+const (
+	Online  Status = "online"
+	Idle    Status = "idle"
+	Offline Status = "offline"
+)
+
+// Store keeps the live connections of every user. A user is online when at
+// least one connection is active, idle when all of them are idle and offline
+// when there is none. Implementations must be safe for concurrent use.
+type Store interface {
+	Set(userID uuid.UUID, connID string, idle bool) error
+	Remove(userID uuid.UUID, connID string) error
+	Status(userID uuid.UUID) (Status, error)
+}
+
+// Tracker reports the status transitions caused by connections coming and
+// going so callers only broadcast real changes.
+type Tracker struct {
+	mu    sync.Mutex
+	store Store
+}
+
+var (
+	defaultTracker *Tracker
+	once           sync.Once
+)
+
+// GetTracker returns the process wide tracker, backed by memory unless
+// SetStore was called first.
+func GetTracker() *Tracker {
+	once.Do(func() {
+		defaultTracker = NewTracker(NewMemoryStore())
+	})
+	return defaultTracker
+}
+
+func NewTracker(store Store) *Tracker {
+	return &Tracker{store: store}
+}
+
+// SetStore swaps the backend, e.g. for a shared one when running several
+// instances.
+func (t *Tracker) SetStore(store Store) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.store = store
+}
+
+// Update records activity on connID and returns the user status and whether
+// it changed.
+func (t *Tracker) Update(userID uuid.UUID, connID string, idle bool) (Status, bool, error) {
+	return t.apply(userID, func() error {
+		return t.store.Set(userID, connID, idle)
+	})
+}
+
+// Disconnect forgets connID and returns the user status and whether it
+// changed.
+func (t *Tracker) Disconnect(userID uuid.UUID, connID string) (Status, bool, error) {
+	return t.apply(userID, func() error {
+		return t.store.Remove(userID, connID)
+	})
+}
+
+func (t *Tracker) Status(userID uuid.UUID) Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, err := t.store.Status(userID)
+	if err != nil {
+		return Offline
+	}
+	return status
+}
+
+func (t *Tracker) Statuses(userIDs []uuid.UUID) map[uuid.UUID]Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make(map[uuid.UUID]Status, len(userIDs))
+	for _, userID := range userIDs {
+		status, err := t.store.Status(userID)
+		if err != nil {
+			status = Offline
+		}
+		statuses[userID] = status
+	}
+	return statuses
+}
+
+func (t *Tracker) apply(userID uuid.UUID, change func() error) (Status, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	before, err := t.store.Status(userID)
+	if err != nil {
+		return Offline, false, err
+	}
+	if err := change(); err != nil {
+		return before, false, err
+	}
+	after, err := t.store.Status(userID)
+	if err != nil {
+		return before, false, err
+	}
+	return after, after != before, nil
+}
@@ -0,0 +1,381 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Version is the realtime protocol version spoken by this server. Frames that
+// carry another "v" are rejected.
+const Version = 1
+
+// Ops a client may send.
+const (
+	OpSubscribe   = "subscribe"
+	OpUnsubscribe = "unsubscribe"
+	OpMessageSend = "message_send"
+	OpResume      = "resume"
+	OpHeartbeat   = "heartbeat"
+	OpTypingStart = "typing_start"
+	OpReadAck     = "read_ack"
+	OpSignal      = "signal"
+	OpVoiceState  = "voice_state"
+)
+
+// OpEvent is the op of every frame sent by the server.
+const OpEvent = "event"
+
+// Event types sent by the server.
+const (
+	EventReady          = "ready"
+	EventResumed        = "resumed"
+	EventInvalidSession = "invalid_session"
+	EventHeartbeatAck   = "heartbeat_ack"
+	EventSubscribed     = "subscribed"
+	EventUnsubscribed   = "unsubscribed"
+	EventError          = "error"
+	EventMessageCreate  = "message_create"
+	EventMessageUpdate  = "message_update"
+	EventMessageDelete  = "message_delete"
+	EventThreadUpdate   = "thread_update"
+	EventReplyCreate    = "reply_create"
+	EventMessageAck     = "message_ack"
+	EventMessageNack    = "message_nack"
+	EventChannelCreate  = "channel_create"
+	EventMemberJoin     = "member_join"
+	EventMemberLeave    = "member_leave"
+	EventPresenceUpdate = "presence_update"
+	EventTypingStart    = "typing_start"
+	EventReadAck        = "read_ack"
+	EventMessageSeen    = "message_seen"
+	EventSignal         = "signal"
+	EventVoiceJoin      = "voice_join"
+	EventVoiceLeave     = "voice_leave"
+	EventVoiceState     = "voice_state_update"
+	EventVoiceMove      = "voice_move"
+	EventRecordingStart = "recording_start"
+	EventRecordingStop  = "recording_stop"
+	EventVoiceReady     = "voice_ready"
+	EventSessionRevoked = "session_revoked"
+)
+
+// Error codes carried by EventError.
+const (
+	CodeInvalidFrame       = "invalid_frame"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownOp          = "unknown_op"
+	CodeInvalidPayload     = "invalid_payload"
+	CodeForbidden          = "forbidden"
+	CodeIdentityMismatch   = "identity_mismatch"
+	CodeNotFound           = "not_found"
+	CodeInternal           = "internal_error"
+)
+
+var (
+	ErrInvalidFrame       = errors.New("frame is not valid JSON")
+	ErrUnsupportedVersion = fmt.Errorf("only protocol version %d is supported", Version)
+	ErrUnknownOp          = errors.New("unknown op")
+	ErrInvalidPayload     = errors.New("invalid payload")
+	ErrIdentityMismatch   = errors.New("frame claims another identity than the session")
+)
+
+var knownOps = map[string]bool{
+	OpSubscribe:   true,
+	OpUnsubscribe: true,
+	OpMessageSend: true,
+	OpResume:      true,
+	OpHeartbeat:   true,
+	OpTypingStart: true,
+	OpReadAck:     true,
+	OpSignal:      true,
+	OpVoiceState:  true,
+}
+
+// Frame is an inbound client frame. Data is decoded into the struct matching
+// Op with DecodeData.
+type Frame struct {
+	Op    string          `json:"op"`
+	V     int             `json:"v"`
+	Nonce string          `json:"nonce,omitempty"`
+	Data  json.RawMessage `json:"d,omitempty"`
+}
+
+// Event is an outbound server frame. Seq is stamped per connection by the hub.
+type Event struct {
+	Op   string      `json:"op"`
+	V    int         `json:"v"`
+	Seq  uint64      `json:"s,omitempty"`
+	Type string      `json:"t"`
+	Data interface{} `json:"d"`
+}
+
+func NewEvent(eventType string, data interface{}) Event {
+	return Event{Op: OpEvent, V: Version, Type: eventType, Data: data}
+}
+
+// Decode parses and validates the envelope of a client frame.
+func Decode(raw []byte) (Frame, error) {
+	var frame Frame
+	if err := json.Unmarshal(raw, &frame); err != nil {
+		return Frame{}, ErrInvalidFrame
+	}
+	if frame.V != Version {
+		return frame, ErrUnsupportedVersion
+	}
+	if !knownOps[frame.Op] {
+		return frame, ErrUnknownOp
+	}
+	return frame, nil
+}
+
+// DecodeData unmarshals the frame payload into v.
+func (f Frame) DecodeData(v interface{}) error {
+	if len(f.Data) == 0 {
+		return ErrInvalidPayload
+	}
+	if err := json.Unmarshal(f.Data, v); err != nil {
+		return ErrInvalidPayload
+	}
+	return nil
+}
+
+// ErrorCode maps a decoding error to the code sent back to the client.
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrUnsupportedVersion):
+		return CodeUnsupportedVersion
+	case errors.Is(err, ErrUnknownOp):
+		return CodeUnknownOp
+	case errors.Is(err, ErrInvalidPayload):
+		return CodeInvalidPayload
+	case errors.Is(err, ErrIdentityMismatch):
+		return CodeIdentityMismatch
+	default:
+		return CodeInvalidFrame
+	}
+}
+
+// Inbound payloads.
+
+type Subscribe struct {
+	Type string    `json:"type"`
+	ID   uuid.UUID `json:"id"`
+}
+
+// MessageSend is authored by the session user. UserID is optional and only
+// checked against the session, never used to pick the author.
+type MessageSend struct {
+	Content   string     `json:"content"`
+	Type      string     `json:"type"`
+	UserID    uuid.UUID  `json:"user_id,omitempty"`
+	ReplyToID *uuid.UUID `json:"reply_to_id,omitempty"`
+	ThreadID  *uuid.UUID `json:"thread_id,omitempty"`
+}
+
+func (m MessageSend) Validate() error {
+	if m.Content == "" || m.Type == "" {
+		return ErrInvalidPayload
+	}
+	return nil
+}
+
+// VerifyIdentity rejects frames that claim to come from someone other than
+// the authenticated session user.
+func (m MessageSend) VerifyIdentity(sessionUserID uuid.UUID) error {
+	if m.UserID != uuid.Nil && m.UserID != sessionUserID {
+		return ErrIdentityMismatch
+	}
+	return nil
+}
+
+type Resume struct {
+	SessionID string `json:"session_id"`
+	Seq       uint64 `json:"seq"`
+}
+
+// Heartbeat may tell the server the user went idle.
+type Heartbeat struct {
+	Idle bool `json:"idle"`
+}
+
+// TypingStart names the channel being typed in. Channel sockets may omit it.
+type TypingStart struct {
+	ChannelID uuid.UUID `json:"channel_id"`
+}
+
+// ReadAck marks everything up to MessageID as read on the socket's channel.
+type ReadAck struct {
+	MessageID uuid.UUID `json:"message_id"`
+}
+
+// VoiceStateUpdate sets the flags a user controls on their voice session.
+type VoiceStateUpdate struct {
+	SelfMute bool `json:"self_mute"`
+	SelfDeaf bool `json:"self_deaf"`
+}
+
+// Outbound payloads.
+
+type Ready struct {
+	SessionID string    `json:"session_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+type Resumed struct {
+	SessionID string `json:"session_id"`
+	Replayed  int    `json:"replayed"`
+}
+
+type HeartbeatAck struct {
+	At time.Time `json:"at"`
+}
+
+type Subscription struct {
+	Type string    `json:"type"`
+	ID   uuid.UUID `json:"id"`
+}
+
+type PresenceUpdate struct {
+	UserID uuid.UUID `json:"user_id"`
+	Status string    `json:"status"`
+}
+
+// Typing lasts until ExpiresAt unless the client sends typing_start again.
+type Typing struct {
+	ChannelID uuid.UUID `json:"channel_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ReadState is sent to the user's own sessions after a read ack, and as
+// message_seen to the other members of a group or DM.
+type ReadState struct {
+	ChannelID uuid.UUID `json:"channel_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	MessageID uuid.UUID `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// VoiceState is carried by voice_join, voice_leave and voice_state_update.
+type VoiceState struct {
+	ChannelID  uuid.UUID `json:"channel_id"`
+	ServerID   uuid.UUID `json:"server_id,omitempty"`
+	UserID     uuid.UUID `json:"user_id"`
+	SelfMute   bool      `json:"self_mute"`
+	SelfDeaf   bool      `json:"self_deaf"`
+	ServerMute bool      `json:"server_mute"`
+}
+
+// VoiceReady is the first event of a voice socket. The client configures its
+// peer connection with ICEServers before answering the server's offer.
+type VoiceReady struct {
+	ChannelID  uuid.UUID   `json:"channel_id"`
+	ICEServers []ICEServer `json:"ice_servers"`
+}
+
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// VoiceMove asks the client to reconnect its voice socket to ChannelID.
+type VoiceMove struct {
+	FromChannelID uuid.UUID `json:"from_channel_id"`
+	ChannelID     uuid.UUID `json:"channel_id"`
+}
+
+// Recording tells voice participants that ChannelID is being recorded. The
+// stop event lists the stored files and the message linking them.
+type Recording struct {
+	ChannelID uuid.UUID   `json:"channel_id"`
+	ServerID  uuid.UUID   `json:"server_id"`
+	UserID    uuid.UUID   `json:"user_id"`
+	StartedAt time.Time   `json:"started_at"`
+	Media     []uuid.UUID `json:"media,omitempty"`
+	MessageID *uuid.UUID  `json:"message_id,omitempty"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Nonce   string `json:"nonce,omitempty"`
+}
+
+// MessageAck confirms to the sender that the frame carrying Nonce was stored.
+type MessageAck struct {
+	Nonce   string  `json:"nonce,omitempty"`
+	Message Message `json:"message"`
+}
+
+// MessageNack tells the sender why the frame carrying Nonce was refused.
+type MessageNack struct {
+	Nonce   string `json:"nonce,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Author struct {
+	ID      uuid.UUID `json:"id"`
+	Pseudo  string    `json:"pseudo"`
+	Profile string    `json:"profile"`
+}
+
+type Message struct {
+	ID          uuid.UUID  `json:"id"`
+	ChannelID   uuid.UUID  `json:"channel_id"`
+	Content     string     `json:"content"`
+	Type        string     `json:"type"`
+	SentAt      time.Time  `json:"sent_at"`
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	ReplyToID   *uuid.UUID `json:"reply_to_id,omitempty"`
+	ThreadID    *uuid.UUID `json:"thread_id,omitempty"`
+	ReplyCount  int        `json:"reply_count,omitempty"`
+	LastReplyAt *time.Time `json:"last_reply_at,omitempty"`
+	User        Author     `json:"user"`
+}
+
+// ThreadUpdate announces new activity on the thread rooted at MessageID, or
+// the deletion of one of its replies. LastReplyAt is null once no reply is
+// left.
+type ThreadUpdate struct {
+	MessageID   uuid.UUID  `json:"message_id"`
+	ChannelID   uuid.UUID  `json:"channel_id"`
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+}
+
+// Reply notifies the author of ParentID that someone answered them.
+type Reply struct {
+	ParentID uuid.UUID `json:"parent_id"`
+	Message  Message   `json:"message"`
+}
+
+type MessageDelete struct {
+	ID        uuid.UUID `json:"id"`
+	ChannelID uuid.UUID `json:"channel_id"`
+}
+
+type Channel struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	ServerID uuid.UUID `json:"server_id"`
+}
+
+type Member struct {
+	ServerID uuid.UUID `json:"server_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	Pseudo   string    `json:"pseudo,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+}
+
+// SessionRevoked is the last event of every socket opened with a login
+// session that was just revoked.
+type SessionRevoked struct {
+	SessionID uuid.UUID `json:"session_id"`
+}
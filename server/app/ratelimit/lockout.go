@@ -0,0 +1,53 @@
+package ratelimit
+
+import "time"
+
+// Lockout refuses attempts on a key after Threshold failures in a row. The
+// first lock lasts Base and each further failure doubles it, up to Max.
+// Failures are forgotten after Window without any, or on success.
+type Lockout struct {
+	Name      string
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Window    time.Duration
+}
+
+// Duration is how long key stays locked after count failures in a row.
+func (l Lockout) Duration(count int) time.Duration {
+	if count < l.Threshold {
+		return 0
+	}
+	delay := l.Base
+	for i := l.Threshold; i < count && delay < l.Max; i++ {
+		delay *= 2
+	}
+	return min(delay, l.Max)
+}
+
+// Check returns how long key is still locked, zero if it is not.
+func (l Lockout) Check(key string, now time.Time) (time.Duration, error) {
+	failure, err := currentStore().Failures(l.Name+":"+key, l.Window, now)
+	if err != nil {
+		return 0, err
+	}
+	return l.remaining(failure, now), nil
+}
+
+// Fail records a failed attempt on key and returns how long it is now locked.
+func (l Lockout) Fail(key string, now time.Time) (time.Duration, error) {
+	failure, err := currentStore().Fail(l.Name+":"+key, l.Window, now)
+	if err != nil {
+		return 0, err
+	}
+	return l.remaining(failure, now), nil
+}
+
+// Succeed forgets the failures of key.
+func (l Lockout) Succeed(key string) error {
+	return currentStore().Reset(l.Name + ":" + key)
+}
+
+func (l Lockout) remaining(failure Failure, now time.Time) time.Duration {
+	return max(0, failure.Last.Add(l.Duration(failure.Count)).Sub(now))
+}
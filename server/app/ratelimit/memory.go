@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets and old failures are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+type failureEntry struct {
+	Failure
+	window time.Duration
+}
+
+// MemoryStore is the default Store. Limits are per process.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	failures  map[string]failureEntry
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]*bucket),
+		failures: make(map[string]failureEntry),
+	}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	rate := float64(limit.Burst) / float64(limit.Per)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(float64(limit.Burst), b.tokens+float64(elapsed)*rate)
+		b.updated = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, nil
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / rate)), nil
+}
+
+func (s *MemoryStore) Fail(key string, window time.Duration, now time.Time) (Failure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	entry := s.failures[key]
+	if now.Sub(entry.Last) > window {
+		entry = failureEntry{}
+	}
+	entry.Count++
+	entry.Last = now
+	entry.window = window
+	s.failures[key] = entry
+	return entry.Failure, nil
+}
+
+func (s *MemoryStore) Failures(key string, window time.Duration, now time.Time) (Failure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.failures[key]
+	if !ok || now.Sub(entry.Last) > window {
+		return Failure{}, nil
+	}
+	return entry.Failure, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+	return nil
+}
+
+// sweep drops full buckets and expired failures so the maps do not grow with
+// every IP ever seen.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.limit.Per {
+			delete(s.buckets, key)
+		}
+	}
+	for key, entry := range s.failures {
+		if now.Sub(entry.Last) > entry.window {
+			delete(s.failures, key)
+		}
+	}
+}
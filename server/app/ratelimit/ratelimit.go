@@ -0,0 +1,127 @@
+// Package ratelimit throttles requests with token buckets keyed by IP or by
+// user, and locks accounts out after repeated failed logins.
+package ratelimit
+
+import (
+	"app/helpers"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at Burst per Per.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// Failure is the failed attempts recorded for a key.
+type Failure struct {
+	Count int
+	Last  time.Time
+}
+
+// Store keeps buckets and failure counters. It may be shared by several
+// instances of the server, the default one only lives in memory.
+type Store interface {
+	// Take removes a token from the bucket key and returns how long to wait
+	// before retrying when there is none left.
+	Take(key string, limit Limit, now time.Time) (time.Duration, error)
+	// Fail records a failed attempt for key. Failures older than window are
+	// forgotten.
+	Fail(key string, window time.Duration, now time.Time) (Failure, error)
+	// Failures returns the failed attempts recorded for key.
+	Failures(key string, window time.Duration, now time.Time) (Failure, error)
+	// Reset forgets the failed attempts of key.
+	Reset(key string) error
+}
+
+var (
+	storeMu sync.RWMutex
+	store   Store = NewMemoryStore()
+)
+
+// SetStore replaces the store used by every policy and lockout.
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+func currentStore() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+// KeyFunc returns who a request is counted for.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client IP.
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests per signed in user. It must run after
+// TokenAuthMiddleware and falls back to the IP otherwise.
+func ByUser(c *gin.Context) string {
+	userID, err := helpers.GetLoggedInUserID(c)
+	if err != nil {
+		return ByIP(c)
+	}
+	return "user:" + userID.String()
+}
+
+// Policy limits one route.
+type Policy struct {
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+// PerIP allows burst requests per per for each IP on the route.
+func PerIP(name string, burst int, per time.Duration) gin.HandlerFunc {
+	return Middleware(Policy{Name: name, Limit: Limit{Burst: burst, Per: per}, Key: ByIP})
+}
+
+// PerUser allows burst requests per per for each user on the route.
+func PerUser(name string, burst int, per time.Duration) gin.HandlerFunc {
+	return Middleware(Policy{Name: name, Limit: Limit{Burst: burst, Per: per}, Key: ByUser})
+}
+
+// Middleware answers 429 with Retry-After once the bucket of the caller is
+// empty. If the store fails, the request goes through.
+func Middleware(policy Policy) gin.HandlerFunc {
+	if policy.Limit.Burst < 1 || policy.Limit.Per <= 0 {
+		panic("ratelimit: invalid limit for " + policy.Name)
+	}
+	return func(c *gin.Context) {
+		wait, err := currentStore().Take(policy.Name+":"+policy.Key(c), policy.Limit, time.Now())
+		if err != nil {
+			log.Println("Rate limiter store error:", err)
+			c.Next()
+			return
+		}
+		if wait > 0 {
+			TooManyRequests(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// TooManyRequests aborts with a 429 asking to retry after wait.
+func TooManyRequests(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", RetryAfter(wait))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+}
+
+// RetryAfter formats wait as a Retry-After value, in whole seconds rounded up.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
@@ -1,11 +1,19 @@
 package routes
 
 import (
+	"app/ratelimit"
 	"app/services"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func AuthV2Routes(r *gin.Engine) {
-	r.GET("/auth/github/callback", services.OAuthCallbackHandler("github"))
+	g := guard(r)
+
+	g.GET("/auth/github/login", ratelimit.PerIP("oauth-login", 20, time.Minute), services.OAuthLoginHandler("github"))
+	g.GET("/auth/github/callback", services.OAuthCallbackHandler("github"))
+	g.POST("/auth/github/link", services.OAuthLinkHandler("github"))
+	g.POST("/auth/refresh", ratelimit.PerIP("refresh", 30, time.Minute), services.RefreshHandler())
+	g.POST("/auth/logout", services.LogoutHandler())
 }
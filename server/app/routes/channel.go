@@ -9,14 +9,20 @@ import (
 )
 
 func ChannelRoutes(r *gin.Engine) {
-	r.GET("/channels", controllers.GetAll(func() interface{} { return &[]models.Channel{} }))
-	r.POST("/channels", controllers.PermissionMiddleware("createChannel"), controllers.Create(func() interface{} { return &models.Channel{} }), controllers.GenerateLogChannelMiddlaware("created"))
-	r.GET("/channels/:id", controllers.Get(func() interface{} { return &models.Channel{} }))
-	r.PUT("/channels/:id", controllers.PermissionChannelMiddleware("editChannel"), controllers.Update(func() interface{} { return &models.Channel{} }))
-	r.DELETE("/channels/:id", controllers.Delete(func() interface{} { return &models.Channel{} }), controllers.GenerateLogChannelMiddlaware("deleted"))
+	g := guard(r)
 
-	r.GET("/channels/:id/messages", services.GetChannelMessages())
-	r.GET("/users/:id/channels", services.GetUserChannels())
-	r.GET("/channels/:id/permissions", services.GetChannelPermissions)
-	r.PUT("/channels/:id/permissions", services.UpdateChannelPermissions)
+	g.GET("/channels", controllers.GetAll(func() interface{} { return &[]models.Channel{} }))
+	g.POST("/channels", services.CreateChannel(), controllers.GenerateLogChannelMiddlaware("created"))
+	g.GET("/channels/:id", controllers.Get(func() interface{} { return &models.Channel{} }))
+	g.PUT("/channels/:id", controllers.Update(func() interface{} { return &models.Channel{} }))
+	g.DELETE("/channels/:id", controllers.Delete(func() interface{} { return &models.Channel{} }), controllers.GenerateLogChannelMiddlaware("deleted"))
+
+	g.GET("/channels/:id/messages", services.GetChannelMessages())
+	g.POST("/channels/:id/ack", services.AckChannelHandler())
+	g.GET("/users/:id/channels", services.GetUserChannels())
+	g.GET("/channels/:id/permissions", services.GetChannelPermissions)
+	g.PUT("/channels/:id/permissions", services.UpdateChannelPermissions)
+	g.GET("/channels/:id/overwrites", services.GetChannelOverwrites())
+	g.PUT("/channels/:id/overwrites", services.PutChannelOverwrite())
+	g.DELETE("/channels/:id/overwrites/:overwriteID", services.DeleteChannelOverwriteHandler())
 }
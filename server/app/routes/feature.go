@@ -10,10 +10,12 @@ import (
 )
 
 func FeatureRoutes(r *gin.Engine) {
-	r.GET("/features", controllers.TokenAuthMiddleware("user"), controllers.GetAll(func() interface{} { return &[]models.Feature{} }))
-	r.GET("/features/:id", controllers.TokenAuthMiddleware("admin"), controllers.Get(func() interface{} { return &models.Feature{} }))
-	r.DELETE("/features/:id", controllers.TokenAuthMiddleware("admin"), controllers.Delete(func() interface{} { return &models.Feature{} }))
+	g := guard(r)
 
-	r.POST("/features", controllers.TokenAuthMiddleware("admin"), services.CreateFeature())
-	r.PUT("/features/:id", controllers.TokenAuthMiddleware("admin"), services.UpdateFeature())
+	g.GET("/features", controllers.GetAll(func() interface{} { return &[]models.Feature{} }))
+	g.GET("/features/:id", controllers.Get(func() interface{} { return &models.Feature{} }))
+	g.DELETE("/features/:id", controllers.Delete(func() interface{} { return &models.Feature{} }))
+
+	g.POST("/features", services.CreateFeature())
+	g.PUT("/features/:id", services.UpdateFeature())
 }
@@ -1,21 +1,23 @@
 package routes
 
 import (
-	"app/controllers"
-	"app/db/models"
+	"app/ratelimit"
 	"app/services"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func FriendRoutes(r *gin.Engine) {
-	r.DELETE("/friends/:id", controllers.TokenAuthMiddleware("user"), controllers.Delete(func() interface{} { return &models.Friend{} }))
+	g := guard(r)
 
-	r.POST("/friends/accept", controllers.TokenAuthMiddleware("user"), services.AcceptFriend())
-	r.POST("/friends/refuse", controllers.TokenAuthMiddleware("user"), services.RefuseFriend())
-	r.GET("/friends/search/:pseudo", controllers.TokenAuthMiddleware("user"), services.SearchUser())
-	r.GET("/friends/users/:id", controllers.TokenAuthMiddleware("user"), services.GetFriendsByUser())
-	r.GET("/friends/pending/:id", controllers.TokenAuthMiddleware("user"), services.GetPendingFriendsByUser())
-	r.GET("/friends/sent/:id", controllers.TokenAuthMiddleware("user"), controllers.IsOwner(), services.GetPendingFriendsFromUser())
-	r.POST("/friends/request", controllers.TokenAuthMiddleware("user"), services.CreateFriendRequest())
+	g.DELETE("/friends/:id", services.DeleteFriend())
+
+	g.POST("/friends/accept", services.AcceptFriend())
+	g.POST("/friends/refuse", services.RefuseFriend())
+	g.GET("/friends/search/:pseudo", ratelimit.PerUser("friend-search", 30, time.Minute), services.SearchUser())
+	g.GET("/friends/users/:id", services.GetFriendsByUser())
+	g.GET("/friends/pending/:id", services.GetPendingFriendsByUser())
+	g.GET("/friends/sent/:id", services.GetPendingFriendsFromUser())
+	g.POST("/friends/request", ratelimit.PerUser("friend-request", 20, time.Hour), services.CreateFriendRequest())
 }
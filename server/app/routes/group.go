@@ -9,10 +9,12 @@ import (
 )
 
 func GroupRoutes(r *gin.Engine) {
-	r.GET("/groups/:id", controllers.TokenAuthMiddleware("user"), controllers.IsGroupMemberMiddleware(), controllers.Get(func() interface{} { return &models.Group{} }, controllers.PreloadField{Association: "Members", Fields: []string{"id", "pseudo", "profile"}}, controllers.PreloadField{Association: "Channel"}))
+	g := guard(r)
 
-	r.POST("/groups/private/:userID", controllers.TokenAuthMiddleware("user"), services.CreateOrGetDM())
-	r.POST("groups/public/:userID", controllers.TokenAuthMiddleware("user"), services.CreatePublicGroup())
-	r.DELETE("/groups/:id/members/:userID", controllers.TokenAuthMiddleware("user"), controllers.IsGroupOwnerMiddleware(), services.RemoveGroupMember())
-	r.GET("/groups/users/:id", controllers.TokenAuthMiddleware("user"), controllers.IsOwner(), services.GetUserGroups())
+	g.GET("/groups/:id", controllers.IsGroupMemberMiddleware(), controllers.Get(func() interface{} { return &models.Group{} }, controllers.PreloadField{Association: "Members", Fields: []string{"id", "pseudo", "profile"}}, controllers.PreloadField{Association: "Channel"}))
+
+	g.POST("/groups/private/:userID", services.CreateOrGetDM())
+	g.POST("/groups/public/:userID", services.CreatePublicGroup())
+	g.DELETE("/groups/:id/members/:userID", controllers.IsGroupOwnerMiddleware(), services.RemoveGroupMember())
+	g.GET("/groups/users/:id", services.GetUserGroups())
 }
@@ -9,8 +9,10 @@ import (
 )
 
 func InvitationRoutes(r *gin.Engine) {
-	r.DELETE("/invitations/:id", controllers.TokenAuthMiddleware("user"), controllers.IsUserInInvitationMiddleware(), controllers.Delete(func() interface{} { return &models.Invitation{} }))
+	g := guard(r)
 
-	r.POST("/invitations/server/:id", controllers.TokenAuthMiddleware("user"), controllers.IsUserOnServerMiddleware(), services.SendInvitation(false))
-	r.GET("/invitations/user/:id", controllers.TokenAuthMiddleware("user"), controllers.IsOwner(), services.GetInvitationsByUser())
+	g.DELETE("/invitations/:id", controllers.IsUserInInvitationMiddleware(), controllers.Delete(func() interface{} { return &models.Invitation{} }))
+
+	g.POST("/invitations/server/:id", controllers.IsUserOnServerMiddleware(), services.SendInvitation(false))
+	g.GET("/invitations/user/:id", services.GetInvitationsByUser())
 }
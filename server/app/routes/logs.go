@@ -8,5 +8,7 @@ import (
 )
 
 func LogsRoutes(r *gin.Engine) {
-	r.GET("/logs", controllers.TokenAuthMiddleware("admin"), controllers.GetAll(func() interface{} { return &[]models.Logs{} }))
+	g := guard(r)
+
+	g.GET("/logs", controllers.GetAll(func() interface{} { return &[]models.Logs{} }))
 }
@@ -8,6 +8,8 @@ import (
 )
 
 func MediaRoutes(r *gin.Engine) {
-	r.GET("/medias", controllers.GetAll(func() interface{} { return &[]models.Media{} }))
-	r.POST("/upload", controllers.TokenAuthMiddleware("user"), services.UploadFile)
+	g := guard(r)
+
+	g.GET("/medias", controllers.GetAll(func() interface{} { return &[]models.Media{} }))
+	g.POST("/upload", services.UploadFile)
 }
@@ -9,11 +9,14 @@ import (
 )
 
 func MessageRoutes(r *gin.Engine) {
-	r.GET("/messages", controllers.GetAll(func() interface{} { return &[]models.Message{} }))
-	r.POST("/messages", controllers.Create(func() interface{} { return &models.Message{} }))
-	r.GET("/messages/:id", controllers.Get(func() interface{} { return &models.Message{} }))
-	r.PUT("/messages/:id", controllers.Update(func() interface{} { return &models.Message{} }))
-	r.DELETE("/messages/:id", controllers.Delete(func() interface{} { return &models.Message{} }))
+	g := guard(r)
 
-	r.GET("/messages/:id/reactions", services.GetMessageReactions())
+	g.GET("/messages", controllers.GetAll(func() interface{} { return &[]models.Message{} }))
+	g.POST("/messages", services.PostMessage())
+	g.GET("/messages/:id", services.GetMessage())
+	g.PUT("/messages/:id", services.UpdateMessage())
+	g.DELETE("/messages/:id", services.RemoveMessage())
+
+	g.GET("/messages/:id/reactions", services.GetMessageReactions())
+	g.GET("/messages/:id/thread", services.GetMessageThread())
 }
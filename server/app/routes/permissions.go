@@ -7,9 +7,11 @@ import (
 )
 
 func PermissionsRoutes(r *gin.Engine) {
-	r.GET("/permissions", controllers.GetAll(func() interface{} { return &[]models.Permissions{} }))
-	r.POST("/permissions", controllers.Create(func() interface{} { return &models.Permissions{} }))
-	r.GET("/permissions/:id", controllers.Get(func() interface{} { return &models.Permissions{} }))
-	r.PUT("/permissions/:id", controllers.Update(func() interface{} { return &models.Permissions{} }))
-	r.DELETE("/permissions/:id", controllers.Delete(func() interface{} { return &models.Permissions{} }))
+	g := guard(r)
+
+	g.GET("/permissions", controllers.GetAll(func() interface{} { return &[]models.Permissions{} }))
+	g.POST("/permissions", controllers.Create(func() interface{} { return &models.Permissions{} }))
+	g.GET("/permissions/:id", controllers.Get(func() interface{} { return &models.Permissions{} }))
+	g.PUT("/permissions/:id", controllers.Update(func() interface{} { return &models.Permissions{} }))
+	g.DELETE("/permissions/:id", controllers.Delete(func() interface{} { return &models.Permissions{} }))
 }
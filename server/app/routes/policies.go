@@ -0,0 +1,203 @@
+package routes
+
+import (
+	"app/policy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policies lists every route of the API with who may call it. A route that
+// is missing here cannot be registered.
+var Policies = policy.Table{
+	"GET /": policy.Public(),
+
+	// Auth
+	"GET /auth/github/login":    policy.Public(),
+	"GET /auth/github/callback": policy.Public(),
+	"POST /auth/github/link":    policy.User(),
+	"POST /auth/refresh":        policy.Public(),
+	"POST /auth/logout":         policy.Public(),
+
+	// Channels
+	"GET /channels":                                policy.Public(),
+	"POST /channels":                               policy.Server("createChannel"),
+	"GET /channels/:id":                            policy.Public(),
+	"PUT /channels/:id":                            policy.Channel("editChannel"),
+	"DELETE /channels/:id":                         policy.Channel("editChannel"),
+	"GET /channels/:id/messages":                   policy.Channel("accessChannel"),
+	"POST /channels/:id/ack":                       policy.User(),
+	"GET /users/:id/channels":                      policy.Self(),
+	"GET /channels/:id/permissions":                policy.Public(),
+	"PUT /channels/:id/permissions":                policy.Channel("editChannel"),
+	"GET /channels/:id/overwrites":                 policy.Channel("accessChannel"),
+	"PUT /channels/:id/overwrites":                 policy.Channel("editChannel"),
+	"DELETE /channels/:id/overwrites/:overwriteID": policy.Channel("editChannel"),
+
+	// Features
+	"GET /features":        policy.User(),
+	"GET /features/:id":    policy.Admin(),
+	"DELETE /features/:id": policy.Admin(),
+	"POST /features":       policy.Admin(),
+	"PUT /features/:id":    policy.Admin(),
+
+	// Friends
+	"DELETE /friends/:id":         policy.User(),
+	"POST /friends/accept":        policy.User(),
+	"POST /friends/refuse":        policy.User(),
+	"GET /friends/search/:pseudo": policy.User(),
+	"GET /friends/users/:id":      policy.User(),
+	"GET /friends/pending/:id":    policy.User(),
+	"GET /friends/sent/:id":       policy.Self(),
+	"POST /friends/request":       policy.User(),
+
+	// Groups
+	"GET /groups/:id":                    policy.User(),
+	"POST /groups/private/:userID":       policy.User(),
+	"POST /groups/public/:userID":        policy.User(),
+	"DELETE /groups/:id/members/:userID": policy.User(),
+	"GET /groups/users/:id":              policy.Self(),
+
+	// Invitations
+	"DELETE /invitations/:id":      policy.User(),
+	"POST /invitations/server/:id": policy.User(),
+	"GET /invitations/user/:id":    policy.Self(),
+
+	// Logs
+	"GET /logs": policy.Admin(),
+
+	// Medias
+	"GET /medias":  policy.Public(),
+	"POST /upload": policy.User(),
+
+	// Messages
+	"GET /messages":               policy.Admin(),
+	"POST /messages":              policy.User(),
+	"GET /messages/:id":           policy.User(), // accessChannel, checked by the handler
+	"PUT /messages/:id":           policy.User(),
+	"DELETE /messages/:id":        policy.User(),
+	"GET /messages/:id/reactions": policy.Public(),
+	"GET /messages/:id/thread":    policy.User(),
+
+	// Memberships are created by joining a server: the raw rows are for site
+	// admins.
+	"GET /onServers":        policy.Public(),
+	"POST /onServers":       policy.Admin(),
+	"GET /onServers/:id":    policy.Public(),
+	"PUT /onServers/:id":    policy.Admin(),
+	"DELETE /onServers/:id": policy.Admin(),
+
+	// Permissions
+	"GET /permissions":        policy.Public(),
+	"POST /permissions":       policy.Admin(),
+	"GET /permissions/:id":    policy.Public(),
+	"PUT /permissions/:id":    policy.Admin(),
+	"DELETE /permissions/:id": policy.Admin(),
+
+	// Reactions
+	"GET /reacts":               policy.Public(),
+	"DELETE /reactMessages/:id": policy.User(),
+	"POST /reactMessages":       policy.User(),
+
+	// Reports show the reported message even once deleted, with its revisions
+	"GET /reports":                      policy.Admin(),
+	"POST /reports":                     policy.User(),
+	"GET /reports/:id":                  policy.Report("accessReport"),
+	"PUT /reports/:id":                  policy.Report("accessReport"),
+	"DELETE /reports/:id":               policy.Report("accessReport"),
+	"GET /servers/:id/reports/finished": policy.Server("accessReport"),
+	"GET /servers/:id/reports/pending":  policy.Server("accessReport"),
+
+	// Roles
+	"GET /roles":                   policy.Public(),
+	"POST /roles":                  policy.Server("createRole"),
+	"GET /roles/:id":               policy.Public(),
+	"PUT /roles/:id":               policy.Role("createRole"),
+	"DELETE /roles/:id":            policy.Role("createRole"),
+	"GET /roles/server/:server_id": policy.User(),
+	"POST /roles/server/:id/add":   policy.Server("createRole"),
+	"GET /roles/:id/permissions":   policy.Public(),
+	"PUT /roles/:id/permissions":   policy.Role("createRole"),
+
+	// Servers
+	"GET /servers":                                         policy.Admin(),
+	"GET /servers/search":                                  policy.Public(),
+	"GET /servers/:id":                                     policy.Public(),
+	"PUT /servers/:id":                                     policy.Server("profileServer"),
+	"GET /servers/public/available/:id":                    policy.Public(),
+	"POST /servers/create":                                 policy.User(),
+	"POST /servers/:id/join":                               policy.User(),
+	"DELETE /servers/:id/leave":                            policy.User(),
+	"GET /servers/users/:id":                               policy.User(),
+	"GET /servers/:id/members":                             policy.Member(),
+	"GET /servers/:id/members/:userID/permissions":         policy.Server("createRole"),
+	"GET /servers/:id/channels":                            policy.User(),
+	"GET /servers/:id/logs":                                policy.Server("accessLog"),
+	"DELETE /servers/:id/kick/users/:userID":               policy.Server("kickUser"),
+	"GET /servers/:id/bans":                                policy.Server("banUser"),
+	"GET /servers/friend/:friendID":                        policy.User(),
+	"POST /servers/:id/ban/users/:userID":                  policy.Server("banUser"),
+	"DELETE /servers/:id/unban/users/:userID":              policy.Server("banUser"),
+	"DELETE /servers/:id":                                  policy.User(), // owner only, checked by the handler
+	"PUT /servers/:id/two-factor":                          policy.User(), // owner only, checked by the handler
+	"PUT /servers/:id/roles/positions":                     policy.Server("createRole"),
+	"POST /server/:serverID/setRole/:roleID":               policy.User(), // createRole, 2FA and hierarchy, checked by the handler
+	"DELETE /server/:serverID/roles/:roleID/users/:userID": policy.User(),
+
+	// Tags
+	"GET /tags/:id":    policy.Admin(),
+	"DELETE /tags/:id": policy.Admin(),
+	"POST /tags":       policy.User(),
+	"GET /tags":        policy.User(),
+	"PUT /tags/:id":    policy.Admin(),
+
+	// Users
+	"GET /users":                                policy.Admin(),
+	"GET /users/:id":                            policy.Self(),
+	"DELETE /users/:id":                         policy.Admin(),
+	"POST /register":                            policy.Public(),
+	"PUT /users/:id":                            policy.Self(),
+	"PUT /users/:id/admin-update":               policy.Admin(),
+	"POST /login":                               policy.Public(),
+	"POST /login/two-factor":                    policy.Public(),
+	"GET /verify/:token":                        policy.Public(),
+	"POST /verify/resend":                       policy.Public(),
+	"POST /password/forgot":                     policy.Public(),
+	"POST /password/reset":                      policy.Public(),
+	"PUT /users/:id/change-password":            policy.Self(),
+	"PUT /fcm-token":                            policy.User(),
+	"GET /users/pseudo/:pseudo":                 policy.User(),
+	"POST /users":                               policy.Admin(),
+	"GET /user/:userID/servers/:serverID/roles": policy.User(),
+	"GET /users/info/:id":                       policy.User(),
+	"GET /users/:id/sessions":                   policy.Self(),
+	"DELETE /users/:id/sessions":                policy.Self(),
+	"DELETE /users/:id/sessions/:sessionID":     policy.Self(),
+	"POST /users/:id/two-factor/enroll":         policy.Self(),
+	"POST /users/:id/two-factor/confirm":        policy.Self(),
+	"POST /users/:id/two-factor/disable":        policy.Self(),
+	"POST /users/:id/two-factor/recovery-codes": policy.Self(),
+	"POST /users/:id/force-logout":              policy.Admin(),
+
+	// Voice; the handlers check muteMembers, moveMembers and recordVoice
+	"GET /channels/:id/connect":            policy.Public(),
+	"POST /channels/:id/recording":         policy.User(),
+	"DELETE /channels/:id/recording":       policy.User(),
+	"GET /channels/:id/voice/stats":        policy.User(),
+	"PUT /servers/:id/voice/:userID/mute":  policy.User(),
+	"POST /servers/:id/voice/:userID/move": policy.User(),
+	"DELETE /servers/:id/voice/:userID":    policy.User(),
+
+	// WebSockets authenticate with the token of their query string
+	"GET /ws":                policy.Public(),
+	"GET /channels/:id/send": policy.Public(),
+
+	// Static files and documentation
+	"GET /swagger/*any":       policy.Public(),
+	"GET /uploads/*filepath":  policy.Public(),
+	"HEAD /uploads/*filepath": policy.Public(),
+}
+
+// guard registers the routes of a file on r with their policy.
+func guard(r *gin.Engine) *policy.Router {
+	return Policies.Router(r)
+}
@@ -8,5 +8,7 @@ import (
 )
 
 func ReactRoutes(r *gin.Engine) {
-	r.GET("/reacts", controllers.GetAll(func() interface{} { return &[]models.React{} }))
+	g := guard(r)
+
+	g.GET("/reacts", controllers.GetAll(func() interface{} { return &[]models.React{} }))
 }
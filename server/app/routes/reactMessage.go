@@ -1,13 +1,14 @@
 package routes
 
 import (
-	"app/controllers"
 	"app/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 func ReactMessageRoutes(r *gin.Engine) {
-	r.DELETE("/reactMessages/:id", controllers.TokenAuthMiddleware("user"), services.DeleteReactMessage())
-	r.POST("/reactMessages", controllers.TokenAuthMiddleware("user"), services.CreateReactMessage())
+	g := guard(r)
+
+	g.DELETE("/reactMessages/:id", services.DeleteReactMessage())
+	g.POST("/reactMessages", services.CreateReactMessage())
 }
@@ -8,12 +8,14 @@ import (
 )
 
 func ReportRoutes(r *gin.Engine) {
-	r.GET("/reports", controllers.GetAll(func() interface{} { return &[]models.Report{} }))
-	r.POST("/reports", controllers.Create(func() interface{} { return &models.Report{} }))
-	r.GET("/reports/:id", controllers.Get(func() interface{} { return &models.Report{} }))
-	r.PUT("/reports/:id", controllers.Update(func() interface{} { return &models.Report{} }))
-	r.DELETE("/reports/:id", controllers.Delete(func() interface{} { return &models.Report{} }))
+	g := guard(r)
 
-	r.GET("/servers/:id/reports/finished", services.GetFinishedReportsByServer())
-	r.GET("/servers/:id/reports/pending", services.GetPendingReportsByServer())
+	g.GET("/reports", controllers.GetAll(func() interface{} { return &[]models.Report{} }))
+	g.POST("/reports", services.CreateReport())
+	g.GET("/reports/:id", controllers.Get(func() interface{} { return &models.Report{} }))
+	g.PUT("/reports/:id", controllers.Update(func() interface{} { return &models.Report{} }))
+	g.DELETE("/reports/:id", controllers.Delete(func() interface{} { return &models.Report{} }))
+
+	g.GET("/servers/:id/reports/finished", services.GetFinishedReportsByServer())
+	g.GET("/servers/:id/reports/pending", services.GetPendingReportsByServer())
 }
@@ -0,0 +1,29 @@
+package routes
+
+import "github.com/gin-gonic/gin"
+
+// Register adds every route of the API to r.
+func Register(r *gin.Engine) {
+	/*models*/
+	MediaRoutes(r)
+	UserRoutes(r)
+	ChannelRoutes(r)
+	FriendRoutes(r)
+	InvitationRoutes(r)
+	LogsRoutes(r)
+	MessageRoutes(r)
+	OnServerRoutes(r)
+	PermissionsRoutes(r)
+	ReactRoutes(r)
+	ReactMessageRoutes(r)
+	ReportRoutes(r)
+	RoleRoutes(r)
+	ServerRoutes(r)
+	TagRoutes(r)
+	FeatureRoutes(r)
+	GroupRoutes(r)
+	/*workers*/
+	WebSocketRoutes(r)
+	AuthV2Routes(r)
+	VocalRoutes(r)
+}
@@ -9,23 +9,29 @@ import (
 )
 
 func ServerRoutes(r *gin.Engine) {
-	r.GET("/servers", controllers.TokenAuthMiddleware("admin"), services.GetAllServers())
-	r.GET("/servers/search", services.SearchServerByName())
-	r.GET("/servers/:id", services.GetServerByID())
-	r.PUT("/servers/:id", services.UpdateServerByID(), controllers.PermissionMiddleware("profileServer"))
-	r.GET("/servers/public/available/:id", services.GetPublicAvailableServers())
-	r.POST("/servers/create", controllers.TokenAuthMiddleware("user"), services.NewServer())
-	r.POST("/servers/:id/join", controllers.TokenAuthMiddleware("user"), controllers.GenerateLogMiddleware("joined"), services.JoinServer())
-	r.DELETE("/servers/:id/leave", controllers.TokenAuthMiddleware("user"), controllers.GenerateLogMiddleware("left"), services.LeaveServer())
-	r.GET("/servers/users/:id", controllers.TokenAuthMiddleware("user"), services.GetServersByUser())
-	r.GET("/servers/:id/members", services.GetServerMembers())
-	r.GET("/servers/:id/channels", controllers.TokenAuthMiddleware("user"), services.GetServerChannels())
-	r.GET("/servers/:id/logs", controllers.PermissionMiddleware("accessLog"), services.GetServerLogs())
-	r.DELETE("/servers/:id/kick/users/:userID", controllers.PermissionMiddleware("kickUser"), controllers.TokenAuthMiddleware("user"), services.KickUser())
-	r.GET("/servers/:id/bans", services.GetServerBans())
-	r.GET("/servers/friend/:friendID", controllers.TokenAuthMiddleware("user"), services.GetServersFriendNotIn())
-	r.POST("/servers/:id/ban/users/:userID", controllers.PermissionMiddleware("banUser"), controllers.TokenAuthMiddleware("user"), controllers.GenerateLogBanMiddlaware(), services.BanUser())
-	r.DELETE("/servers/:id/unban/users/:userID", controllers.TokenAuthMiddleware("user"), services.UnbanUser())
-	r.DELETE("/servers/:id", controllers.TokenAuthMiddleware("user"), services.DeleteServerByID())
-	r.POST("/server/:serverID/setRole/:roleID", services.SetRoleToUser)
+	g := guard(r)
+
+	g.GET("/servers", services.GetAllServers())
+	g.GET("/servers/search", services.SearchServerByName())
+	g.GET("/servers/:id", services.GetServerByID())
+	g.PUT("/servers/:id", services.UpdateServerByID())
+	g.GET("/servers/public/available/:id", services.GetPublicAvailableServers())
+	g.POST("/servers/create", services.NewServer())
+	g.POST("/servers/:id/join", controllers.GenerateLogMiddleware("joined"), services.JoinServer())
+	g.DELETE("/servers/:id/leave", controllers.GenerateLogMiddleware("left"), services.LeaveServer())
+	g.GET("/servers/users/:id", services.GetServersByUser())
+	g.GET("/servers/:id/members", services.GetServerMembers())
+	g.GET("/servers/:id/members/:userID/permissions", services.ExplainPermissions())
+	g.GET("/servers/:id/channels", services.GetServerChannels())
+	g.GET("/servers/:id/logs", services.GetServerLogs())
+	g.DELETE("/servers/:id/kick/users/:userID", services.KickUser())
+	g.GET("/servers/:id/bans", services.GetServerBans())
+	g.GET("/servers/friend/:friendID", services.GetServersFriendNotIn())
+	g.POST("/servers/:id/ban/users/:userID", controllers.GenerateLogBanMiddlaware(), services.BanUser())
+	g.DELETE("/servers/:id/unban/users/:userID", services.UnbanUser())
+	g.DELETE("/servers/:id", services.DeleteServerByID())
+	g.PUT("/servers/:id/two-factor", services.RequireServerTwoFactor())
+	g.POST("/server/:serverID/setRole/:roleID", services.SetRoleToUser)
+	g.PUT("/servers/:id/roles/positions", services.ReorderRoles())
+	g.DELETE("/server/:serverID/roles/:roleID/users/:userID", services.RemoveRoleFromUser)
 }
@@ -9,10 +9,12 @@ import (
 )
 
 func TagRoutes(r *gin.Engine) {
-	r.GET("/tags/:id", controllers.TokenAuthMiddleware("admin"), controllers.Get(func() interface{} { return &models.Tag{} }))
-	r.DELETE("/tags/:id", controllers.TokenAuthMiddleware("admin"), controllers.Delete(func() interface{} { return &models.Tag{} }))
+	g := guard(r)
 
-	r.POST("/tags", controllers.TokenAuthMiddleware("user"), services.CreateTag())
-	r.GET("/tags", controllers.TokenAuthMiddleware("user"), services.GetAllTags())
-	r.PUT("/tags/:id", controllers.TokenAuthMiddleware("admin"), services.UpdateTag())
+	g.GET("/tags/:id", controllers.Get(func() interface{} { return &models.Tag{} }))
+	g.DELETE("/tags/:id", controllers.Delete(func() interface{} { return &models.Tag{} }))
+
+	g.POST("/tags", services.CreateTag())
+	g.GET("/tags", services.GetAllTags())
+	g.PUT("/tags/:id", services.UpdateTag())
 }
@@ -3,24 +3,42 @@ package routes
 import (
 	"app/controllers"
 	"app/db/models"
+	"app/ratelimit"
 	"app/services"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func UserRoutes(r *gin.Engine) {
-	r.GET("/users", controllers.TokenAuthMiddleware("admin"), controllers.GetAll(func() interface{} { return &[]models.User{} }))
-	r.GET("/users/:id", controllers.TokenAuthMiddleware("user"), controllers.IsOwner(), controllers.Get(func() interface{} { return &models.User{} }))
-	r.DELETE("/users/:id", controllers.TokenAuthMiddleware("admin"), controllers.Delete(func() interface{} { return &models.User{} }))
+	g := guard(r)
 
-	r.POST("/register", services.Register())
-	r.PUT("/users/:id", controllers.TokenAuthMiddleware("user"), controllers.IsOwner(), services.UpdateUserData())
-	r.PUT("/users/:id/admin-update", controllers.TokenAuthMiddleware("admin"), services.UpdateUserAdmin())
-	r.POST("/login", services.Login())
-	r.PUT("/users/:id/change-password", controllers.TokenAuthMiddleware("user"), controllers.IsOwner(), services.ChangePassword())
-	r.PUT("/fcm-token", controllers.TokenAuthMiddleware("user"), services.RegisterFcmToken())
-	r.GET("/users/pseudo/:pseudo", controllers.TokenAuthMiddleware("user"), services.GetUserByPseudo())
-	r.POST("/users", controllers.TokenAuthMiddleware("admin"), services.CreateUserByAdmin())
-	r.GET("/user/:userID/servers/:serverID/roles", controllers.TokenAuthMiddleware("user"), services.GetUserServerRole())
-	r.GET("/users/info/:id", controllers.TokenAuthMiddleware("user"), services.GetUserInfos())
+	g.GET("/users", controllers.GetAll(func() interface{} { return &[]models.User{} }))
+	g.GET("/users/:id", controllers.Get(func() interface{} { return &models.User{} }))
+	g.DELETE("/users/:id", controllers.Delete(func() interface{} { return &models.User{} }))
+
+	g.POST("/register", ratelimit.PerIP("register", 5, time.Hour), services.Register())
+	g.PUT("/users/:id", services.UpdateUserData())
+	g.PUT("/users/:id/admin-update", services.UpdateUserAdmin())
+	g.POST("/login", ratelimit.PerIP("login", 10, time.Minute), services.Login())
+	g.POST("/login/two-factor", ratelimit.PerIP("login-two-factor", 10, time.Minute), services.LoginTwoFactor())
+	g.GET("/verify/:token", services.VerifyAccount())
+	g.POST("/verify/resend", ratelimit.PerIP("account-email", 5, 15*time.Minute), services.ResendVerification())
+	g.POST("/password/forgot", ratelimit.PerIP("account-email", 5, 15*time.Minute), services.ForgotPassword())
+	g.POST("/password/reset", ratelimit.PerIP("password-reset", 10, 15*time.Minute), services.ResetPasswordHandler())
+	g.PUT("/users/:id/change-password", services.ChangePassword())
+	g.PUT("/fcm-token", services.RegisterFcmToken())
+	g.GET("/users/pseudo/:pseudo", services.GetUserByPseudo())
+	g.POST("/users", services.CreateUserByAdmin())
+	g.GET("/user/:userID/servers/:serverID/roles", services.GetUserServerRole())
+	g.GET("/users/info/:id", services.GetUserInfos())
+
+	g.GET("/users/:id/sessions", services.ListSessionsHandler())
+	g.DELETE("/users/:id/sessions", services.RevokeAllSessionsHandler())
+	g.DELETE("/users/:id/sessions/:sessionID", services.RevokeSessionHandler())
+	g.POST("/users/:id/two-factor/enroll", services.EnrollTwoFactorHandler())
+	g.POST("/users/:id/two-factor/confirm", services.ConfirmTwoFactorHandler())
+	g.POST("/users/:id/two-factor/disable", services.DisableTwoFactorHandler())
+	g.POST("/users/:id/two-factor/recovery-codes", services.RegenerateRecoveryCodesHandler())
+	g.POST("/users/:id/force-logout", services.RevokeAllSessionsHandler())
 }
@@ -7,5 +7,14 @@ import (
 )
 
 func VocalRoutes(r *gin.Engine) {
-	r.GET("/channels/:id/connect", services.ConnectToChannel)
+	g := guard(r)
+
+	g.GET("/channels/:id/connect", services.ConnectToChannel)
+	g.POST("/channels/:id/recording", services.StartRecordingHandler())
+	g.DELETE("/channels/:id/recording", services.StopRecordingHandler())
+	g.GET("/channels/:id/voice/stats", services.VoiceStatsHandler())
+
+	g.PUT("/servers/:id/voice/:userID/mute", services.SetServerMuteHandler())
+	g.POST("/servers/:id/voice/:userID/move", services.MoveVoiceMemberHandler())
+	g.DELETE("/servers/:id/voice/:userID", services.DisconnectVoiceMemberHandler())
 }
@@ -7,11 +7,13 @@ import (
 )
 
 func WebSocketRoutes(r *gin.Engine) {
-	r.GET("/ws", func(c *gin.Context) {
+	g := guard(r)
+
+	g.GET("/ws", func(c *gin.Context) {
 		services.WsHandler(c.Writer, c.Request)
 	})
 
-	r.GET("/channels/:id/send", func(c *gin.Context) {
+	g.GET("/channels/:id/send", func(c *gin.Context) {
 		services.ChannelWsHandler(c.Writer, c.Request, c.Param("id"))
 	})
 }
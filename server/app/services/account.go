@@ -0,0 +1,281 @@
+package services
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/mailer"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+
+	// A user may be mailed once per emailCooldown and emailHourlyLimit times
+	// per hour for each purpose. Further requests are dropped without telling
+	// the caller, who may not own the account.
+	emailCooldown    = time.Minute
+	emailHourlyLimit = 3
+)
+
+var (
+	ErrInvalidUserToken = errors.New("invalid or expired token")
+	ErrTooManyEmails    = errors.New("too many emails requested, try again later")
+)
+
+// accountEmail is the data of the verify_email and reset_password templates.
+type accountEmail struct {
+	Pseudo string
+	Link   string
+}
+
+// allowUnverifiedLogin reads ALLOW_UNVERIFIED_LOGIN. Unverified users are
+// refused at login unless it is true.
+func allowUnverifiedLogin() bool {
+	allowed, _ := strconv.ParseBool(os.Getenv("ALLOW_UNVERIFIED_LOGIN"))
+	return allowed
+}
+
+// issueUserToken creates a token for purpose and invalidates the previous
+// ones, so only the latest link mailed works.
+func issueUserToken(userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	raw, err := controllers.GenerateVerificationToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: controllers.HashToken(raw),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	return raw, err
+}
+
+// consumeUserToken marks raw as used and returns it. A token only works once.
+func consumeUserToken(raw string, purpose string) (models.UserToken, error) {
+	var token models.UserToken
+	if err := db.GetDB().Where("token_hash = ? AND purpose = ?", controllers.HashToken(raw), purpose).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return token, ErrInvalidUserToken
+		}
+		return token, err
+	}
+
+	result := db.GetDB().Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", token.ID, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return token, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return token, ErrInvalidUserToken
+	}
+	return token, nil
+}
+
+// checkEmailRate refuses to mail userID again too soon.
+func checkEmailRate(userID uuid.UUID, purpose string) error {
+	var recent []models.UserToken
+	if err := db.GetDB().Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, time.Now().Add(-time.Hour)).
+		Order("created_at ASC").Find(&recent).Error; err != nil {
+		return err
+	}
+	if len(recent) == 0 {
+		return nil
+	}
+
+	if time.Since(recent[len(recent)-1].CreatedAt) < emailCooldown || len(recent) >= emailHourlyLimit {
+		return ErrTooManyEmails
+	}
+	return nil
+}
+
+// SendVerificationEmail mails user a fresh verification link.
+func SendVerificationEmail(user models.User) error {
+	if err := checkEmailRate(user.ID, models.UserTokenVerifyEmail); err != nil {
+		return err
+	}
+	token, err := issueUserToken(user.ID, models.UserTokenVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(user.Email, "verify_email", user.Language, accountEmail{
+		Pseudo: user.Pseudo,
+		Link:   strings.TrimSuffix(os.Getenv("DOMAIN"), "/") + "/verify/" + token,
+	})
+}
+
+// SendPasswordResetEmail mails user a link to choose a new password. The link
+// points to PASSWORD_RESET_URL, the page of the client that posts the token
+// to /password/reset.
+func SendPasswordResetEmail(user models.User) error {
+	if err := checkEmailRate(user.ID, models.UserTokenResetPassword); err != nil {
+		return err
+	}
+	token, err := issueUserToken(user.ID, models.UserTokenResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		resetURL = strings.TrimSuffix(os.Getenv("DOMAIN"), "/") + "/reset-password"
+	}
+	return mailer.Send(user.Email, "reset_password", user.Language, accountEmail{
+		Pseudo: user.Pseudo,
+		Link:   resetURL + "?token=" + url.QueryEscape(token),
+	})
+}
+
+// VerifyEmail marks the owner of raw as verified.
+func VerifyEmail(raw string) error {
+	token, err := consumeUserToken(raw, models.UserTokenVerifyEmail)
+	if err != nil {
+		return err
+	}
+	return db.GetDB().Model(&models.User{}).Where("id = ?", token.UserID).
+		UpdateColumns(map[string]interface{}{"is_verified": true, "verification_token": ""}).Error
+}
+
+// ResetPassword sets a new password for the owner of raw and ends all of
+// their sessions.
+func ResetPassword(raw string, password string) error {
+	token, err := consumeUserToken(raw, models.UserTokenResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	if err != nil {
+		return err
+	}
+	// The link was mailed to the user, which proves the address as well.
+	if err := db.GetDB().Model(&models.User{}).Where("id = ?", token.UserID).
+		UpdateColumns(map[string]interface{}{"password": string(hashed), "is_verified": true}).Error; err != nil {
+		return err
+	}
+
+	_, err = RevokeAllSessions(token.UserID, uuid.Nil)
+	return err
+}
+
+func accountError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidUserToken):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ResendVerification godoc
+// @Summary Resend the verification email
+// @Description The answer is the same whether the address is known or not, and when the requests are throttled.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body models.EmailPayload true "Email"
+// @Success 200 {object} models.SuccessResponse
+// @Router /verify/resend [post]
+func ResendVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.EmailPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		var user models.User
+		err := db.GetDB().Where("email = ?", strings.ToLower(payload.Email)).First(&user).Error
+		if err == nil && !user.IsVerified {
+			err = SendVerificationEmail(user)
+		}
+		// Only real accounts are throttled or mailed, so neither may change the
+		// answer.
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, ErrTooManyEmails) {
+			log.Printf("Resend verification for user %s: %v", user.ID, err)
+		}
+
+		c.JSON(http.StatusOK, models.SuccessResponse{Message: "If the account exists and is not verified, an email has been sent"})
+	}
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset email
+// @Description The answer is the same whether the address is known or not, and when the requests are throttled.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body models.EmailPayload true "Email"
+// @Success 200 {object} models.SuccessResponse
+// @Router /password/forgot [post]
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.EmailPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		var user models.User
+		err := db.GetDB().Where("email = ?", strings.ToLower(payload.Email)).First(&user).Error
+		if err == nil {
+			err = SendPasswordResetEmail(user)
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, ErrTooManyEmails) {
+			log.Printf("Password reset email for user %s: %v", user.ID, err)
+		}
+
+		c.JSON(http.StatusOK, models.SuccessResponse{Message: "If the account exists, an email has been sent"})
+	}
+}
+
+// ResetPasswordHandler godoc
+// @Summary Choose a new password
+// @Description Uses the token of a password reset email. Every session of the user is revoked.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body models.ResetPasswordPayload true "Token and new password"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorUserResponse
+// @Router /password/reset [post]
+func ResetPasswordHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.ResetPasswordPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		if err := ResetPassword(payload.Token, payload.NewPassword); err != nil {
+			accountError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.SuccessResponse{Message: "Password updated successfully"})
+	}
+}
@@ -4,74 +4,345 @@ import (
 	"app/controllers"
 	"app/db"
 	"app/db/models"
-	"encoding/json"
+	"app/helpers"
+	"app/oauth"
 	"errors"
-	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"github.com/gin-gonic/gin"
 )
 
-func OAuthCallbackHandler(provider string) gin.HandlerFunc {
+var (
+	ErrUnknownProvider       = errors.New("unknown OAuth provider")
+	ErrProviderConflict      = errors.New("Utilisateur déjà existant avec un autre fournisseur")
+	ErrProviderAlreadyLinked = errors.New("this provider account is already linked to another user")
+	ErrInvalidClientRedirect = errors.New("redirect is not an allowed client URL")
+)
+
+var (
+	oauthOnce      sync.Once
+	oauthProviders map[string]oauth.Provider
+	oauthStates    = oauth.NewStateStore()
+)
+
+// getOAuthProvider returns the provider name, configured from:
+//
+//	CLIENT_ID_GITHUB_AUTH, CLIENT_SECRET_GITHUB_AUTH   GitHub OAuth app
+//	GITHUB_REDIRECT_URL    callback URL (default DOMAIN/auth/github/callback)
+//	GITHUB_BASE_URL, GITHUB_API_URL                    GitHub Enterprise or a stand-in
+func getOAuthProvider(name string) (oauth.Provider, error) {
+	oauthOnce.Do(func() {
+		oauthProviders = make(map[string]oauth.Provider)
+		if clientID := os.Getenv("CLIENT_ID_GITHUB_AUTH"); clientID != "" {
+			redirect := os.Getenv("GITHUB_REDIRECT_URL")
+			if redirect == "" {
+				redirect = strings.TrimSuffix(os.Getenv("DOMAIN"), "/") + "/auth/github/callback"
+			}
+			oauthProviders["github"] = oauth.NewGitHub(oauth.GitHubConfig{
+				ClientID:     clientID,
+				ClientSecret: os.Getenv("CLIENT_SECRET_GITHUB_AUTH"),
+				RedirectURL:  redirect,
+				BaseURL:      os.Getenv("GITHUB_BASE_URL"),
+				APIURL:       os.Getenv("GITHUB_API_URL"),
+			})
+		}
+	})
+
+	provider, ok := oauthProviders[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// allowedClientRedirect reports whether the callback may send tokens to
+// redirect. OAUTH_CLIENT_REDIRECTS lists the allowed URLs, comma separated.
+func allowedClientRedirect(redirect string) bool {
+	for _, allowed := range strings.Split(os.Getenv("OAUTH_CLIENT_REDIRECTS"), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && allowed == redirect {
+			return true
+		}
+	}
+	return false
+}
+
+// userForIdentity finds the account of identity, links accounts created by
+// the former client-side flow by email, or creates a new one.
+func userForIdentity(identity oauth.Identity) (models.User, error) {
+	var user models.User
+	err := db.GetDB().Where("provider = ? AND provider_id = ?", identity.Provider, identity.ProviderID).First(&user).Error
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	err = db.GetDB().Where("email = ?", identity.Email).First(&user).Error
+	if err == nil {
+		if user.Provider != identity.Provider || user.ProviderID != "" {
+			return user, ErrProviderConflict
+		}
+		user.ProviderID = identity.ProviderID
+		return user, db.GetDB().Model(&user).UpdateColumns(map[string]interface{}{"provider_id": identity.ProviderID, "is_verified": true}).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	pseudo, err := availablePseudo(identity.Name)
+	if err != nil {
+		return user, err
+	}
+	user = models.User{
+		Email:      identity.Email,
+		Pseudo:     pseudo,
+		Provider:   identity.Provider,
+		ProviderID: identity.ProviderID,
+		IsVerified: true,
+		Profile:    identity.AvatarURL,
+	}
+	return user, db.GetDB().Create(&user).Error
+}
+
+// availablePseudo returns name, or name with a numeric suffix when taken.
+func availablePseudo(name string) (string, error) {
+	if name == "" {
+		name = "user"
+	}
+	candidate := name
+	for i := 2; ; i++ {
+		var count int64
+		if err := db.GetDB().Model(&models.User{}).Where("pseudo = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = name + strconv.Itoa(i)
+	}
+}
+
+// linkIdentity attaches identity to the account of userID.
+func linkIdentity(userID uuid.UUID, identity oauth.Identity) error {
+	var count int64
+	if err := db.GetDB().Model(&models.User{}).
+		Where("provider = ? AND provider_id = ? AND id <> ?", identity.Provider, identity.ProviderID, userID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrProviderAlreadyLinked
+	}
+	return db.GetDB().Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumns(map[string]interface{}{"provider": identity.Provider, "provider_id": identity.ProviderID}).Error
+}
+
+func oauthError(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownProvider):
+		return http.StatusNotFound
+	case errors.Is(err, oauth.ErrInvalidState), errors.Is(err, ErrInvalidClientRedirect):
+		return http.StatusBadRequest
+	case errors.Is(err, oauth.ErrExchangeFailed), errors.Is(err, oauth.ErrNoVerifiedEmail):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrProviderConflict):
+		return http.StatusForbidden
+	case errors.Is(err, ErrProviderAlreadyLinked):
+		return http.StatusConflict
+	case errors.Is(err, oauth.ErrIdentityFailed):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// OAuthLoginHandler sends the browser to the provider. With a redirect
+// parameter listed in OAUTH_CLIENT_REDIRECTS, the callback hands the tokens
+// to that URL in its fragment instead of answering JSON.
+func OAuthLoginHandler(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, err := getOAuthProvider(name)
+		if err != nil {
+			c.JSON(oauthError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		redirect := c.Query("redirect")
+		if redirect != "" && !allowedClientRedirect(redirect) {
+			c.JSON(oauthError(ErrInvalidClientRedirect), gin.H{"error": ErrInvalidClientRedirect.Error()})
+			return
+		}
+
+		state, challenge, err := oauthStates.Begin(oauth.Flow{Provider: name, ClientRedirect: redirect})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Redirect(http.StatusFound, provider.AuthCodeURL(state, challenge))
+	}
+}
+
+// OAuthLinkHandler returns the provider URL that links the provider account
+// to the signed in user.
+func OAuthLinkHandler(name string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Récupération des données du corps de la requête
-		var data map[string]interface{}
-		body, err := io.ReadAll(c.Request.Body)
+		userID, err := helpers.GetLoggedInUserID(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Échec de la lecture du corps de la requête"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
-		if err := json.Unmarshal(body, &data); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Échec de l'analyse du corps de la requête"})
+
+		provider, err := getOAuthProvider(name)
+		if err != nil {
+			c.JSON(oauthError(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		email, okEmail := data["email"].(string)
-		displayName, okDisplayName := data["displayName"].(string)
-		avatar, okAvatar := data["photoURL"].(string)
-		if !okEmail || !okDisplayName || !okAvatar {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Données d'entrée invalides"})
+		state, challenge, err := oauthStates.Begin(oauth.Flow{Provider: name, LinkUserID: userID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		println(email, displayName, avatar)
+		c.JSON(http.StatusOK, gin.H{"url": provider.AuthCodeURL(state, challenge)})
+	}
+}
+
+// OAuthCallbackHandler is where the provider sends the browser back. The
+// code is exchanged on the server and the identity read from the provider,
+// never from the client.
+func OAuthCallbackHandler(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, err := getOAuthProvider(name)
+		if err != nil {
+			c.JSON(oauthError(err), gin.H{"error": err.Error()})
+			return
+		}
 
-		var user models.User
-		result := db.GetDB().Where("email = ?", email).First(&user)
-		if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Erreur de base de données"})
+		flow, err := oauthStates.Complete(name, c.Query("state"))
+		if err != nil {
+			c.JSON(oauthError(err), gin.H{"error": err.Error()})
+			return
+		}
+		if reason := c.Query("error"); reason != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Connexion refusée par le fournisseur : " + reason})
 			return
 		}
 
-		if result.RowsAffected == 0 {
-			user = models.User{
-				Email:      email,
-				Pseudo:     displayName,
-				Provider:   provider,
-				IsVerified: true,
-				Profile:    avatar,
+		identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), flow.Verifier)
+		if err != nil {
+			c.JSON(oauthError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if flow.LinkUserID != uuid.Nil {
+			if err := linkIdentity(flow.LinkUserID, identity); err != nil {
+				c.JSON(oauthError(err), gin.H{"error": err.Error()})
+				return
 			}
+			c.JSON(http.StatusOK, gin.H{"message": "Compte " + name + " lié"})
+			return
+		}
 
-			if err := db.GetDB().Create(&user).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Échec de la création de l'utilisateur"})
+		user, err := userForIdentity(identity)
+		if err != nil {
+			c.JSON(oauthError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.TOTPEnabled {
+			challenge, err := StartLoginChallenge(user)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Échec de la génération du JWT"})
 				return
 			}
-		} else {
-			if user.Provider != provider {
-				c.JSON(http.StatusForbidden, gin.H{"error": "Utilisateur déjà existant avec un autre fournisseur"})
+			if flow.ClientRedirect != "" {
+				fragment := url.Values{
+					"challenge_token": {challenge.ChallengeToken},
+					"expires_in":      {strconv.Itoa(challenge.ExpiresIn)},
+				}
+				c.Redirect(http.StatusFound, flow.ClientRedirect+"#"+fragment.Encode())
 				return
 			}
+			c.JSON(http.StatusOK, challenge)
+			return
 		}
 
-		// Génération du JWT
-		tokenString, err := controllers.GenerateJWT(user.ID, user.Email, user.Role, user.Pseudo)
+		// Génération du JWT et du refresh token
+		tokens, err := controllers.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Échec de la génération du JWT"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"token": tokenString})
+		if flow.ClientRedirect != "" {
+			fragment := url.Values{
+				"token":         {tokens.Token},
+				"refresh_token": {tokens.RefreshToken},
+				"expires_in":    {strconv.Itoa(tokens.ExpiresIn)},
+			}
+			c.Redirect(http.StatusFound, flow.ClientRedirect+"#"+fragment.Encode())
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+func refreshError(err error) int {
+	switch {
+	case errors.Is(err, controllers.ErrInvalidRefreshToken), errors.Is(err, controllers.ErrRefreshTokenExpired), errors.Is(err, controllers.ErrRefreshTokenReused), errors.Is(err, controllers.ErrSessionRevoked):
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// RefreshHandler exchanges a refresh token for a new token pair. The refresh
+// token sent is consumed: sending it again ends the session.
+func RefreshHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.RefreshPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		tokens, err := controllers.RefreshTokens(payload.RefreshToken, c.ClientIP())
+		if err != nil {
+			c.JSON(refreshError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// LogoutHandler revokes the session of a refresh token along with the access
+// tokens issued for it.
+func LogoutHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.RefreshPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		if err := controllers.RevokeRefreshToken(payload.RefreshToken); err != nil {
+			c.JSON(refreshError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Déconnexion réussie"})
 	}
 }
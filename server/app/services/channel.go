@@ -3,19 +3,39 @@ package services
 import (
 	"app/db"
 	"app/db/models"
-	"log"
+	"app/helpers"
+	"app/hub"
+	"app/protocol"
+	"errors"
 	"net/http"
 	"github.com/google/uuid"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func GetChannelMessages() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var messages []models.Message
-		channelID := c.Param("id")
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		cursor, err := helpers.ParseCursor(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		if err := db.GetDB().Where("channel_id = ?", channelID).Order("sent_at").Preload("User").Find(&messages).Error; err != nil {
+		messages, err := pageMessages(func() *gorm.DB {
+			return db.GetDB().Where("channel_id = ? AND thread_id IS NULL", channelID)
+		}, cursor)
+		if err != nil {
+			if errors.Is(err, ErrMessageNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
 			c.Error(err)
 			return
 		}
@@ -24,6 +44,89 @@ func GetChannelMessages() gin.HandlerFunc {
 	}
 }
 
+// pageMessages returns one page of the messages selected by scope, oldest
+// first. Pages are keyed on (created_at, id) so concurrent inserts never
+// shift them.
+func pageMessages(scope func() *gorm.DB, cursor helpers.Cursor) ([]models.Message, error) {
+	query := func() *gorm.DB {
+		return scope().Preload("User", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id", "pseudo", "profile")
+		})
+	}
+
+	var messages []models.Message
+	if cursor.Direction == helpers.CursorLatest {
+		if err := query().Order("created_at DESC, id DESC").Limit(cursor.Limit).Find(&messages).Error; err != nil {
+			return nil, err
+		}
+		reverseMessages(messages)
+		return messages, nil
+	}
+
+	var pivot models.Message
+	if err := scope().Unscoped().Where("id = ?", cursor.ID).First(&pivot).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+
+	switch cursor.Direction {
+	case helpers.CursorBefore:
+		if err := query().Where("(created_at, id) < (?, ?)", pivot.CreatedAt, pivot.ID).
+			Order("created_at DESC, id DESC").Limit(cursor.Limit).Find(&messages).Error; err != nil {
+			return nil, err
+		}
+		reverseMessages(messages)
+	case helpers.CursorAfter:
+		if err := query().Where("(created_at, id) > (?, ?)", pivot.CreatedAt, pivot.ID).
+			Order("created_at, id").Limit(cursor.Limit).Find(&messages).Error; err != nil {
+			return nil, err
+		}
+	case helpers.CursorAround:
+		var before, after []models.Message
+		if err := query().Where("(created_at, id) < (?, ?)", pivot.CreatedAt, pivot.ID).
+			Order("created_at DESC, id DESC").Limit(cursor.Limit / 2).Find(&before).Error; err != nil {
+			return nil, err
+		}
+		if err := query().Where("(created_at, id) >= (?, ?)", pivot.CreatedAt, pivot.ID).
+			Order("created_at, id").Limit(cursor.Limit - len(before)).Find(&after).Error; err != nil {
+			return nil, err
+		}
+		reverseMessages(before)
+		messages = append(before, after...)
+	}
+
+	return messages, nil
+}
+
+func reverseMessages(messages []models.Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+func CreateChannel() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var channel models.Channel
+		if err := c.ShouldBindJSON(&channel); err != nil {
+			c.Error(err)
+			return
+		}
+
+		if err := db.GetDB().Create(&channel).Error; err != nil {
+			c.Error(err)
+			return
+		}
+
+		if channel.ServerID != uuid.Nil {
+			publishEvent(hub.ServerTopic(channel.ServerID), protocol.EventChannelCreate, channelPayload(channel))
+		}
+
+		c.JSON(http.StatusCreated, channel)
+	}
+}
+
 func GetUserChannels() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var channels []models.Channel
@@ -49,9 +152,19 @@ func GetUserChannels() gin.HandlerFunc {
 			channels = append(channels, serverChannels...)
 		}
 
-		log.Printf("User %s has %d channels\n", userID, len(channels))
+		userUUID, err := uuid.Parse(userID)
+		if err != nil {
+			handleError(c, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+
+		response, err := withUnread(userUUID, channels)
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, "Error counting unread messages")
+			return
+		}
 
-		c.JSON(http.StatusOK, channels)
+		c.JSON(http.StatusOK, response)
 	}
 }
 
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/permissions"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidOverwrite  = errors.New("an overwrite targets either a role or a user of the server")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrOverwriteNotFound = errors.New("overwrite not found")
+)
+
+// ListChannelOverwrites returns the overwrites of channelID.
+func ListChannelOverwrites(channelID uuid.UUID) ([]models.ChannelOverwrite, error) {
+	overwrites := []models.ChannelOverwrite{}
+	err := db.GetDB().Where("channel_id = ?", channelID).Order("created_at").Find(&overwrites).Error
+	return overwrites, err
+}
+
+// SetChannelOverwrite creates or replaces the overwrite of a role or a user
+// for a permission in channelID.
+func SetChannelOverwrite(channelID uuid.UUID, payload models.ChannelOverwritePayload) (models.ChannelOverwrite, error) {
+	var overwrite models.ChannelOverwrite
+	if (payload.RoleID == nil) == (payload.UserID == nil) {
+		return overwrite, ErrInvalidOverwrite
+	}
+
+	var channel models.Channel
+	if err := db.GetDB().First(&channel, "id = ?", channelID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return overwrite, ErrChannelNotFound
+		}
+		return overwrite, err
+	}
+
+	var known int64
+	if err := db.GetDB().Model(&models.Permissions{}).Where("label = ?", payload.Permission).Count(&known).Error; err != nil {
+		return overwrite, err
+	}
+	if known == 0 || payload.Permission == permissions.Admin {
+		return overwrite, ErrUnknownPermission
+	}
+
+	query := db.GetDB().Where("channel_id = ? AND permission = ?", channelID, payload.Permission)
+	var target int64
+	if payload.RoleID != nil {
+		if err := db.GetDB().Model(&models.Role{}).Where("id = ? AND server_id = ?", *payload.RoleID, channel.ServerID).Count(&target).Error; err != nil {
+			return overwrite, err
+		}
+		query = query.Where("role_id = ?", *payload.RoleID)
+	} else {
+		if err := db.GetDB().Model(&models.OnServer{}).Where("user_id = ? AND server_id = ?", *payload.UserID, channel.ServerID).Count(&target).Error; err != nil {
+			return overwrite, err
+		}
+		query = query.Where("user_id = ?", *payload.UserID)
+	}
+	if target == 0 {
+		return overwrite, ErrInvalidOverwrite
+	}
+
+	err := query.First(&overwrite).Error
+	switch {
+	case err == nil:
+		overwrite.Allow = *payload.Allow
+		err = db.GetDB().Model(&overwrite).Update("allow", overwrite.Allow).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		overwrite = models.ChannelOverwrite{
+			ChannelID:  channelID,
+			RoleID:     payload.RoleID,
+			UserID:     payload.UserID,
+			Permission: payload.Permission,
+			Allow:      *payload.Allow,
+		}
+		err = db.GetDB().Create(&overwrite).Error
+	}
+	return overwrite, err
+}
+
+// DeleteChannelOverwrite removes an overwrite of channelID.
+func DeleteChannelOverwrite(channelID uuid.UUID, overwriteID uuid.UUID) error {
+	result := db.GetDB().Where("id = ? AND channel_id = ?", overwriteID, channelID).Delete(&models.ChannelOverwrite{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOverwriteNotFound
+	}
+	return nil
+}
+
+func overwriteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidOverwrite), errors.Is(err, ErrUnknownPermission):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrChannelNotFound), errors.Is(err, ErrOverwriteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// GetChannelOverwrites godoc
+// @Summary List the permission overwrites of a channel
+// @Tags channels
+// @Produce json
+// @Param id path string true "Channel ID"
+// @Success 200 {array} models.ChannelOverwrite
+// @Router /channels/{id}/overwrites [get]
+func GetChannelOverwrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		overwrites, err := ListChannelOverwrites(channelID)
+		if err != nil {
+			overwriteError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, overwrites)
+	}
+}
+
+// PutChannelOverwrite godoc
+// @Summary Allow or deny a permission in a channel to a role or a user
+// @Description An overwrite replaces what the roles give. A user overwrite wins over role overwrites, and among roles the highest one wins.
+// @Tags channels
+// @Accept json
+// @Produce json
+// @Param id path string true "Channel ID"
+// @Param payload body models.ChannelOverwritePayload true "Overwrite"
+// @Success 200 {object} models.ChannelOverwrite
+// @Failure 400 {object} models.ErrorUserResponse
+// @Router /channels/{id}/overwrites [put]
+func PutChannelOverwrite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		var payload models.ChannelOverwritePayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		overwrite, err := SetChannelOverwrite(channelID, payload)
+		if err != nil {
+			overwriteError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, overwrite)
+	}
+}
+
+// DeleteChannelOverwriteHandler godoc
+// @Summary Remove a permission overwrite of a channel
+// @Tags channels
+// @Produce json
+// @Param id path string true "Channel ID"
+// @Param overwriteID path string true "Overwrite ID"
+// @Success 200 {object} models.SuccessResponse
+// @Router /channels/{id}/overwrites/{overwriteID} [delete]
+func DeleteChannelOverwriteHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		overwriteID, err := uuid.Parse(c.Param("overwriteID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid overwrite ID"})
+			return
+		}
+
+		if err := DeleteChannelOverwrite(channelID, overwriteID); err != nil {
+			overwriteError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.SuccessResponse{Message: "Overwrite removed"})
+	}
+}
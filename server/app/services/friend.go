@@ -3,6 +3,8 @@ package services
 import (
 	"app/db"
 	"app/db/models"
+	"app/helpers"
+	"app/presence"
 	"net/http"
 	"strings"
 
@@ -110,6 +112,41 @@ func RefuseFriend() gin.HandlerFunc {
 	}
 }
 
+// DeleteFriend removes a friendship or a friend request of the logged-in user.
+func DeleteFriend() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		friendID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "ID d'ami invalide"})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		var friend models.Friend
+		if err := db.GetDB().Where("id = ?", friendID).First(&friend).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Ami non trouvé"})
+			return
+		}
+
+		if friend.UserID1 != userID && friend.UserID2 != userID {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Suppression d'ami non autorisée"})
+			return
+		}
+
+		if err := db.GetDB().Delete(&friend).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete friend"})
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
 func SearchUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		pseudo := c.Param("pseudo")
@@ -177,6 +214,7 @@ func GetFriendsByUser() gin.HandlerFunc {
 				"Status":     friend.Status,
 				"UserPseudo": friendPseudo,
 				"Profile":    friendProfile,
+				"Presence":   presence.GetTracker().Status(friendID),
 			}
 			friendsResponse = append(friendsResponse, friendData)
 		}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/hub"
+	"app/permissions"
+	"app/protocol"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	subscriptionChannel = "channel"
+	subscriptionServer  = "server"
+	subscriptionGroup   = "group"
+)
+
+var errAccessDenied = errors.New("access denied")
+
+// WsHandler is the multiplexed gateway: one authenticated socket per client
+// that subscribes to channels, servers and groups on demand.
+func WsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, err := authenticateWebSocket(r)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+
+	h := hub.GetHub()
+	client := hub.NewClient(h, conn, userID)
+	client.EnableResume()
+	h.Register(client)
+	h.Subscribe(client, hub.AuthTopic(sessionID))
+	h.Subscribe(client, hub.UserTopic(userID))
+	go client.WritePump()
+
+	connID := client.SessionID
+	defer trackPresence(userID, connID)()
+	typing := typingThrottle{}
+
+	sendEvent(client, protocol.EventReady, protocol.Ready{SessionID: client.SessionID, UserID: userID})
+
+	client.ReadPump(func(msgBytes []byte) {
+		frame, err := protocol.Decode(msgBytes)
+		if err != nil {
+			sendError(client, protocol.ErrorCode(err), err.Error(), frame.Nonce)
+			return
+		}
+
+		switch frame.Op {
+		case protocol.OpSubscribe, protocol.OpUnsubscribe:
+			var sub protocol.Subscribe
+			if err := frame.DecodeData(&sub); err != nil {
+				sendError(client, protocol.CodeInvalidPayload, err.Error(), frame.Nonce)
+				return
+			}
+
+			topic, err := resolveSubscription(userID, sub.Type, sub.ID)
+			if err != nil {
+				if errors.Is(err, errAccessDenied) {
+					sendError(client, protocol.CodeForbidden, "You cannot subscribe to this "+sub.Type, frame.Nonce)
+				} else {
+					sendError(client, "invalid_subscription", err.Error(), frame.Nonce)
+				}
+				return
+			}
+
+			payload := protocol.Subscription{Type: sub.Type, ID: sub.ID}
+			if frame.Op == protocol.OpSubscribe {
+				h.Subscribe(client, topic)
+				sendEvent(client, protocol.EventSubscribed, payload)
+			} else {
+				h.Unsubscribe(client, topic)
+				sendEvent(client, protocol.EventUnsubscribed, payload)
+			}
+		case protocol.OpResume:
+			var resume protocol.Resume
+			if err := frame.DecodeData(&resume); err != nil {
+				sendError(client, protocol.CodeInvalidPayload, err.Error(), frame.Nonce)
+				return
+			}
+
+			replayed, err := h.Resume(client, resume.SessionID, resume.Seq)
+			if err != nil {
+				sendEvent(client, protocol.EventInvalidSession, protocol.Error{Code: "invalid_session", Message: err.Error(), Nonce: frame.Nonce})
+				return
+			}
+			sendEvent(client, protocol.EventResumed, protocol.Resumed{SessionID: resume.SessionID, Replayed: replayed})
+		case protocol.OpHeartbeat:
+			handleHeartbeat(client, connID, frame)
+		case protocol.OpTypingStart:
+			var start protocol.TypingStart
+			if err := frame.DecodeData(&start); err != nil {
+				sendError(client, protocol.CodeInvalidPayload, err.Error(), frame.Nonce)
+				return
+			}
+			// A refused op must not use up the throttle of the caller
+			if allowed, err := canAccessChannel(userID, start.ChannelID); err != nil || !allowed {
+				sendError(client, protocol.CodeForbidden, "You cannot type in this channel", frame.Nonce)
+				return
+			}
+			if !typing.allow(start.ChannelID, time.Now()) {
+				return
+			}
+			publishTyping(userID, start.ChannelID)
+		default:
+			sendError(client, protocol.CodeUnknownOp, "Op "+frame.Op+" is not supported on the gateway", frame.Nonce)
+		}
+	})
+}
+
+func resolveSubscription(userID uuid.UUID, kind string, id uuid.UUID) (string, error) {
+	if id == uuid.Nil {
+		return "", errors.New("invalid id")
+	}
+
+	var allowed bool
+	var topic string
+	var err error
+	switch kind {
+	case subscriptionChannel:
+		topic = hub.ChannelTopic(id)
+		allowed, err = canAccessChannel(userID, id)
+	case subscriptionServer:
+		topic = hub.ServerTopic(id)
+		allowed, err = isServerMember(userID, id)
+	case subscriptionGroup:
+		topic = hub.GroupTopic(id)
+		allowed, err = isGroupMember(userID, id)
+	default:
+		return "", errors.New("unknown subscription type")
+	}
+
+	if err != nil || !allowed {
+		return "", errAccessDenied
+	}
+
+	return topic, nil
+}
+
+func canAccessChannel(userID uuid.UUID, channelID uuid.UUID) (bool, error) {
+	var channel models.Channel
+	if err := db.GetDB().Where("id = ?", channelID).First(&channel).Error; err != nil {
+		return false, err
+	}
+
+	if channel.ServerID != uuid.Nil {
+		return verifyWebSocketPermission(userID, channelID, "accessChannel", channel.ServerID)
+	}
+
+	return permissions.GroupMember(userID, channelID)
+}
+
+func isServerMember(userID uuid.UUID, serverID uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.GetDB().Model(&models.OnServer{}).Where("server_id = ? AND user_id = ?", serverID, userID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func isGroupMember(userID uuid.UUID, groupID uuid.UUID) (bool, error) {
+	var count int64
+	if err := db.GetDB().Model(&models.GroupMember{}).Where("group_id = ? AND user_id = ?", groupID, userID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// publishEvent sends a typed event to every subscriber of topic.
+func publishEvent(topic string, eventType string, data interface{}) {
+	hub.GetHub().Publish(topic, protocol.NewEvent(eventType, data))
+}
+
+func sendEvent(client *hub.Client, eventType string, data interface{}) {
+	client.Send(protocol.NewEvent(eventType, data))
+}
+
+func sendError(client *hub.Client, code string, message string, nonce string) {
+	sendEvent(client, protocol.EventError, protocol.Error{Code: code, Message: message, Nonce: nonce})
+}
+
+func sendNack(client *hub.Client, nonce string, code string, message string) {
+	sendEvent(client, protocol.EventMessageNack, protocol.MessageNack{Nonce: nonce, Code: code, Message: message})
+}
+
+func channelPayload(channel models.Channel) protocol.Channel {
+	return protocol.Channel{ID: channel.ID, Name: channel.Name, Type: channel.Type, ServerID: channel.ServerID}
+}
+
+func messagePayload(message models.Message, author models.User) protocol.Message {
+	return protocol.Message{
+		ID:          message.ID,
+		ChannelID:   message.ChannelID,
+		Content:     message.Content,
+		Type:        message.Type,
+		SentAt:      message.SentAt,
+		EditedAt:    message.EditedAt,
+		ReplyToID:   message.ReplyToID,
+		ThreadID:    message.ThreadID,
+		ReplyCount:  message.ReplyCount,
+		LastReplyAt: message.LastReplyAt,
+		User:        protocol.Author{ID: author.ID, Pseudo: author.Pseudo, Profile: author.Profile},
+	}
+}
@@ -50,7 +50,12 @@ func CreateOrGetDM() gin.HandlerFunc {
 		var group models.Group
 		err = db.GetDB().Where("type = ? AND (id IN (SELECT group_id FROM group_members WHERE user_id = ?) AND id IN (SELECT group_id FROM group_members WHERE user_id = ?))", "dm", userID1, userID2).First(&group).Error
 		if err == nil {
-			c.JSON(http.StatusOK, group)
+			seen, err := seenBy(userID1, group)
+			if err != nil {
+				c.Error(err)
+				return
+			}
+			c.JSON(http.StatusOK, groupWithUnread{Group: group, SeenBy: seen})
 			return
 		}
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -421,6 +426,12 @@ func GetUserGroups() gin.HandlerFunc {
 			groups = append(groups, group)
 		}
 
-		c.JSON(http.StatusOK, groups)
+		response, err := groupsWithUnread(userID, groups)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
 	}
 }
@@ -4,7 +4,9 @@ import (
 	"app/db"
 	"app/db/models"
 	"app/helpers"
+	"app/mailer"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -93,10 +95,42 @@ func SendInvitation(createLink bool) gin.HandlerFunc {
 			return
 		}
 
+		notifyInvitation(invitation)
+
 		c.JSON(http.StatusCreated, invitation)
 	}
 }
 
+// notifyInvitation emails the receiver of invitation. The invitation stands
+// even when the email cannot be queued.
+func notifyInvitation(invitation models.Invitation) {
+	var sender, receiver models.User
+	var server models.Server
+	if err := db.GetDB().Where("id = ?", invitation.UserSenderID).First(&sender).Error; err != nil {
+		log.Println("Error loading invitation sender:", err)
+		return
+	}
+	if err := db.GetDB().Where("id = ?", invitation.UserReceiverID).First(&receiver).Error; err != nil {
+		log.Println("Error loading invitation receiver:", err)
+		return
+	}
+	if err := db.GetDB().Where("id = ?", invitation.ServerID).First(&server).Error; err != nil {
+		log.Println("Error loading invitation server:", err)
+		return
+	}
+
+	err := mailer.Send(receiver.Email, "invitation", receiver.Language, struct {
+		Pseudo string
+		Sender string
+		Server string
+		Link   string
+		Expire time.Time
+	}{receiver.Pseudo, sender.Pseudo, server.Name, invitation.Link, invitation.Expire})
+	if err != nil {
+		log.Println("Error queuing invitation email:", err)
+	}
+}
+
 func GetInvitationsByUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userIDStr := c.Param("id")
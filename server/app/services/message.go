@@ -3,13 +3,369 @@ package services
 import (
 	"app/db"
 	"app/db/models"
+	"app/helpers"
+	"app/hub"
+	"app/permissions"
+	"app/protocol"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrInvalidMessage   = errors.New("content and type are required")
+	ErrChannelNotFound  = errors.New("channel not found")
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrMessageForbidden = errors.New("you cannot send messages on this channel")
+	ErrInvalidReply     = errors.New("replies must target a message of the same channel and thread")
+
+	ErrNotMessageAuthor    = errors.New("only the author can edit this message")
+	ErrCannotDeleteMessage = errors.New("you cannot delete this message")
+)
+
+type messageInput struct {
+	Content   string     `json:"content"`
+	Type      string     `json:"type"`
+	ChannelID uuid.UUID  `json:"channelId"`
+	ReplyToID *uuid.UUID `json:"replyToId"`
+	ThreadID  *uuid.UUID `json:"threadId"`
+}
+
+// MessageDraft is what a client asks to post. ReplyToID and ThreadID are
+// optional.
+type MessageDraft struct {
+	Content   string
+	Type      string
+	ReplyToID *uuid.UUID
+	ThreadID  *uuid.UUID
+}
+
+// CreateMessage stores a message written by userID in channelID and
+// announces it to the channel subscribers. Thread replies also bump their
+// root and the author of the answered message is notified.
+func CreateMessage(userID uuid.UUID, channelID uuid.UUID, draft MessageDraft) (models.Message, error) {
+	if draft.Content == "" || draft.Type == "" {
+		return models.Message{}, ErrInvalidMessage
+	}
+
+	var channel models.Channel
+	if err := db.GetDB().Where("id = ?", channelID).First(&channel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Message{}, ErrChannelNotFound
+		}
+		return models.Message{}, err
+	}
+
+	allowed, err := canSendMessage(userID, channel)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if !allowed {
+		return models.Message{}, ErrMessageForbidden
+	}
+
+	message := models.Message{
+		Content:   draft.Content,
+		Type:      draft.Type,
+		ChannelID: channelID,
+		UserID:    userID,
+		SentAt:    time.Now().UTC(),
+	}
+
+	parent, root, err := resolveReply(channelID, draft)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if parent != nil {
+		message.ReplyToID = &parent.ID
+	}
+	if root != nil {
+		message.ThreadID = &root.ID
+	}
+
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		if root == nil {
+			return nil
+		}
+		root.ReplyCount++
+		root.LastReplyAt = &message.SentAt
+		return tx.Model(&models.Message{}).Where("id = ?", root.ID).Updates(map[string]interface{}{
+			"reply_count":   gorm.Expr("reply_count + 1"),
+			"last_reply_at": message.SentAt,
+		}).Error
+	})
+	if err != nil {
+		return models.Message{}, err
+	}
+	if err := db.GetDB().Where("id = ?", userID).First(&message.User).Error; err != nil {
+		return models.Message{}, err
+	}
+
+	payload := messagePayload(message, message.User)
+	publishEvent(hub.ChannelTopic(channelID), protocol.EventMessageCreate, payload)
+
+	if root != nil {
+		publishEvent(hub.ChannelTopic(channelID), protocol.EventThreadUpdate, protocol.ThreadUpdate{
+			MessageID:   root.ID,
+			ChannelID:   channelID,
+			ReplyCount:  root.ReplyCount,
+			LastReplyAt: root.LastReplyAt,
+		})
+	}
+
+	notified := parent
+	if notified == nil {
+		notified = root
+	}
+	if notified != nil && notified.UserID != userID {
+		publishEvent(hub.UserTopic(notified.UserID), protocol.EventReplyCreate, protocol.Reply{ParentID: notified.ID, Message: payload})
+	}
+
+	return message, nil
+}
+
+// resolveReply loads the message answered by draft and the root of the thread
+// it belongs to. Both must live in channelID; a reply to a thread message
+// stays in that thread.
+func resolveReply(channelID uuid.UUID, draft MessageDraft) (*models.Message, *models.Message, error) {
+	var parent, root *models.Message
+
+	if draft.ThreadID != nil {
+		message, err := findMessage(*draft.ThreadID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if message.ChannelID != channelID || message.ThreadID != nil {
+			return nil, nil, ErrInvalidReply
+		}
+		root = &message
+	}
+
+	if draft.ReplyToID != nil {
+		message, err := findMessage(*draft.ReplyToID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if message.ChannelID != channelID {
+			return nil, nil, ErrInvalidReply
+		}
+		parent = &message
+
+		if root == nil && message.ThreadID != nil {
+			threadRoot, err := findMessage(*message.ThreadID)
+			if err != nil {
+				return nil, nil, err
+			}
+			root = &threadRoot
+		}
+		if root != nil && message.ID != root.ID && (message.ThreadID == nil || *message.ThreadID != root.ID) {
+			return nil, nil, ErrInvalidReply
+		}
+	}
+
+	return parent, root, nil
+}
+
+// EditMessage replaces the content of a message written by userID and keeps
+// the previous content as a revision.
+func EditMessage(userID uuid.UUID, messageID uuid.UUID, content string) (models.Message, error) {
+	if content == "" {
+		return models.Message{}, ErrInvalidMessage
+	}
+
+	message, err := findMessage(messageID)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if message.UserID != userID {
+		return models.Message{}, ErrNotMessageAuthor
+	}
+	if message.Content == content {
+		return message, nil
+	}
+
+	now := time.Now()
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		revision := models.MessageRevision{
+			Content:   message.Content,
+			MessageID: message.ID,
+			EditorID:  userID,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+		return tx.Model(&message).Updates(map[string]interface{}{"content": content, "edited_at": now}).Error
+	})
+	if err != nil {
+		return models.Message{}, err
+	}
+	message.Content = content
+	message.EditedAt = &now
+
+	publishEvent(hub.ChannelTopic(message.ChannelID), protocol.EventMessageUpdate, messagePayload(message, message.User))
+
+	return message, nil
+}
+
+// DeleteMessage removes a message. Authors may delete their own messages,
+// members with manageMessages on the server may delete any of them.
+// Deleting a reply updates the counters of its thread root; deleting a root
+// deletes its whole thread with it, so no reply is left without its root.
+func DeleteMessage(userID uuid.UUID, messageID uuid.UUID) (models.Message, error) {
+	message, err := findMessage(messageID)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	if message.UserID != userID {
+		var channel models.Channel
+		if err := db.GetDB().Where("id = ?", message.ChannelID).First(&channel).Error; err != nil {
+			return models.Message{}, err
+		}
+		if channel.ServerID == uuid.Nil {
+			return models.Message{}, ErrCannotDeleteMessage
+		}
+		allowed, err := hasServerPermission(userID, channel.ServerID, "manageMessages")
+		if err != nil {
+			return models.Message{}, err
+		}
+		if !allowed {
+			return models.Message{}, ErrCannotDeleteMessage
+		}
+	}
+
+	var thread *protocol.ThreadUpdate
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&message).Error; err != nil {
+			return err
+		}
+		if message.ThreadID == nil {
+			return tx.Where("thread_id = ?", message.ID).Delete(&models.Message{}).Error
+		}
+
+		update, err := recountThread(tx, *message.ThreadID)
+		if err != nil {
+			return err
+		}
+		update.ChannelID = message.ChannelID
+		thread = &update
+		return nil
+	})
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	publishEvent(hub.ChannelTopic(message.ChannelID), protocol.EventMessageDelete, protocol.MessageDelete{ID: message.ID, ChannelID: message.ChannelID})
+	if thread != nil {
+		publishEvent(hub.ChannelTopic(message.ChannelID), protocol.EventThreadUpdate, *thread)
+	}
+
+	return message, nil
+}
+
+// recountThread sets the reply counters of rootID from the replies left in
+// its thread.
+func recountThread(tx *gorm.DB, rootID uuid.UUID) (protocol.ThreadUpdate, error) {
+	update := protocol.ThreadUpdate{MessageID: rootID}
+
+	var counters struct {
+		ReplyCount  int
+		LastReplyAt *time.Time
+	}
+	if err := tx.Model(&models.Message{}).Select("COUNT(*) AS reply_count, MAX(sent_at) AS last_reply_at").
+		Where("thread_id = ?", rootID).Scan(&counters).Error; err != nil {
+		return update, err
+	}
+	update.ReplyCount = counters.ReplyCount
+	update.LastReplyAt = counters.LastReplyAt
+
+	err := tx.Model(&models.Message{}).Where("id = ?", rootID).Updates(map[string]interface{}{
+		"reply_count":   update.ReplyCount,
+		"last_reply_at": update.LastReplyAt,
+	}).Error
+	return update, err
+}
+
+func findMessage(messageID uuid.UUID) (models.Message, error) {
+	var message models.Message
+	if err := db.GetDB().Where("id = ?", messageID).Preload("User").First(&message).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.Message{}, ErrMessageNotFound
+		}
+		return models.Message{}, err
+	}
+	return message, nil
+}
+
+func canSendMessage(userID uuid.UUID, channel models.Channel) (bool, error) {
+	if channel.ServerID != uuid.Nil {
+		return verifyWebSocketPermission(userID, channel.ID, "sendMessage", channel.ServerID)
+	}
+	return permissions.GroupMember(userID, channel.ID)
+}
+
+// hasServerPermission tells whether the roles of userID on serverID grant
+// the boolean permission label.
+func hasServerPermission(userID uuid.UUID, serverID uuid.UUID, label string) (bool, error) {
+	decision, err := permissions.Check(userID, serverID, label)
+	if errors.Is(err, permissions.ErrServerNotFound) {
+		return false, nil
+	}
+	return decision.Allowed, err
+}
+
+// messageError maps a message service error to an HTTP status and a protocol
+// error code.
+func messageError(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrInvalidMessage), errors.Is(err, ErrInvalidReply):
+		return http.StatusBadRequest, protocol.CodeInvalidPayload
+	case errors.Is(err, ErrChannelNotFound), errors.Is(err, ErrMessageNotFound):
+		return http.StatusNotFound, protocol.CodeNotFound
+	case errors.Is(err, ErrMessageForbidden), errors.Is(err, ErrNotMessageAuthor), errors.Is(err, ErrCannotDeleteMessage):
+		return http.StatusForbidden, protocol.CodeForbidden
+	default:
+		return http.StatusInternalServerError, protocol.CodeInternal
+	}
+}
+
+func PostMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input messageInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		message, err := CreateMessage(userID, input.ChannelID, MessageDraft{
+			Content:   input.Content,
+			Type:      input.Type,
+			ReplyToID: input.ReplyToID,
+			ThreadID:  input.ThreadID,
+		})
+		if err != nil {
+			status, _ := messageError(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, message)
+	}
+}
+
 func GetMessageReactions() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		messageIDStr := c.Param("id")
@@ -50,3 +406,134 @@ func GetMessageReactions() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"data": reactMessages, "count": reactionsCount})
 	}
 }
+
+func UpdateMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de message invalide"})
+			return
+		}
+
+		var input messageInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		message, err := EditMessage(userID, messageID, input.Content)
+		if err != nil {
+			status, _ := messageError(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, message)
+	}
+}
+
+func RemoveMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de message invalide"})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := DeleteMessage(userID, messageID); err != nil {
+			status, _ := messageError(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Message supprimé"})
+	}
+}
+
+// GetMessage returns a message of a channel the logged-in user can read.
+func GetMessage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		messageID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de message invalide"})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		message, err := findMessage(messageID)
+		if err != nil {
+			status, _ := messageError(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		if allowed, err := canAccessChannel(userID, message.ChannelID); err != nil || !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrCannotReadChannel.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, message)
+	}
+}
+
+func GetMessageThread() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rootID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de message invalide"})
+			return
+		}
+
+		cursor, err := helpers.ParseCursor(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		root, err := findMessage(rootID)
+		if err != nil {
+			status, _ := messageError(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		if allowed, err := canAccessChannel(userID, root.ChannelID); err != nil || !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrCannotReadChannel.Error()})
+			return
+		}
+
+		replies, err := pageMessages(func() *gorm.DB {
+			return db.GetDB().Where("thread_id = ?", rootID)
+		}, cursor)
+		if err != nil {
+			status, _ := messageError(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, replies)
+	}
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/permissions"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ExplainMemberPermissions explains why userID has or lacks permission on
+// serverID, in channelID if it is not nil. Without a permission, every channel
+// permission is explained in a channel and every other one on the server.
+func ExplainMemberPermissions(serverID uuid.UUID, userID uuid.UUID, channelID *uuid.UUID, permission string) (permissions.Explanation, error) {
+	var labels []string
+	if err := db.GetDB().Model(&models.Permissions{}).Pluck("label", &labels).Error; err != nil {
+		return permissions.Explanation{}, err
+	}
+
+	var perms []string
+	switch {
+	case permission != "":
+		known := permission == permissions.Admin
+		for _, label := range labels {
+			known = known || label == permission
+		}
+		if !known {
+			return permissions.Explanation{}, ErrUnknownPermission
+		}
+		perms = []string{permission}
+	case channelID != nil:
+		for label := range permissions.ChannelPermissions {
+			perms = append(perms, label)
+		}
+	default:
+		for _, label := range labels {
+			if !permissions.ChannelPermissions[label] {
+				perms = append(perms, label)
+			}
+		}
+	}
+	sort.Strings(perms)
+
+	return permissions.Explain(userID, serverID, channelID, perms)
+}
+
+// ExplainPermissions godoc
+// @Summary Explain the permissions of a member
+// @Description Details how the permissions of a member are decided on a server or in one of its channels: the power of each role, the threshold of the channel, the overwrite applied and the final decision.
+// @Tags servers
+// @Produce json
+// @Param id path string true "Server ID"
+// @Param userID path string true "User ID"
+// @Param channel_id query string false "Channel ID"
+// @Param permission query string false "Permission label"
+// @Success 200 {object} permissions.Explanation
+// @Failure 400 {object} models.ErrorServerResponse
+// @Failure 404 {object} models.ErrorServerResponse
+// @Router /servers/{id}/members/{userID}/permissions [get]
+func ExplainPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+			return
+		}
+
+		userID, err := uuid.Parse(c.Param("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var channelID *uuid.UUID
+		if raw := c.Query("channel_id"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+				return
+			}
+			channelID = &id
+		}
+
+		explanation, err := ExplainMemberPermissions(serverID, userID, channelID, c.Query("permission"))
+		switch {
+		case err == nil:
+			c.JSON(http.StatusOK, explanation)
+		case errors.Is(err, ErrUnknownPermission):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, permissions.ErrServerNotFound), errors.Is(err, permissions.ErrChannelNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
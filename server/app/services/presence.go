@@ -0,0 +1,102 @@
+package services
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/hub"
+	"app/presence"
+	"app/protocol"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// typingTTL is how long a typing indicator lasts without a new typing_start.
+const typingTTL = 8 * time.Second
+
+// trackPresence marks the connection connID of userID as active and returns
+// the function to call when it closes.
+func trackPresence(userID uuid.UUID, connID string) func() {
+	updatePresence(userID, connID, false)
+	return func() {
+		status, changed, err := presence.GetTracker().Disconnect(userID, connID)
+		if err != nil {
+			log.Println("Error updating presence:", err)
+			return
+		}
+		if changed {
+			broadcastPresence(userID, status)
+		}
+	}
+}
+
+func updatePresence(userID uuid.UUID, connID string, idle bool) {
+	status, changed, err := presence.GetTracker().Update(userID, connID, idle)
+	if err != nil {
+		log.Println("Error updating presence:", err)
+		return
+	}
+	if changed {
+		broadcastPresence(userID, status)
+	}
+}
+
+// broadcastPresence tells the friends of userID and the servers they share
+// about a status change.
+func broadcastPresence(userID uuid.UUID, status presence.Status) {
+	event := protocol.PresenceUpdate{UserID: userID, Status: string(status)}
+
+	var friends []models.Friend
+	if err := db.GetDB().Where("(user_id1 = ? OR user_id2 = ?) AND status = ?", userID, userID, "accepted").Find(&friends).Error; err != nil {
+		log.Println("Error loading friends for presence:", err)
+	}
+	for _, friend := range friends {
+		friendID := friend.UserID1
+		if friendID == userID {
+			friendID = friend.UserID2
+		}
+		publishEvent(hub.UserTopic(friendID), protocol.EventPresenceUpdate, event)
+	}
+
+	var serverIDs []uuid.UUID
+	if err := db.GetDB().Model(&models.OnServer{}).Where("user_id = ?", userID).Pluck("server_id", &serverIDs).Error; err != nil {
+		log.Println("Error loading servers for presence:", err)
+	}
+	for _, serverID := range serverIDs {
+		publishEvent(hub.ServerTopic(serverID), protocol.EventPresenceUpdate, event)
+	}
+}
+
+// handleHeartbeat refreshes presence from an optional idle flag and acks.
+func handleHeartbeat(client *hub.Client, connID string, frame protocol.Frame) {
+	var heartbeat protocol.Heartbeat
+	if len(frame.Data) > 0 {
+		if err := frame.DecodeData(&heartbeat); err != nil {
+			sendError(client, protocol.CodeInvalidPayload, err.Error(), frame.Nonce)
+			return
+		}
+	}
+	updatePresence(client.UserID, connID, heartbeat.Idle)
+	sendEvent(client, protocol.EventHeartbeatAck, protocol.HeartbeatAck{At: time.Now()})
+}
+
+// typingThrottle drops repeated typing_start frames from one connection so a
+// client cannot flood a channel. It is only used from a ReadPump goroutine.
+type typingThrottle map[uuid.UUID]time.Time
+
+func (t typingThrottle) allow(channelID uuid.UUID, now time.Time) bool {
+	if last, ok := t[channelID]; ok && now.Sub(last) < typingTTL/2 {
+		return false
+	}
+	t[channelID] = now
+	return true
+}
+
+func publishTyping(userID uuid.UUID, channelID uuid.UUID) {
+	publishEvent(hub.ChannelTopic(channelID), protocol.EventTypingStart, protocol.Typing{
+		ChannelID: channelID,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(typingTTL),
+	})
+}
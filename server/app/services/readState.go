@@ -0,0 +1,226 @@
+package services
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/helpers"
+	"app/hub"
+	"app/protocol"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrCannotReadChannel = errors.New("you cannot access this channel")
+
+// UnreadCount is what a user has not read yet in one channel. Mentions are
+// unread messages containing "@pseudo".
+type UnreadCount struct {
+	UnreadCount  int64
+	MentionCount int64
+}
+
+type channelWithUnread struct {
+	models.Channel
+	UnreadCount
+}
+
+type groupReadState struct {
+	UserID            uuid.UUID
+	LastReadMessageID uuid.UUID
+	LastReadAt        time.Time
+}
+
+type groupWithUnread struct {
+	models.Group
+	UnreadCount
+	SeenBy []groupReadState
+}
+
+// AckChannel moves the read marker of userID in channelID up to messageID.
+// Older acks are ignored so sessions acking out of order cannot rewind it.
+func AckChannel(userID uuid.UUID, channelID uuid.UUID, messageID uuid.UUID) (models.ReadState, error) {
+	allowed, err := canAccessChannel(userID, channelID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.ReadState{}, err
+	}
+	if !allowed {
+		return models.ReadState{}, ErrCannotReadChannel
+	}
+
+	message, err := findMessage(messageID)
+	if err != nil {
+		return models.ReadState{}, err
+	}
+	if message.ChannelID != channelID {
+		return models.ReadState{}, ErrMessageNotFound
+	}
+
+	var state models.ReadState
+	err = db.GetDB().Where("user_id = ? AND channel_id = ?", userID, channelID).First(&state).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		state = models.ReadState{UserID: userID, ChannelID: channelID}
+	case err != nil:
+		return models.ReadState{}, err
+	case !message.CreatedAt.After(state.LastReadAt):
+		return state, nil
+	}
+
+	state.LastReadMessageID = message.ID
+	state.LastReadAt = message.CreatedAt
+	if err := db.GetDB().Save(&state).Error; err != nil {
+		return models.ReadState{}, err
+	}
+
+	event := protocol.ReadState{ChannelID: channelID, UserID: userID, MessageID: message.ID, ReadAt: message.CreatedAt}
+	publishEvent(hub.UserTopic(userID), protocol.EventReadAck, event)
+
+	var channel models.Channel
+	if err := db.GetDB().Where("id = ?", channelID).First(&channel).Error; err == nil && channel.ServerID == uuid.Nil {
+		publishEvent(hub.ChannelTopic(channelID), protocol.EventMessageSeen, event)
+	}
+
+	return state, nil
+}
+
+// unreadCounts computes unread and mention counts of userID for channelIDs in
+// one query. Channels without unread messages are absent from the result.
+func unreadCounts(userID uuid.UUID, channelIDs []uuid.UUID) (map[uuid.UUID]UnreadCount, error) {
+	counts := make(map[uuid.UUID]UnreadCount)
+	if len(channelIDs) == 0 {
+		return counts, nil
+	}
+
+	var user models.User
+	if err := db.GetDB().Select("id", "pseudo").Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+	mention := "%@" + escapeLike(user.Pseudo) + "%"
+
+	var rows []struct {
+		ChannelID    uuid.UUID
+		UnreadCount  int64
+		MentionCount int64
+	}
+	err := db.GetDB().Table("messages").
+		Select("messages.channel_id, COUNT(*) AS unread_count, COUNT(*) FILTER (WHERE messages.content ILIKE ?) AS mention_count", mention).
+		Joins("LEFT JOIN read_states ON read_states.channel_id = messages.channel_id AND read_states.user_id = ? AND read_states.deleted_at IS NULL", userID).
+		Where("messages.channel_id IN ? AND messages.deleted_at IS NULL AND messages.thread_id IS NULL AND messages.user_id <> ?", channelIDs, userID).
+		Where("read_states.last_read_at IS NULL OR messages.created_at > read_states.last_read_at").
+		Group("messages.channel_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		counts[row.ChannelID] = UnreadCount{UnreadCount: row.UnreadCount, MentionCount: row.MentionCount}
+	}
+	return counts, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func withUnread(userID uuid.UUID, channels []models.Channel) ([]channelWithUnread, error) {
+	ids := make([]uuid.UUID, 0, len(channels))
+	for _, channel := range channels {
+		ids = append(ids, channel.ID)
+	}
+
+	counts, err := unreadCounts(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]channelWithUnread, 0, len(channels))
+	for _, channel := range channels {
+		result = append(result, channelWithUnread{Channel: channel, UnreadCount: counts[channel.ID]})
+	}
+	return result, nil
+}
+
+func groupsWithUnread(userID uuid.UUID, groups []models.Group) ([]groupWithUnread, error) {
+	ids := make([]uuid.UUID, 0, len(groups))
+	for _, group := range groups {
+		ids = append(ids, group.ChannelID)
+	}
+
+	counts, err := unreadCounts(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]groupWithUnread, 0, len(groups))
+	for _, group := range groups {
+		seen, err := seenBy(userID, group)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, groupWithUnread{Group: group, UnreadCount: counts[group.ChannelID], SeenBy: seen})
+	}
+	return result, nil
+}
+
+// seenBy returns the read markers of the members of a group other than
+// userID.
+func seenBy(userID uuid.UUID, group models.Group) ([]groupReadState, error) {
+	var states []models.ReadState
+	if err := db.GetDB().Where("channel_id = ? AND user_id <> ?", group.ChannelID, userID).Find(&states).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]groupReadState, 0, len(states))
+	for _, state := range states {
+		result = append(result, groupReadState{
+			UserID:            state.UserID,
+			LastReadMessageID: state.LastReadMessageID,
+			LastReadAt:        state.LastReadAt,
+		})
+	}
+	return result, nil
+}
+
+func AckChannelHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		var input struct {
+			MessageID uuid.UUID `json:"messageId" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		state, err := AckChannel(userID, channelID, input.MessageID)
+		if err != nil {
+			if errors.Is(err, ErrCannotReadChannel) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			status, _ := messageError(err)
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, state)
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/helpers"
+	"app/hub"
+	"app/protocol"
+	"app/sfu"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// recordingDir is the upload directory, served under /uploads.
+const recordingDir = "upload"
+
+// StartRecording records every participant of a server voice channel until
+// StopRecording. Participants are told through a recording_start event.
+func StartRecording(userID uuid.UUID, channelID uuid.UUID) (protocol.Recording, error) {
+	channel, err := moderatedVoiceChannel(userID, channelID, "recordVoice")
+	if err != nil {
+		return protocol.Recording{}, err
+	}
+
+	recording, err := getSFU().StartRecording(channelID, userID, recordingDir)
+	if err != nil {
+		return protocol.Recording{}, err
+	}
+
+	payload := recordingPayload(recording, channel.ServerID)
+	publishEvent(hub.ChannelTopic(channelID), protocol.EventRecordingStart, payload)
+	publishEvent(hub.ServerTopic(channel.ServerID), protocol.EventRecordingStart, payload)
+	return payload, nil
+}
+
+// StopRecording stores one Media per recorded participant and posts a system
+// message linking the files in the channel.
+func StopRecording(userID uuid.UUID, channelID uuid.UUID) (protocol.Recording, error) {
+	channel, err := moderatedVoiceChannel(userID, channelID, "recordVoice")
+	if err != nil {
+		return protocol.Recording{}, err
+	}
+
+	recording, files, err := getSFU().StopRecording(channelID)
+	if err != nil {
+		return protocol.Recording{}, err
+	}
+
+	payload := recordingPayload(recording, channel.ServerID)
+	payload.UserID = userID
+
+	if len(files) > 0 {
+		var author models.User
+		message := models.Message{
+			Type:      "system",
+			SentAt:    time.Now().UTC(),
+			UserID:    userID,
+			ChannelID: channelID,
+		}
+		links := make([]string, 0, len(files))
+
+		err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+			for _, file := range files {
+				media := models.Media{FileName: filepath.Base(file.Path), MimeType: "audio/ogg", UserID: file.UserID}
+				if err := tx.Create(&media).Error; err != nil {
+					return err
+				}
+				payload.Media = append(payload.Media, media.ID)
+				links = append(links, "/uploads/"+media.FileName)
+			}
+
+			message.Content = "Enregistrement du salon vocal : " + strings.Join(links, " ")
+			if err := tx.Create(&message).Error; err != nil {
+				return err
+			}
+			return tx.Where("id = ?", userID).First(&author).Error
+		})
+		if err != nil {
+			return protocol.Recording{}, err
+		}
+
+		payload.MessageID = &message.ID
+		publishEvent(hub.ChannelTopic(channelID), protocol.EventMessageCreate, messagePayload(message, author))
+	}
+
+	publishEvent(hub.ChannelTopic(channelID), protocol.EventRecordingStop, payload)
+	publishEvent(hub.ServerTopic(channel.ServerID), protocol.EventRecordingStop, payload)
+	return payload, nil
+}
+
+func recordingPayload(recording *sfu.Recording, serverID uuid.UUID) protocol.Recording {
+	return protocol.Recording{
+		ChannelID: recording.RoomID,
+		ServerID:  serverID,
+		UserID:    recording.StartedBy,
+		StartedAt: recording.StartedAt,
+	}
+}
+
+func recordingError(err error) int {
+	switch {
+	case errors.Is(err, ErrVoiceForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrChannelNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNotVoiceChannel):
+		return http.StatusBadRequest
+	case errors.Is(err, sfu.ErrAlreadyRecording), errors.Is(err, sfu.ErrNotRecording):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func StartRecordingHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		recording, err := StartRecording(userID, channelID)
+		if err != nil {
+			c.JSON(recordingError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, recording)
+	}
+}
+
+func StopRecordingHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		recording, err := StopRecording(userID, channelID)
+		if err != nil {
+			c.JSON(recordingError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, recording)
+	}
+}
@@ -3,8 +3,10 @@ package services
 import (
 	"app/db"
 	"app/db/models"
+	"app/helpers"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 	"log"
 	"net/http"
 )
@@ -27,8 +29,14 @@ func getReportsByServerAndStatus(c *gin.Context, status string) {
 
 	var reports []models.Report
 	result := db.GetDB().
-		Preload("ReportedMessage").
+		Preload("ReportedMessage", func(db *gorm.DB) *gorm.DB {
+			return db.Unscoped()
+		}).
 		Preload("ReportedMessage.User").
+		Preload("ReportedMessage.Revisions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at")
+		}).
+		Preload("ReportedMessage.Revisions.Editor").
 		Preload("Reporter").
 		Where("server_id = ? AND status = ?", serverID, status).
 		Find(&reports)
@@ -51,3 +59,57 @@ func GetFinishedReportsByServer() gin.HandlerFunc {
 		getReportsByServerAndStatus(c, "finished")
 	}
 }
+
+// CreateReport files a report of the logged-in user on a server they belong
+// to. A reported message must come from a channel of that server.
+func CreateReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input models.ReportCreatePayload
+		if err := c.ShouldBindJSON(&input); err != nil {
+			handleError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			handleError(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		member, err := isServerMember(userID, input.ServerID)
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, "Error checking membership")
+			return
+		}
+		if !member {
+			handleError(c, http.StatusForbidden, "You are not a member of this server")
+			return
+		}
+
+		if input.MessageID != nil {
+			var channel models.Channel
+			err := db.GetDB().Joins("JOIN messages ON messages.channel_id = channels.id").
+				Where("messages.id = ? AND messages.deleted_at IS NULL", *input.MessageID).
+				First(&channel).Error
+			if err != nil || channel.ServerID != input.ServerID {
+				handleError(c, http.StatusNotFound, "Message not found on this server")
+				return
+			}
+		}
+
+		report := models.Report{
+			Message:    input.Message,
+			Status:     "pending",
+			MessageID:  input.MessageID,
+			UserID:     userID,
+			ReportedID: input.ReportedID,
+			ServerID:   input.ServerID,
+		}
+		if err := db.GetDB().Create(&report).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, "Error creating report")
+			return
+		}
+
+		c.JSON(http.StatusCreated, report)
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"app/db"
 	"app/db/models"
+	"app/helpers"
 	"log"
 	"net/http"
 
@@ -20,7 +21,7 @@ func GetByServer(factory ModelFactory) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Factory function returned nil"})
 			return
 		}
-		if err := db.GetDB().Where("server_id = ?", serverID).Find(role).Error; err != nil {
+		if err := db.GetDB().Where("server_id = ?", serverID).Order("position DESC, created_at").Find(role).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -38,14 +39,151 @@ func AddRoleToServer(factory ModelFactory) gin.HandlerFunc {
 		}
 		serverIDInt, _ := uuid.Parse(serverID)
 		role.(*models.Role).ServerID = serverIDInt
-		if err := db.GetDB().Create(role).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+
+		actorID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if err := CreateServerRole(actorID, role.(*models.Role)); err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, role)
+	}
+}
+
+// CreateRole adds a role to the server given by its ServerID, below the
+// highest role of the logged in user.
+func CreateRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role models.Role
+		if err := c.ShouldBindJSON(&role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		actorID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if err := CreateServerRole(actorID, &role); err != nil {
+			roleError(c, err)
 			return
 		}
 		c.JSON(http.StatusCreated, role)
 	}
 }
 
+// UpdateRole godoc
+// @Summary Rename a role
+// @Description Only roles below the highest role of the user can be renamed.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param id path string true "Role ID"
+// @Param payload body models.RoleUpdatePayload true "Role"
+// @Success 200 {object} models.Role
+// @Failure 403 {object} models.ErrorUserResponse
+// @Router /roles/{id} [put]
+func UpdateRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roleID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+			return
+		}
+
+		var payload models.RoleUpdatePayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		actorID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		role, err := UpdateServerRole(actorID, roleID, payload)
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+// DeleteRole godoc
+// @Summary Delete a role
+// @Description Only roles below the highest role of the user can be deleted. Members lose the role.
+// @Tags roles
+// @Produce json
+// @Param id path string true "Role ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 403 {object} models.ErrorUserResponse
+// @Router /roles/{id} [delete]
+func DeleteRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roleID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+			return
+		}
+
+		actorID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if err := DeleteServerRole(actorID, roleID); err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, models.SuccessResponse{Message: "Role deleted"})
+	}
+}
+
+// ReorderRoles godoc
+// @Summary Move roles of a server
+// @Description Sets the position of the given roles, the highest comes first. Roles can only be moved below the highest role of the user.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param id path string true "Server ID"
+// @Param payload body models.RolePositionsPayload true "Positions"
+// @Success 200 {array} models.Role
+// @Failure 403 {object} models.ErrorUserResponse
+// @Router /servers/{id}/roles/positions [put]
+func ReorderRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+			return
+		}
+
+		var payload models.RolePositionsPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		actorID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		roles, err := ReorderServerRoles(actorID, serverID, payload.Roles)
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, roles)
+	}
+}
+
 type PermissionResponse struct {
 	Label string `json:"label"`
 	Power int    `json:"power"`
@@ -80,17 +218,32 @@ func UpdateRolePermissions(c *gin.Context) {
 		return
 	}
 
+	actorID, err := helpers.GetLoggedInUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	actor, err := loadPermissionEditor(actorID, roleUUID)
+	if err != nil {
+		roleError(c, err)
+		return
+	}
+
 	availablePermissions := map[string]struct{}{
-		"createChannel": {},
-		"sendMessage":   {},
-		"accessChannel": {},
-		"banUser":       {},
-		"kickUser":      {},
-		"createRole":    {},
-		"accessLog":     {},
-		"accessReport":  {},
-		"profileServer": {},
-		"editChannel":   {},
+		"createChannel":  {},
+		"sendMessage":    {},
+		"accessChannel":  {},
+		"banUser":        {},
+		"kickUser":       {},
+		"createRole":     {},
+		"accessLog":      {},
+		"accessReport":   {},
+		"profileServer":  {},
+		"editChannel":    {},
+		"manageMessages": {},
+		"muteMembers":    {},
+		"moveMembers":    {},
+		"recordVoice":    {},
 	}
 
 	var requestBody map[string]int
@@ -143,6 +296,13 @@ func UpdateRolePermissions(c *gin.Context) {
 			return
 		}
 
+		// Keeping a power the role already has is not a grant
+		if power != rolePermission.Power && !actor.CanGrant(label, power) {
+			tx.Rollback()
+			roleError(c, ErrPowerAboveActor)
+			return
+		}
+
 		rolePermission.Power = power
 		if err := tx.Save(&rolePermission).Error; err != nil {
 			tx.Rollback()
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/permissions"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInsufficientPermissions = errors.New("insufficient permissions")
+	ErrRoleNotOnServer         = errors.New("role not found on this server")
+	ErrRoleNotHeld             = errors.New("the user does not have this role")
+	ErrRoleAboveActor          = errors.New("you can only manage roles below your highest role")
+	ErrPowerAboveActor         = errors.New("you can only grant a role up to your own power for each permission")
+	ErrMemberNotOnServer       = errors.New("user is not a member of this server")
+	ErrMemberAboveActor        = errors.New("you can only act on members ranked below you")
+	ErrInvalidRolePosition     = errors.New("role positions must be 0 or more, once per role")
+	ErrReservedRoleLabel       = errors.New("the admin label is reserved to the role created with the server")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication required")
+)
+
+// loadActor reads actorID as a member of serverID and checks that they hold
+// permission there, with 2FA if the server requires it. Some role routes name
+// their server in a way the permission middlewares cannot read, so this is
+// their only check.
+func loadActor(actorID uuid.UUID, serverID uuid.UUID, permission string) (permissions.Member, error) {
+	actor, err := permissions.LoadMember(actorID, serverID)
+	if err != nil {
+		return actor, err
+	}
+	if !actor.Evaluate(permission, nil).Allowed {
+		return actor, ErrInsufficientPermissions
+	}
+	missing, err := controllers.MissingTwoFactor(serverID, actorID, permission)
+	if err != nil {
+		return actor, err
+	}
+	if missing {
+		return actor, ErrTwoFactorRequired
+	}
+	return actor, nil
+}
+
+// checkOutranks returns ErrMemberAboveActor unless targetID is a member of the
+// server of actor ranked below them.
+func checkOutranks(actor permissions.Member, targetID uuid.UUID) error {
+	target, err := permissions.LoadMember(targetID, actor.ServerID)
+	if err != nil {
+		return err
+	}
+	if !target.IsMember {
+		return ErrMemberNotOnServer
+	}
+	if !actor.Outranks(target) {
+		return ErrMemberAboveActor
+	}
+	return nil
+}
+
+// CheckModeration tells whether actorID may kick or ban targetID on serverID.
+// The permission itself is checked by the route, this only compares ranks.
+func CheckModeration(actorID uuid.UUID, serverID uuid.UUID, targetID uuid.UUID) error {
+	actor, err := permissions.LoadMember(actorID, serverID)
+	if err != nil {
+		return err
+	}
+	return checkOutranks(actor, targetID)
+}
+
+// loadManagedRole reads roleID and checks that actorID may manage it: they
+// need createRole on its server and a higher role.
+func loadManagedRole(actorID uuid.UUID, roleID uuid.UUID) (models.Role, error) {
+	var role models.Role
+	if err := db.GetDB().First(&role, "id = ?", roleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return role, permissions.ErrRoleNotFound
+		}
+		return role, err
+	}
+
+	actor, err := loadActor(actorID, role.ServerID, "createRole")
+	if err != nil {
+		return role, err
+	}
+	if !actor.CanManageRole(role.Position) {
+		return role, ErrRoleAboveActor
+	}
+	return role, nil
+}
+
+// loadPermissionEditor checks that actorID may manage roleID and returns them
+// as a member of its server, whose powers cap what they give the role.
+func loadPermissionEditor(actorID uuid.UUID, roleID uuid.UUID) (permissions.Member, error) {
+	role, err := loadManagedRole(actorID, roleID)
+	if err != nil {
+		return permissions.Member{}, err
+	}
+	return permissions.LoadMember(actorID, role.ServerID)
+}
+
+// CreateServerRole adds role to its server, below the highest role of actorID.
+func CreateServerRole(actorID uuid.UUID, role *models.Role) error {
+	actor, err := loadActor(actorID, role.ServerID, "createRole")
+	if err != nil {
+		return err
+	}
+	if role.Label == permissions.AdminRole {
+		return ErrReservedRoleLabel
+	}
+	if role.Position < 0 {
+		return ErrInvalidRolePosition
+	}
+	if !actor.CanManageRole(role.Position) {
+		return ErrRoleAboveActor
+	}
+	return db.GetDB().Create(role).Error
+}
+
+// UpdateServerRole renames roleID.
+func UpdateServerRole(actorID uuid.UUID, roleID uuid.UUID, payload models.RoleUpdatePayload) (models.Role, error) {
+	role, err := loadManagedRole(actorID, roleID)
+	if err != nil {
+		return role, err
+	}
+	if (payload.Label == permissions.AdminRole) != (role.Label == permissions.AdminRole) {
+		return role, ErrReservedRoleLabel
+	}
+
+	role.Label = payload.Label
+	err = db.GetDB().Model(&role).UpdateColumn("label", role.Label).Error
+	return role, err
+}
+
+// DeleteServerRole removes roleID from its server and from its members.
+func DeleteServerRole(actorID uuid.UUID, roleID uuid.UUID) error {
+	if _, err := loadManagedRole(actorID, roleID); err != nil {
+		return err
+	}
+
+	return db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", roleID).Delete(&models.RoleUser{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", roleID).Delete(&models.ChannelOverwrite{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Role{}, "id = ?", roleID).Error
+	})
+}
+
+// ReorderServerRoles moves roles of serverID to new positions. Every role
+// moved must stay below the highest role of actorID.
+func ReorderServerRoles(actorID uuid.UUID, serverID uuid.UUID, positions []models.RolePosition) ([]models.Role, error) {
+	actor, err := loadActor(actorID, serverID, "createRole")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uuid.UUID]bool, len(positions))
+	ids := make([]uuid.UUID, 0, len(positions))
+	for _, position := range positions {
+		if seen[position.ID] || *position.Position < 0 {
+			return nil, ErrInvalidRolePosition
+		}
+		seen[position.ID] = true
+		ids = append(ids, position.ID)
+	}
+
+	var roles []models.Role
+	if err := db.GetDB().Where("id IN ? AND server_id = ?", ids, serverID).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	if len(roles) != len(ids) {
+		return nil, ErrRoleNotOnServer
+	}
+	current := make(map[uuid.UUID]int, len(roles))
+	for _, role := range roles {
+		current[role.ID] = role.Position
+	}
+	for _, position := range positions {
+		if !actor.CanManageRole(current[position.ID]) || !actor.CanManageRole(*position.Position) {
+			return nil, ErrRoleAboveActor
+		}
+	}
+
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		for _, position := range positions {
+			if err := tx.Model(&models.Role{}).Where("id = ?", position.ID).UpdateColumn("position", *position.Position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roles = []models.Role{}
+	err = db.GetDB().Where("server_id = ?", serverID).Order("position DESC, created_at").Find(&roles).Error
+	return roles, err
+}
+
+// AssignRole gives roleID to userID. The role and the member must both rank
+// below actorID.
+func AssignRole(actorID uuid.UUID, serverID uuid.UUID, roleID uuid.UUID, userID uuid.UUID) error {
+	role, err := roleAssignment(actorID, serverID, roleID, userID)
+	if err != nil {
+		return err
+	}
+
+	// Un membre peut cumuler plusieurs rôles : on ajoute seulement celui-ci
+	var held int64
+	if err := db.GetDB().Model(&models.RoleUser{}).Where("user_id = ? AND role_id = ?", userID, role.ID).Count(&held).Error; err != nil {
+		return err
+	}
+	if held > 0 {
+		return nil
+	}
+	return db.GetDB().Create(&models.RoleUser{UserID: userID, RoleID: role.ID}).Error
+}
+
+// UnassignRole takes roleID away from userID, who keeps their other roles.
+func UnassignRole(actorID uuid.UUID, serverID uuid.UUID, roleID uuid.UUID, userID uuid.UUID) error {
+	if _, err := roleAssignment(actorID, serverID, roleID, userID); err != nil {
+		return err
+	}
+
+	result := db.GetDB().Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&models.RoleUser{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRoleNotHeld
+	}
+	return nil
+}
+
+// roleAssignment checks that actorID may give or take roleID of serverID
+// to or from userID.
+func roleAssignment(actorID uuid.UUID, serverID uuid.UUID, roleID uuid.UUID, userID uuid.UUID) (models.Role, error) {
+	var role models.Role
+	if err := db.GetDB().Where("id = ? AND server_id = ?", roleID, serverID).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return role, ErrRoleNotOnServer
+		}
+		return role, err
+	}
+
+	actor, err := loadActor(actorID, serverID, "createRole")
+	if err != nil {
+		return role, err
+	}
+	if !actor.CanManageRole(role.Position) {
+		return role, ErrRoleAboveActor
+	}
+	return role, checkOutranks(actor, userID)
+}
+
+func roleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidRolePosition), errors.Is(err, ErrReservedRoleLabel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTwoFactorRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "two_factor_required"})
+	case errors.Is(err, ErrInsufficientPermissions), errors.Is(err, ErrRoleAboveActor), errors.Is(err, ErrMemberAboveActor),
+		errors.Is(err, ErrPowerAboveActor):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrRoleNotOnServer), errors.Is(err, ErrRoleNotHeld), errors.Is(err, ErrMemberNotOnServer),
+		errors.Is(err, permissions.ErrRoleNotFound), errors.Is(err, permissions.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
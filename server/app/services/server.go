@@ -3,6 +3,11 @@ package services
 import (
 	"app/db"
 	"app/db/models"
+	"app/helpers"
+	"app/hub"
+	"app/permissions"
+	"app/presence"
+	"app/protocol"
 	"errors"
 	"fmt"
 	"net/http"
@@ -166,8 +171,8 @@ func BanUser() gin.HandlerFunc {
 			return
 		}
 
-		if server.UserID != bannedByID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only the server creator can ban users"})
+		if err := CheckModeration(bannedByID, serverUUID, userUUID); err != nil {
+			roleError(c, err)
 			return
 		}
 
@@ -193,11 +198,20 @@ func BanUser() gin.HandlerFunc {
 			return
 		}
 
+		if err := removeServerRoles(tx, serverUUID, userUUID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
 		if err := tx.Commit().Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		hub.GetHub().UnsubscribeUser(userUUID, hub.ServerTopic(serverUUID))
+		publishEvent(hub.ServerTopic(serverUUID), protocol.EventMemberLeave, protocol.Member{ServerID: serverUUID, UserID: userUUID})
+
 		c.JSON(http.StatusOK, ban)
 	}
 }
@@ -537,6 +551,7 @@ func NewServer() gin.HandlerFunc {
 		adminRole := models.Role{
 			ServerID: inputServer.ID,
 			Label:    "admin",
+			Position: 1,
 		}
 		if err := tx.Create(&adminRole).Error; err != nil {
 			tx.Rollback()
@@ -555,10 +570,11 @@ func NewServer() gin.HandlerFunc {
 			return
 		}
 
-		// Création du rôle "membre"
+		// Création du rôle "membre", tout en bas de la hiérarchie
 		memberRole := models.Role{
 			ServerID: inputServer.ID,
 			Label:    "membre",
+			Position: 0,
 		}
 		if err := tx.Create(&memberRole).Error; err != nil {
 			tx.Rollback()
@@ -618,6 +634,16 @@ func KickUser() gin.HandlerFunc {
 			return
 		}
 
+		actorID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if err := CheckModeration(actorID, serverUUID, userUUID); err != nil {
+			roleError(c, err)
+			return
+		}
+
 		tx := db.GetDB().Begin()
 
 		if err := tx.Where("server_id = ? AND user_id = ?", serverUUID, userUUID).Delete(&models.OnServer{}).Error; err != nil {
@@ -626,11 +652,20 @@ func KickUser() gin.HandlerFunc {
 			return
 		}
 
+		if err := removeServerRoles(tx, serverUUID, userUUID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
 		if err := tx.Commit().Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		hub.GetHub().UnsubscribeUser(userUUID, hub.ServerTopic(serverUUID))
+		publishEvent(hub.ServerTopic(serverUUID), protocol.EventMemberLeave, protocol.Member{ServerID: serverUUID, UserID: userUUID})
+
 		c.JSON(http.StatusOK, gin.H{"message": "User kicked from server"})
 	}
 }
@@ -743,6 +778,13 @@ func JoinServer() gin.HandlerFunc {
 
 		tx.Commit()
 
+		publishEvent(hub.ServerTopic(serverID), protocol.EventMemberJoin, protocol.Member{
+			ServerID: serverID,
+			UserID:   userID,
+			Pseudo:   user.Pseudo,
+			Profile:  user.Profile,
+		})
+
 		c.JSON(http.StatusOK, gin.H{"data": onServer})
 	}
 }
@@ -851,17 +893,17 @@ func LeaveServer() gin.HandlerFunc {
 			return
 		}
 
-		var roleUser models.RoleUser
-		if err := tx.Where("role_id IN (SELECT id FROM roles WHERE server_id = ?) AND user_id = ?", serverID, userID).First(&roleUser).Error; err == nil {
-			if err := tx.Delete(&roleUser).Error; err != nil {
-				tx.Rollback()
-				handleError(c, http.StatusInternalServerError, "Erreur lors de la suppression de l'association rôle-utilisateur.")
-				return
-			}
+		if err := removeServerRoles(tx, serverID, userID); err != nil {
+			tx.Rollback()
+			handleError(c, http.StatusInternalServerError, "Erreur lors de la suppression de l'association rôle-utilisateur.")
+			return
 		}
 
 		tx.Commit()
 
+		hub.GetHub().UnsubscribeUser(userID, hub.ServerTopic(serverID))
+		publishEvent(hub.ServerTopic(serverID), protocol.EventMemberLeave, protocol.Member{ServerID: serverID, UserID: userID})
+
 		c.JSON(http.StatusOK, gin.H{"data": onServer})
 	}
 }
@@ -946,7 +988,14 @@ func GetServerMembers() gin.HandlerFunc {
 			Pseudo  string    `json:"pseudo"`
 			Role    string    `json:"role"`
 			Profile string    `json:"profile"`
+			Status  string    `json:"status"`
+		}
+
+		userIDs := make([]uuid.UUID, 0, len(users))
+		for _, user := range users {
+			userIDs = append(userIDs, user.ID)
 		}
+		statuses := presence.GetTracker().Statuses(userIDs)
 
 		var response []UserResponse
 		for _, user := range users {
@@ -955,6 +1004,7 @@ func GetServerMembers() gin.HandlerFunc {
 				Pseudo:  user.Pseudo,
 				Role:    user.Role,
 				Profile: user.Profile,
+				Status:  string(statuses[user.ID]),
 			})
 		}
 
@@ -1005,55 +1055,49 @@ func GetServerChannels() gin.HandlerFunc {
 			return
 		}
 
-		// Récupérer le rôle utilisateur et vérifier les permissions
-		var roleUser models.RoleUser
-		if err := db.GetDB().Joins("JOIN roles ON roles.id = role_users.role_id").
-			Where("role_users.user_id = ? AND roles.server_id = ?", userID, serverID).
-			First(&roleUser).Error; err != nil {
-			handleError(c, http.StatusUnauthorized, "User role not found")
+		member, err := permissions.LoadMember(userID, serverID)
+		if err != nil {
+			handleError(c, http.StatusNotFound, "Serveur introuvable")
 			return
 		}
-
-		var rolePermissions []models.RolePermissions
-		if err := db.GetDB().Where("role_id = ?", roleUser.RoleID).Preload("Permissions").Find(&rolePermissions).Error; err != nil {
-			handleError(c, http.StatusUnauthorized, "Role permissions not found")
+		if !member.IsMember {
+			handleError(c, http.StatusUnauthorized, "User role not found")
 			return
 		}
 
-		// Obtenir le niveau de pouvoir pour accessChannel
-		accessChannelPower := -1
-		for _, rp := range rolePermissions {
-			if rp.Permissions.Label == "accessChannel" {
-				accessChannelPower = rp.Power
-				break
-			}
+		var channels []models.Channel
+		if err := db.GetDB().Where("server_id = ? AND type IN ?", serverID, []string{"text", "vocal"}).Find(&channels).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, "Erreur lors de la récupération des canaux du serveur.")
+			return
 		}
 
-		if accessChannelPower == -1 {
-			handleError(c, http.StatusUnauthorized, "Insufficient permissions")
+		rules, err := permissions.LoadChannels(channels)
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, "Erreur lors de la récupération des permissions des canaux.")
 			return
 		}
 
-		// Récupérer les channels en fonction des permissions
-		var textChannels []models.Channel
-		if err := db.GetDB().Joins("JOIN channel_channel_permissions ccp ON channels.id = ccp.channel_id").
-			Joins("JOIN channel_permissions cp ON ccp.channel_permission_id = cp.id").
-			Where("channels.server_id = ? AND channels.type = ? AND cp.label = ? AND ccp.power <= ?", serverID, "text", "accessChannel", accessChannelPower).
-			Find(&textChannels).Error; err != nil {
-			handleError(c, http.StatusInternalServerError, "Erreur lors de la récupération des canaux de texte du serveur.")
-			return
+		// Seuls les canaux accessibles au membre sont renvoyés
+		textChannels := []models.Channel{}
+		voiceChannels := []models.Channel{}
+		for _, channel := range channels {
+			if !member.Evaluate("accessChannel", rules[channel.ID]).Allowed {
+				continue
+			}
+			if channel.Type == "text" {
+				textChannels = append(textChannels, channel)
+			} else {
+				voiceChannels = append(voiceChannels, channel)
+			}
 		}
 
-		var voiceChannels []models.Channel
-		if err := db.GetDB().Joins("JOIN channel_channel_permissions ccp ON channels.id = ccp.channel_id").
-			Joins("JOIN channel_permissions cp ON ccp.channel_permission_id = cp.id").
-			Where("channels.server_id = ? AND channels.type = ? AND cp.label = ? AND ccp.power <= ?", serverID, "vocal", "accessChannel", accessChannelPower).
-			Find(&voiceChannels).Error; err != nil {
-			handleError(c, http.StatusInternalServerError, "Erreur lors de la récupération des canaux vocaux du serveur.")
+		textWithUnread, err := withUnread(userID, textChannels)
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, "Erreur lors du comptage des messages non lus.")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"text": textChannels, "vocal": voiceChannels})
+		c.JSON(http.StatusOK, gin.H{"text": textWithUnread, "vocal": withParticipants(voiceChannels)})
 	}
 }
 
@@ -1283,27 +1327,54 @@ func SetRoleToUser(c *gin.Context) {
 		return
 	}
 
-	userUUID := requestBody.UserID
+	actorID, err := helpers.GetLoggedInUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if err := AssignRole(actorID, serverUUID, roleUUID, requestBody.UserID); err != nil {
+		roleError(c, err)
+		return
+	}
 
-	tx := db.GetDB().Begin()
+	c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully"})
+}
 
-	if err := tx.Where("user_id = ? AND role_id IN (SELECT id FROM roles WHERE server_id = ?)", userUUID, serverUUID).Delete(&models.RoleUser{}).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting old role"})
+// RemoveRoleFromUser takes a role away from a member, who keeps their other
+// roles. Both the role and the member must rank below the logged in user.
+func RemoveRoleFromUser(c *gin.Context) {
+	serverUUID, err := uuid.Parse(c.Param("serverID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
 		return
 	}
 
-	newRoleUser := models.RoleUser{
-		UserID: userUUID,
-		RoleID: roleUUID,
+	roleUUID, err := uuid.Parse(c.Param("roleID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
 	}
 
-	if err := tx.Create(&newRoleUser).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error assigning new role"})
+	userUUID, err := uuid.Parse(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
 
-	tx.Commit()
-	c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully"})
+	actorID, err := helpers.GetLoggedInUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if err := UnassignRole(actorID, serverUUID, roleUUID, userUUID); err != nil {
+		roleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role removed successfully"})
+}
+
+// removeServerRoles takes every role of serverID away from userID.
+func removeServerRoles(tx *gorm.DB, serverID uuid.UUID, userID uuid.UUID) error {
+	return tx.Where("user_id = ? AND role_id IN (SELECT id FROM roles WHERE server_id = ?)", userID, serverID).Delete(&models.RoleUser{}).Error
 }
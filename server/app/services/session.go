@@ -0,0 +1,159 @@
+package services
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/helpers"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ListSessions returns the active sessions of userID, most recently seen
+// first. current marks the session of the caller.
+func ListSessions(userID uuid.UUID, current uuid.UUID) ([]models.SessionResponse, error) {
+	var sessions []models.Session
+	if err := activeSessions(userID).Order("last_seen_at DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, models.SessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == current,
+		})
+	}
+	return result, nil
+}
+
+// RevokeSession logs userID out of one session.
+func RevokeSession(userID uuid.UUID, sessionID uuid.UUID) error {
+	var session models.Session
+	if err := activeSessions(userID).Where("id = ?", sessionID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	return controllers.RevokeSessions(session.ID)
+}
+
+// RevokeAllSessions logs userID out everywhere but keep, which may be
+// uuid.Nil. It returns how many sessions were revoked.
+func RevokeAllSessions(userID uuid.UUID, keep uuid.UUID) (int, error) {
+	var ids []uuid.UUID
+	if err := activeSessions(userID).Where("id <> ?", keep).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	return len(ids), controllers.RevokeSessions(ids...)
+}
+
+func activeSessions(userID uuid.UUID) *gorm.DB {
+	return db.GetDB().Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now())
+}
+
+// ListSessionsHandler godoc
+// @Summary List the active sessions of a user
+// @Tags auth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {array} models.SessionResponse
+// @Router /users/{id}/sessions [get]
+func ListSessionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID utilisateur invalide"})
+			return
+		}
+
+		current, _ := helpers.GetLoggedInSessionID(c)
+		sessions, err := ListSessions(userID, current)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeSessionHandler godoc
+// @Summary Revoke one session of a user
+// @Tags auth
+// @Produce json
+// @Param id path string true "User ID"
+// @Param sessionID path string true "Session ID"
+// @Success 200 {object} models.SuccessResponse
+// @Router /users/{id}/sessions/{sessionID} [delete]
+func RevokeSessionHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID utilisateur invalide"})
+			return
+		}
+
+		sessionID, err := uuid.Parse(c.Param("sessionID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de session invalide"})
+			return
+		}
+
+		if err := RevokeSession(userID, sessionID); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrSessionNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session révoquée"})
+	}
+}
+
+// RevokeAllSessionsHandler godoc
+// @Summary Revoke every session of a user
+// @Description With keep_current=true, the session making the request stays open.
+// @Tags auth
+// @Produce json
+// @Param id path string true "User ID"
+// @Param keep_current query bool false "Keep the current session"
+// @Success 200 {object} map[string]int
+// @Router /users/{id}/sessions [delete]
+func RevokeAllSessionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID utilisateur invalide"})
+			return
+		}
+
+		keep := uuid.Nil
+		if c.Query("keep_current") == "true" {
+			keep, _ = helpers.GetLoggedInSessionID(c)
+		}
+
+		revoked, err := RevokeAllSessions(userID, keep)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+	}
+}
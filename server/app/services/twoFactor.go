@@ -0,0 +1,493 @@
+package services
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/helpers"
+	"app/totp"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	loginChallengeTTL = 5 * time.Minute
+	// A challenge is burnt after maxChallengeAttempts wrong codes, the user
+	// has to enter their password again.
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+	defaultTOTPIssuer    = "Unity Hub"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not enabled")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnrollTwoFactor gives userID a new secret to add to an authenticator app.
+// 2FA is only enabled once a code is confirmed with ConfirmTwoFactor.
+func EnrollTwoFactor(userID uuid.UUID) (models.TwoFactorEnrollResponse, error) {
+	var user models.User
+	if err := db.GetDB().First(&user, "id = ?", userID).Error; err != nil {
+		return models.TwoFactorEnrollResponse{}, err
+	}
+	if user.TOTPEnabled {
+		return models.TwoFactorEnrollResponse{}, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return models.TwoFactorEnrollResponse{}, err
+	}
+	if err := db.GetDB().Model(&models.User{}).Where("id = ?", userID).
+		UpdateColumns(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		return models.TwoFactorEnrollResponse{}, err
+	}
+
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+	return models.TwoFactorEnrollResponse{Secret: secret, URI: totp.ProvisioningURI(issuer, user.Email, secret)}, nil
+}
+
+// ConfirmTwoFactor enables 2FA for userID if code matches the enrolled secret
+// and returns the recovery codes.
+func ConfirmTwoFactor(userID uuid.UUID, code string) ([]string, error) {
+	var user models.User
+	if err := db.GetDB().First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err := db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			UpdateColumns(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTwoFactor turns 2FA off for userID. code is a TOTP or recovery code.
+func DisableTwoFactor(userID uuid.UUID, code string) error {
+	user, err := twoFactorUser(userID)
+	if err != nil {
+		return err
+	}
+	if err := verifySecondFactor(user, code); err != nil {
+		return err
+	}
+
+	return db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.User{}).Where("id = ?", userID).
+			UpdateColumns(map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of userID. code is a
+// TOTP or recovery code.
+func RegenerateRecoveryCodes(userID uuid.UUID, code string) ([]string, error) {
+	user, err := twoFactorUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySecondFactor(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// StartLoginChallenge is the first login step of a user with 2FA: the
+// password was right, a code is still needed.
+func StartLoginChallenge(user models.User) (models.LoginChallengeResponse, error) {
+	token, err := issueUserToken(user.ID, models.UserTokenLoginChallenge, loginChallengeTTL)
+	if err != nil {
+		return models.LoginChallengeResponse{}, err
+	}
+	return models.LoginChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int(loginChallengeTTL.Seconds()),
+	}, nil
+}
+
+// CompleteLoginChallenge checks code for the challenge raw and returns the
+// user to sign in.
+func CompleteLoginChallenge(raw string, code string) (models.User, error) {
+	var challenge models.UserToken
+	if err := db.GetDB().Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?",
+		controllers.HashToken(raw), models.UserTokenLoginChallenge, time.Now(), maxChallengeAttempts).
+		First(&challenge).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.User{}, ErrInvalidUserToken
+		}
+		return models.User{}, err
+	}
+
+	user, err := twoFactorUser(challenge.UserID)
+	if err != nil {
+		return user, err
+	}
+
+	if err := verifySecondFactor(user, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			updates := map[string]interface{}{"attempts": gorm.Expr("attempts + 1")}
+			if challenge.Attempts+1 >= maxChallengeAttempts {
+				updates["used_at"] = time.Now()
+			}
+			if dbErr := db.GetDB().Model(&models.UserToken{}).Where("id = ?", challenge.ID).UpdateColumns(updates).Error; dbErr != nil {
+				return user, dbErr
+			}
+		}
+		return user, err
+	}
+
+	result := db.GetDB().Model(&models.UserToken{}).Where("id = ? AND used_at IS NULL", challenge.ID).Update("used_at", time.Now())
+	if result.Error != nil {
+		return user, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return user, ErrInvalidUserToken
+	}
+	return user, nil
+}
+
+func twoFactorUser(userID uuid.UUID) (models.User, error) {
+	var user models.User
+	if err := db.GetDB().First(&user, "id = ?", userID).Error; err != nil {
+		return user, err
+	}
+	if !user.TOTPEnabled {
+		return user, ErrTwoFactorNotEnrolled
+	}
+	return user, nil
+}
+
+// verifySecondFactor accepts a TOTP code, each step only once, or an unused
+// recovery code, which is then spent.
+func verifySecondFactor(user models.User, code string) error {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		result := db.GetDB().Model(&models.User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).
+			UpdateColumn("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	result := db.GetDB().Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, controllers.HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of userID and creates new
+// ones. Only their hashes are stored.
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(buf)[:10])
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: controllers.HashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// twoFactorAccount returns the logged-in user if they are the user of the id
+// parameter: 2FA is only ever changed by the account holder.
+func twoFactorAccount(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := helpers.GetLoggedInUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return uuid.Nil, false
+	}
+	if c.Param("id") != userID.String() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only manage your own two-factor authentication"})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func twoFactorError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidTwoFactorCode), errors.Is(err, ErrInvalidUserToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTwoFactorEnabled), errors.Is(err, ErrTwoFactorNotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// EnrollTwoFactorHandler godoc
+// @Summary Start enabling two-factor authentication
+// @Description Returns a TOTP secret and its otpauth:// URI to show as a QR code.
+// @Tags auth
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.TwoFactorEnrollResponse
+// @Failure 403 {object} models.ErrorUserResponse
+// @Router /users/{id}/two-factor/enroll [post]
+func EnrollTwoFactorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := twoFactorAccount(c)
+		if !ok {
+			return
+		}
+
+		enrollment, err := EnrollTwoFactor(userID)
+		if err != nil {
+			twoFactorError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, enrollment)
+	}
+}
+
+// ConfirmTwoFactorHandler godoc
+// @Summary Enable two-factor authentication
+// @Description Checks a code of the enrolled secret and returns the recovery codes, shown only once.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param payload body models.TwoFactorCodePayload true "TOTP code"
+// @Success 200 {object} models.RecoveryCodesResponse
+// @Failure 401 {object} models.ErrorUserResponse
+// @Failure 403 {object} models.ErrorUserResponse
+// @Router /users/{id}/two-factor/confirm [post]
+func ConfirmTwoFactorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.TwoFactorCodePayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		userID, ok := twoFactorAccount(c)
+		if !ok {
+			return
+		}
+
+		codes, err := ConfirmTwoFactor(userID, payload.Code)
+		if err != nil {
+			twoFactorError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableTwoFactorHandler godoc
+// @Summary Disable two-factor authentication
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param payload body models.TwoFactorCodePayload true "TOTP or recovery code"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 401 {object} models.ErrorUserResponse
+// @Failure 403 {object} models.ErrorUserResponse
+// @Router /users/{id}/two-factor/disable [post]
+func DisableTwoFactorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.TwoFactorCodePayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		userID, ok := twoFactorAccount(c)
+		if !ok {
+			return
+		}
+
+		if err := DisableTwoFactor(userID, payload.Code); err != nil {
+			twoFactorError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.SuccessResponse{Message: "Two-factor authentication disabled"})
+	}
+}
+
+// RegenerateRecoveryCodesHandler godoc
+// @Summary Replace the recovery codes
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param payload body models.TwoFactorCodePayload true "TOTP or recovery code"
+// @Success 200 {object} models.RecoveryCodesResponse
+// @Failure 401 {object} models.ErrorUserResponse
+// @Failure 403 {object} models.ErrorUserResponse
+// @Router /users/{id}/two-factor/recovery-codes [post]
+func RegenerateRecoveryCodesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.TwoFactorCodePayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		userID, ok := twoFactorAccount(c)
+		if !ok {
+			return
+		}
+
+		codes, err := RegenerateRecoveryCodes(userID, payload.Code)
+		if err != nil {
+			twoFactorError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// LoginTwoFactor godoc
+// @Summary Second login step
+// @Description Trades the challenge token returned by /login and a TOTP or recovery code for tokens.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body models.TwoFactorLoginPayload true "Challenge token and code"
+// @Success 200 {object} models.TokenResponse
+// @Failure 401 {object} models.ErrorUserResponse
+// @Router /login/two-factor [post]
+func LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var payload models.TwoFactorLoginPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		user, err := CompleteLoginChallenge(payload.ChallengeToken, payload.Code)
+		if err != nil {
+			twoFactorError(c, err)
+			return
+		}
+
+		tokens, err := controllers.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// RequireServerTwoFactor godoc
+// @Summary Require two-factor authentication for sensitive permissions
+// @Description Only the owner of the server can change it. Members without 2FA keep their roles but cannot use sensitive permissions such as banUser or createRole.
+// @Tags servers
+// @Accept json
+// @Produce json
+// @Param id path string true "Server ID"
+// @Param payload body models.ServerTwoFactorPayload true "Required"
+// @Success 200 {object} models.SuccessServerResponse
+// @Failure 403 {object} models.ErrorServerResponse
+// @Router /servers/{id}/two-factor [put]
+func RequireServerTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			handleError(c, http.StatusBadRequest, "ID de serveur invalide")
+			return
+		}
+
+		var payload models.ServerTwoFactorPayload
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			handleError(c, http.StatusBadRequest, "Erreur lors de la liaison des données JSON")
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			handleError(c, http.StatusUnauthorized, "Non autorisé")
+			return
+		}
+
+		var server models.Server
+		if err := db.GetDB().First(&server, "id = ?", serverID).Error; err != nil {
+			handleError(c, http.StatusNotFound, "Serveur introuvable")
+			return
+		}
+		if server.UserID != userID {
+			handleError(c, http.StatusForbidden, "Seul le créateur du serveur peut modifier ce paramètre")
+			return
+		}
+
+		if err := db.GetDB().Model(&server).Update("require_two_factor", *payload.Required).Error; err != nil {
+			handleError(c, http.StatusInternalServerError, "Erreur lors de la mise à jour du serveur")
+			return
+		}
+
+		c.JSON(http.StatusOK, models.SuccessServerResponse{Message: "Paramètre mis à jour"})
+	}
+}
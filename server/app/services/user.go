@@ -4,14 +4,18 @@ import (
 	"app/controllers"
 	"app/db"
 	"app/db/models"
+	"app/mailer"
+	"app/ratelimit"
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
@@ -21,6 +25,29 @@ import (
 
 var validate = validator.New()
 
+// The "password" tag checks models.ValidPassword, for the User model as for
+// the payloads bound by gin.
+func init() {
+	validate.RegisterValidation("password", validPassword)
+	if engine, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		engine.RegisterValidation("password", validPassword)
+	}
+}
+
+func validPassword(fl validator.FieldLevel) bool {
+	return models.ValidPassword(fl.Field().String())
+}
+
+// loginLockout locks an account for a minute after 5 wrong passwords in a
+// row, doubling with each further failure up to an hour.
+var loginLockout = ratelimit.Lockout{
+	Name:      "login",
+	Threshold: 5,
+	Base:      time.Minute,
+	Max:       time.Hour,
+	Window:    24 * time.Hour,
+}
+
 // Register godoc
 // @Summary Register a new user
 // @Description Register a new user with email and pseudo
@@ -67,12 +94,11 @@ func Register() gin.HandlerFunc {
 			return
 		}
 
-		verificationToken, err := controllers.GenerateVerificationToken()
-		if err != nil {
-			c.Error(err)
-			return
+		if !slices.Contains(mailer.Languages, inputUser.Language) {
+			inputUser.Language = mailer.Language(c.GetHeader("Accept-Language"))
 		}
-		inputUser.VerificationToken = verificationToken
+		inputUser.IsVerified = false
+		inputUser.VerificationToken = ""
 
 		result := db.GetDB().Create(&inputUser)
 		if result.Error != nil {
@@ -80,9 +106,10 @@ func Register() gin.HandlerFunc {
 			return
 		}
 
-		verificationLink := os.Getenv("DOMAIN") + "/verify/" + verificationToken
-		body := "To activate your account, please click on the following link: " + verificationLink
-		controllers.SendEmail(inputUser.Email, "Account Activation", body)
+		if err := SendVerificationEmail(inputUser); err != nil {
+			c.Error(err)
+			return
+		}
 
 		c.JSON(http.StatusCreated, inputUser)
 	}
@@ -90,7 +117,7 @@ func Register() gin.HandlerFunc {
 
 // Login godoc
 // @Summary Login a user
-// @Description Login a user with email and password
+// @Description Login a user with email and password. With two-factor authentication enabled, a challenge token for /login/two-factor is returned instead of tokens.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -130,7 +157,8 @@ func CreateUserByAdmin() gin.HandlerFunc {
 			return
 		}
 
-		// Optionnel : Vous pouvez générer un jeton de vérification ou d'autres données requises ici
+		// Les comptes créés par un administrateur n'ont pas à être vérifiés
+		inputUser.IsVerified = true
 
 		result := db.GetDB().Create(&inputUser)
 		if result.Error != nil {
@@ -159,23 +187,47 @@ func Login() gin.HandlerFunc {
 
 		payload.Email = strings.ToLower(payload.Email)
 
+		if wait, err := loginLockout.Check(payload.Email, time.Now()); err == nil && wait > 0 {
+			ratelimit.TooManyRequests(c, wait)
+			return
+		}
+
 		if err := db.GetDB().Where("email = ?", payload.Email).First(&user).Error; err != nil {
 			c.JSON(http.StatusNotFound, models.ErrorUserResponse{Error: "User not found"})
 			return
 		}
 
 		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)); err != nil {
+			if wait, err := loginLockout.Fail(payload.Email, time.Now()); err == nil && wait > 0 {
+				c.Header("Retry-After", ratelimit.RetryAfter(wait))
+			}
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Incorrect password"})
 			return
 		}
+		loginLockout.Succeed(payload.Email)
+
+		if !user.IsVerified && !allowUnverifiedLogin() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified", "code": "email_not_verified"})
+			return
+		}
 
-		tokenString, err := controllers.GenerateJWT(user.ID, user.Email, user.Role, user.Pseudo)
+		if user.TOTPEnabled {
+			challenge, err := StartLoginChallenge(user)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+				return
+			}
+			c.JSON(http.StatusOK, challenge)
+			return
+		}
+
+		tokens, err := controllers.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 			return
 		}
 
-		c.JSON(http.StatusOK, models.TokenResponse{Token: tokenString})
+		c.JSON(http.StatusOK, tokens)
 	}
 }
 
@@ -191,18 +243,11 @@ func Login() gin.HandlerFunc {
 // @Router /verify/{token} [get]
 func VerifyAccount() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token := c.Param("token")
-		var user models.User
-		result := db.GetDB().Where("verification_token = ?", token).First(&user)
-		if result.Error != nil {
-			c.Error(result.Error)
+		if err := VerifyEmail(c.Param("token")); err != nil {
+			accountError(c, err)
 			return
 		}
 
-		user.IsVerified = true
-		user.VerificationToken = ""
-		db.GetDB().Save(&user)
-
 		c.JSON(http.StatusOK, models.SuccessResponse{Message: "Account verified successfully"})
 	}
 }
@@ -517,7 +562,7 @@ func GetUserServerRole() gin.HandlerFunc {
 
 		var role models.Role
 
-		result := db.GetDB().Table("role_users").Select("roles.id, roles.label").Joins("JOIN roles ON role_users.role_id = roles.id").Where("role_users.user_id = ? AND roles.server_id = ?", userID, serverID).First(&role)
+		result := db.GetDB().Table("role_users").Select("roles.id, roles.label").Joins("JOIN roles ON role_users.role_id = roles.id").Where("role_users.user_id = ? AND roles.server_id = ? AND role_users.deleted_at IS NULL", userID, serverID).Order("roles.position DESC").First(&role)
 
 		if result.Error != nil {
 			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
@@ -1,44 +1,229 @@
 package services
 
 import (
+	"app/db"
+	"app/db/models"
+	"app/hub"
+	"app/protocol"
+	"app/sfu"
+	"app/voice"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/pion/webrtc/v3"
+	"gorm.io/gorm"
 )
 
+var (
+	voiceSFU  *sfu.SFU
+	voiceICE  sfu.ICEConfig
+	voiceOnce sync.Once
+)
+
+// getSFU returns the process wide SFU, configured from the environment and
+// with the embedded TURN server started when enabled. Joins and leaves are
+// recorded in the voice registry and announced to the channel and to its
+// server.
+func getSFU() *sfu.SFU {
+	voiceOnce.Do(func() {
+		settings, err := sfu.SettingsFromEnv(os.Getenv)
+		if err != nil {
+			log.Fatalf("Invalid voice configuration: %v", err)
+		}
+		if settings.TURN != nil {
+			server, err := sfu.StartTURN(*settings.TURN)
+			if err != nil {
+				log.Fatalf("Failed to start TURN server: %v", err)
+			}
+			log.Println("TURN server listening on", server.Addr())
+		}
+		voiceICE = settings.ICE
+
+		s, err := sfu.New(settings.Config)
+		if err != nil {
+			log.Fatalf("Failed to start SFU: %v", err)
+		}
+		s.OnJoin = joinVoice
+		s.OnLeave = leaveVoice
+		voiceSFU = s
+	})
+	return voiceSFU
+}
+
+// joinVoice registers the peer as the user's voice session. A user is in one
+// voice channel at a time, so an older session is closed.
+func joinVoice(peer *sfu.Peer) {
+	var channel models.Channel
+	if err := db.GetDB().Where("id = ?", peer.RoomID).First(&channel).Error; err != nil {
+		log.Println("Voice channel lookup failed:", err)
+	}
+
+	state, previous, replaced := voice.GetRegistry().Join(voice.State{
+		UserID:    peer.UserID,
+		ChannelID: peer.RoomID,
+		ServerID:  channel.ServerID,
+		SessionID: peer.ID,
+	})
+	peer.SetMuted(state.Muted())
+
+	if replaced {
+		publishVoiceState(protocol.EventVoiceLeave, previous)
+		if old := getSFU().Peer(previous.ChannelID, previous.SessionID); old != nil {
+			old.Close()
+		}
+	}
+	publishVoiceState(protocol.EventVoiceJoin, state)
+}
+
+func leaveVoice(peer *sfu.Peer) {
+	if state, ok := voice.GetRegistry().Leave(peer.UserID, peer.ID); ok {
+		publishVoiceState(protocol.EventVoiceLeave, state)
+	}
+}
+
+func publishVoiceState(eventType string, state voice.State) {
+	payload := voiceStatePayload(state)
+	publishEvent(hub.ChannelTopic(state.ChannelID), eventType, payload)
+	if state.ServerID != uuid.Nil {
+		publishEvent(hub.ServerTopic(state.ServerID), eventType, payload)
+	}
+}
+
+func iceServersPayload(servers []webrtc.ICEServer) []protocol.ICEServer {
+	payload := make([]protocol.ICEServer, 0, len(servers))
+	for _, server := range servers {
+		credential, _ := server.Credential.(string)
+		payload = append(payload, protocol.ICEServer{URLs: server.URLs, Username: server.Username, Credential: credential})
+	}
+	return payload
+}
+
+func voiceStatePayload(state voice.State) protocol.VoiceState {
+	return protocol.VoiceState{
+		ChannelID:  state.ChannelID,
+		ServerID:   state.ServerID,
+		UserID:     state.UserID,
+		SelfMute:   state.SelfMute,
+		SelfDeaf:   state.SelfDeaf,
+		ServerMute: state.ServerMute,
+	}
+}
+
+// ConnectToChannel is the signaling socket of a voice channel. The server
+// sends offers and candidates as "signal" events; the client answers with
+// "signal" ops.
 func ConnectToChannel(c *gin.Context) {
-	channelId := c.Param("id")
+	userID, sessionID, err := authenticateWebSocket(c.Request)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
 
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	channelID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		log.Println("Failed to create peer connection:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create peer connection"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
 		return
 	}
 
-	peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
-		if candidate != nil {
-			log.Printf("New ICE candidate: %s\n", candidate.ToJSON().Candidate)
+	var channel models.Channel
+	if err := db.GetDB().Where("id = ?", channelID).First(&channel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+			return
 		}
-	})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if channel.Type != "vocal" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Channel is not a voice channel"})
+		return
+	}
 
-	offer, err := peerConnection.CreateOffer(nil)
+	allowed, err := canAccessChannel(userID, channelID)
+	if err != nil || !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Println("Failed to create offer:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create offer"})
+		log.Println("Upgrade error:", err)
 		return
 	}
 
-	if err := peerConnection.SetLocalDescription(offer); err != nil {
-		log.Println("Failed to set local description:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set local description"})
+	h := hub.GetHub()
+	client := hub.NewClient(h, conn, userID)
+	h.Register(client)
+	h.Subscribe(client, hub.AuthTopic(sessionID))
+	h.Subscribe(client, hub.ChannelTopic(channelID))
+	h.Subscribe(client, hub.VoiceTopic(userID))
+	go client.WritePump()
+
+	// getSFU loads the ICE configuration, so it must run before the ready event.
+	s := getSFU()
+	sendEvent(client, protocol.EventVoiceReady, protocol.VoiceReady{ChannelID: channelID, ICEServers: iceServersPayload(voiceICE.Servers())})
+
+	peer, err := s.Join(channelID, userID, func(signal sfu.Signal) {
+		sendEvent(client, protocol.EventSignal, signal)
+	})
+	if err != nil {
+		log.Println("Failed to join voice channel:", err)
+		sendError(client, protocol.CodeInternal, "Failed to join voice channel", "")
+		h.Unregister(client)
 		return
 	}
+	defer peer.Close()
 
-	c.JSON(http.StatusOK, gin.H{
-		"offer":     offer.SDP,
-		"channelId": channelId,
+	if recording := s.Recording(channelID); recording != nil {
+		sendEvent(client, protocol.EventRecordingStart, recordingPayload(recording, channel.ServerID))
+	}
+
+	// Moderators may disconnect or move the peer; the socket goes with it.
+	go func() {
+		<-peer.Done()
+		h.Close(client)
+	}()
+
+	client.ReadPump(func(msgBytes []byte) {
+		frame, err := protocol.Decode(msgBytes)
+		if err != nil {
+			sendError(client, protocol.ErrorCode(err), err.Error(), frame.Nonce)
+			return
+		}
+
+		switch frame.Op {
+		case protocol.OpSignal:
+			var signal sfu.Signal
+			if err := frame.DecodeData(&signal); err != nil {
+				sendError(client, protocol.CodeInvalidPayload, err.Error(), frame.Nonce)
+				return
+			}
+			if err := peer.HandleSignal(signal); err != nil {
+				sendError(client, protocol.CodeInvalidPayload, err.Error(), frame.Nonce)
+			}
+		case protocol.OpVoiceState:
+			var update protocol.VoiceStateUpdate
+			if err := frame.DecodeData(&update); err != nil {
+				sendError(client, protocol.CodeInvalidPayload, err.Error(), frame.Nonce)
+				return
+			}
+			state, ok := voice.GetRegistry().SetSelf(userID, peer.ID, update.SelfMute, update.SelfDeaf)
+			if !ok {
+				sendError(client, protocol.CodeNotFound, "Voice session not found", frame.Nonce)
+				return
+			}
+			peer.SetMuted(state.Muted())
+			publishVoiceState(protocol.EventVoiceState, state)
+		case protocol.OpHeartbeat:
+			sendEvent(client, protocol.EventHeartbeatAck, protocol.HeartbeatAck{At: time.Now()})
+		default:
+			sendError(client, protocol.CodeUnknownOp, "Op "+frame.Op+" is not supported on a voice socket", frame.Nonce)
+		}
 	})
 }
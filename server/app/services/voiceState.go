@@ -0,0 +1,264 @@
+package services
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/helpers"
+	"app/hub"
+	"app/protocol"
+	"app/voice"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotInVoice          = errors.New("user is not in a voice channel of this server")
+	ErrNotVoiceMember      = errors.New("user is not a member of this server")
+	ErrVoiceForbidden      = errors.New("insufficient permissions")
+	ErrInvalidVoiceChannel = errors.New("target is not a voice channel of this server")
+	ErrNotVoiceChannel     = errors.New("channel is not a voice channel")
+)
+
+type channelWithVoice struct {
+	models.Channel
+	Participants []voice.State
+}
+
+func withParticipants(channels []models.Channel) []channelWithVoice {
+	result := make([]channelWithVoice, 0, len(channels))
+	for _, channel := range channels {
+		result = append(result, channelWithVoice{Channel: channel, Participants: voice.GetRegistry().Channel(channel.ID)})
+	}
+	return result
+}
+
+// SetServerMute mutes or unmutes targetID in every voice channel of serverID.
+// The mute sticks when the target reconnects.
+func SetServerMute(actorID uuid.UUID, serverID uuid.UUID, targetID uuid.UUID, mute bool) error {
+	if err := requireServerPermission(actorID, serverID, "muteMembers"); err != nil {
+		return err
+	}
+	if ok, err := isServerMember(targetID, serverID); err != nil || !ok {
+		return ErrNotVoiceMember
+	}
+
+	state, ok := voice.GetRegistry().SetServerMute(serverID, targetID, mute)
+	if !ok {
+		return nil
+	}
+	if peer := getSFU().Peer(state.ChannelID, state.SessionID); peer != nil {
+		peer.SetMuted(state.Muted())
+	}
+	publishVoiceState(protocol.EventVoiceState, state)
+	return nil
+}
+
+// MoveVoiceMember tells targetID's voice socket to reconnect to channelID and
+// ends its current session.
+func MoveVoiceMember(actorID uuid.UUID, serverID uuid.UUID, targetID uuid.UUID, channelID uuid.UUID) error {
+	if err := requireServerPermission(actorID, serverID, "moveMembers"); err != nil {
+		return err
+	}
+
+	state, ok := voice.GetRegistry().Get(targetID)
+	if !ok || state.ServerID != serverID {
+		return ErrNotInVoice
+	}
+
+	var channel models.Channel
+	if err := db.GetDB().Where("id = ? AND server_id = ? AND type = ?", channelID, serverID, "vocal").First(&channel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidVoiceChannel
+		}
+		return err
+	}
+	if allowed, err := canAccessChannel(targetID, channelID); err != nil || !allowed {
+		return ErrVoiceForbidden
+	}
+	if channelID == state.ChannelID {
+		return nil
+	}
+
+	move := protocol.VoiceMove{FromChannelID: state.ChannelID, ChannelID: channelID}
+	publishEvent(hub.VoiceTopic(targetID), protocol.EventVoiceMove, move)
+	publishEvent(hub.UserTopic(targetID), protocol.EventVoiceMove, move)
+	closeVoiceSession(state)
+	return nil
+}
+
+// DisconnectVoiceMember ends targetID's voice session on serverID.
+func DisconnectVoiceMember(actorID uuid.UUID, serverID uuid.UUID, targetID uuid.UUID) error {
+	if err := requireServerPermission(actorID, serverID, "moveMembers"); err != nil {
+		return err
+	}
+
+	state, ok := voice.GetRegistry().Get(targetID)
+	if !ok || state.ServerID != serverID {
+		return ErrNotInVoice
+	}
+	closeVoiceSession(state)
+	return nil
+}
+
+func closeVoiceSession(state voice.State) {
+	if peer := getSFU().Peer(state.ChannelID, state.SessionID); peer != nil {
+		peer.Close()
+	}
+}
+
+// moderatedVoiceChannel loads a server voice channel on which userID holds
+// the permission label.
+func moderatedVoiceChannel(userID uuid.UUID, channelID uuid.UUID, label string) (models.Channel, error) {
+	var channel models.Channel
+	if err := db.GetDB().Where("id = ?", channelID).First(&channel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return channel, ErrChannelNotFound
+		}
+		return channel, err
+	}
+	if channel.Type != "vocal" || channel.ServerID == uuid.Nil {
+		return channel, ErrNotVoiceChannel
+	}
+
+	return channel, requireServerPermission(userID, channel.ServerID, label)
+}
+
+func requireServerPermission(userID uuid.UUID, serverID uuid.UUID, label string) error {
+	allowed, err := hasServerPermission(userID, serverID, label)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrVoiceForbidden
+	}
+	return nil
+}
+
+func voiceError(err error) int {
+	switch {
+	case errors.Is(err, ErrVoiceForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotInVoice), errors.Is(err, ErrNotVoiceMember), errors.Is(err, ErrInvalidVoiceChannel), errors.Is(err, ErrChannelNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrNotVoiceChannel):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// voiceTarget reads the server and target user of a moderation route.
+func voiceTarget(c *gin.Context) (uuid.UUID, uuid.UUID, uuid.UUID, bool) {
+	actorID, err := helpers.GetLoggedInUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	serverID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de serveur invalide"})
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	targetID, err := uuid.Parse(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID utilisateur invalide"})
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	return actorID, serverID, targetID, true
+}
+
+func SetServerMuteHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actorID, serverID, targetID, ok := voiceTarget(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Mute bool `json:"mute"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if err := SetServerMute(actorID, serverID, targetID, body.Mute); err != nil {
+			c.JSON(voiceError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"mute": body.Mute})
+	}
+}
+
+func MoveVoiceMemberHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actorID, serverID, targetID, ok := voiceTarget(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			ChannelID uuid.UUID `json:"channel_id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if err := MoveVoiceMember(actorID, serverID, targetID, body.ChannelID); err != nil {
+			c.JSON(voiceError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"channel_id": body.ChannelID})
+	}
+}
+
+func DisconnectVoiceMemberHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actorID, serverID, targetID, ok := voiceTarget(c)
+		if !ok {
+			return
+		}
+
+		if err := DisconnectVoiceMember(actorID, serverID, targetID); err != nil {
+			c.JSON(voiceError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Utilisateur déconnecté du salon vocal"})
+	}
+}
+
+// VoiceStatsHandler reports the connection quality of everyone in a voice
+// channel, to debug complaints about choppy audio.
+func VoiceStatsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+			return
+		}
+
+		userID, err := helpers.GetLoggedInUserID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := moderatedVoiceChannel(userID, channelID, "moveMembers"); err != nil {
+			c.JSON(voiceError(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"channel_id": channelID, "peers": getSFU().Stats(channelID)})
+	}
+}
@@ -0,0 +1,230 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	defaultTURNListen    = "0.0.0.0:3478"
+	defaultTURNRealm     = "app"
+	defaultCredentialTTL = 12 * time.Hour
+)
+
+var (
+	ErrTURNPublicIP    = errors.New("TURN_PUBLIC_IP must be a valid IP when TURN_EMBEDDED is set")
+	ErrTURNCredentials = errors.New("TURN_SECRET or TURN_USERNAME and TURN_PASSWORD are required when TURN_EMBEDDED is set")
+)
+
+// Settings is the voice configuration read from the environment.
+type Settings struct {
+	// Config is used by the SFU itself.
+	Config Config
+	// ICE is handed to clients before they answer the first offer.
+	ICE ICEConfig
+	// TURN is the embedded TURN server to start, nil when disabled.
+	TURN *TURNConfig
+}
+
+// ICEConfig lists the STUN and TURN servers clients should use. With a
+// Secret, clients get time-limited credentials (the TURN REST scheme)
+// instead of the static Username and Password.
+type ICEConfig struct {
+	STUNURLs      []string
+	TURNURLs      []string
+	Username      string
+	Password      string
+	Secret        string
+	CredentialTTL time.Duration
+}
+
+type TURNConfig struct {
+	Listen   string
+	PublicIP string
+	Realm    string
+	Username string
+	Password string
+	Secret   string
+}
+
+// SettingsFromEnv reads:
+//
+//	STUN_URLS, TURN_URLS             comma separated server URLs
+//	TURN_USERNAME, TURN_PASSWORD     static TURN credentials
+//	TURN_SECRET                      shared secret for time-limited credentials
+//	TURN_CREDENTIAL_TTL              lifetime of those credentials (default 12h)
+//	TURN_EMBEDDED                    "true" to run the built-in TURN server
+//	TURN_LISTEN, TURN_REALM          its UDP address and realm
+//	TURN_PUBLIC_IP                   the relay address it advertises
+//	VOICE_PUBLIC_IP                  public IP of the SFU behind a 1:1 NAT
+//	VOICE_UDP_PORT_MIN, _MAX         UDP port range used for media
+func SettingsFromEnv(getenv func(string) string) (Settings, error) {
+	var settings Settings
+	ice := ICEConfig{
+		STUNURLs:      splitList(getenv("STUN_URLS")),
+		TURNURLs:      splitList(getenv("TURN_URLS")),
+		Username:      getenv("TURN_USERNAME"),
+		Password:      getenv("TURN_PASSWORD"),
+		Secret:        getenv("TURN_SECRET"),
+		CredentialTTL: defaultCredentialTTL,
+	}
+	if ttl := getenv("TURN_CREDENTIAL_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil {
+			return settings, fmt.Errorf("invalid TURN_CREDENTIAL_TTL: %w", err)
+		}
+		ice.CredentialTTL = parsed
+	}
+
+	if enabled, _ := strconv.ParseBool(getenv("TURN_EMBEDDED")); enabled {
+		server := &TURNConfig{
+			Listen:   orDefault(getenv("TURN_LISTEN"), defaultTURNListen),
+			PublicIP: getenv("TURN_PUBLIC_IP"),
+			Realm:    orDefault(getenv("TURN_REALM"), defaultTURNRealm),
+			Username: ice.Username,
+			Password: ice.Password,
+			Secret:   ice.Secret,
+		}
+		if net.ParseIP(server.PublicIP) == nil {
+			return settings, ErrTURNPublicIP
+		}
+		if server.Secret == "" && (server.Username == "" || server.Password == "") {
+			return settings, ErrTURNCredentials
+		}
+		if len(ice.TURNURLs) == 0 {
+			_, port, err := net.SplitHostPort(server.Listen)
+			if err != nil {
+				return settings, fmt.Errorf("invalid TURN_LISTEN: %w", err)
+			}
+			ice.TURNURLs = []string{"turn:" + net.JoinHostPort(server.PublicIP, port) + "?transport=udp"}
+		}
+		settings.TURN = server
+	}
+
+	engine := webrtc.SettingEngine{}
+	if ip := getenv("VOICE_PUBLIC_IP"); ip != "" {
+		if net.ParseIP(ip) == nil {
+			return settings, fmt.Errorf("invalid VOICE_PUBLIC_IP %q", ip)
+		}
+		engine.SetNAT1To1IPs([]string{ip}, webrtc.ICECandidateTypeHost)
+	}
+	if minPort, maxPort := getenv("VOICE_UDP_PORT_MIN"), getenv("VOICE_UDP_PORT_MAX"); minPort != "" || maxPort != "" {
+		low, errLow := strconv.ParseUint(minPort, 10, 16)
+		high, errHigh := strconv.ParseUint(maxPort, 10, 16)
+		if errLow != nil || errHigh != nil {
+			return settings, errors.New("VOICE_UDP_PORT_MIN and VOICE_UDP_PORT_MAX must both be ports")
+		}
+		if err := engine.SetEphemeralUDPPortRange(uint16(low), uint16(high)); err != nil {
+			return settings, err
+		}
+	}
+
+	// The SFU only needs STUN to learn its public address.
+	settings.ICE = ice
+	settings.Config = Config{SettingEngine: engine, ICEServers: ICEConfig{STUNURLs: ice.STUNURLs}.Servers()}
+	return settings, nil
+}
+
+// Servers returns the ICE servers for one client, with fresh credentials
+// when a shared secret is configured.
+func (c ICEConfig) Servers() []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	if len(c.STUNURLs) > 0 {
+		servers = append(servers, webrtc.ICEServer{URLs: c.STUNURLs})
+	}
+	if len(c.TURNURLs) == 0 {
+		return servers
+	}
+
+	username, password := c.Username, c.Password
+	if c.Secret != "" {
+		var err error
+		username, password, err = turn.GenerateLongTermCredentials(c.Secret, c.CredentialTTL)
+		if err != nil {
+			return servers
+		}
+	}
+	return append(servers, webrtc.ICEServer{
+		URLs:           c.TURNURLs,
+		Username:       username,
+		Credential:     password,
+		CredentialType: webrtc.ICECredentialTypePassword,
+	})
+}
+
+// TURNServer is the embedded TURN server.
+type TURNServer struct {
+	server *turn.Server
+	conn   net.PacketConn
+}
+
+// StartTURN serves TURN over UDP on config.Listen until Close.
+func StartTURN(config TURNConfig) (*TURNServer, error) {
+	conn, err := net.ListenPacket("udp4", config.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth turn.AuthHandler
+	if config.Secret != "" {
+		auth = turn.NewLongTermAuthHandler(config.Secret, nil)
+	} else {
+		key := turn.GenerateAuthKey(config.Username, config.Realm, config.Password)
+		auth = func(username string, realm string, _ net.Addr) ([]byte, bool) {
+			if config.Username == "" || username != config.Username {
+				return nil, false
+			}
+			return key, true
+		}
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       config.Realm,
+		AuthHandler: auth,
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn: conn,
+			RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+				RelayAddress: net.ParseIP(config.PublicIP),
+				Address:      "0.0.0.0",
+			},
+		}},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &TURNServer{server: server, conn: conn}, nil
+}
+
+// Addr is the UDP address the server listens on.
+func (t *TURNServer) Addr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+func (t *TURNServer) Close() error {
+	return t.server.Close()
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
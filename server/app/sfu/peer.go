@@ -0,0 +1,173 @@
+package sfu
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v3"
+)
+
+// Peer is one client connection in a room.
+type Peer struct {
+	ID     string
+	RoomID uuid.UUID
+	UserID uuid.UUID
+
+	room   *Room
+	pc     *webrtc.PeerConnection
+	signal func(Signal)
+	muted  atomic.Bool
+	done   chan struct{}
+	stats  stats.Getter
+
+	// signalMu keeps an offer ahead of the candidates it produces.
+	signalMu  sync.Mutex
+	closeOnce sync.Once
+
+	// pending is guarded by room.mu and set when a renegotiation had to
+	// wait for the previous answer.
+	pending bool
+}
+
+func newPeer(room *Room, userID uuid.UUID, pc *webrtc.PeerConnection, signal func(Signal)) *Peer {
+	peer := &Peer{
+		ID:     uuid.NewString(),
+		RoomID: room.id,
+		UserID: userID,
+		room:   room,
+		pc:     pc,
+		signal: signal,
+		done:   make(chan struct{}),
+	}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		init := candidate.ToJSON()
+		peer.signalMu.Lock()
+		defer peer.signalMu.Unlock()
+		peer.signal(Signal{Type: SignalCandidate, Candidate: &init})
+	})
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			peer.Close()
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		peer.forward(remote)
+	})
+
+	return peer
+}
+
+// PeerConnection exposes the underlying connection, e.g. to read stats.
+func (p *Peer) PeerConnection() *webrtc.PeerConnection {
+	return p.pc
+}
+
+// SetMuted stops or resumes forwarding the audio the peer publishes.
+func (p *Peer) SetMuted(muted bool) {
+	p.muted.Store(muted)
+}
+
+// Done is closed once the peer left its room.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// HandleSignal applies an answer or a trickled candidate from the client.
+func (p *Peer) HandleSignal(signal Signal) error {
+	switch signal.Type {
+	case SignalAnswer:
+		p.room.mu.Lock()
+		defer p.room.mu.Unlock()
+		if err := p.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: signal.SDP}); err != nil {
+			return err
+		}
+		if p.pending {
+			p.room.syncLocked()
+		}
+		return nil
+	case SignalCandidate:
+		if signal.Candidate == nil {
+			return ErrUnknownSignal
+		}
+		return p.pc.AddICECandidate(*signal.Candidate)
+	default:
+		return ErrUnknownSignal
+	}
+}
+
+// Close leaves the room and releases the connection. It is safe to call more
+// than once.
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		if err := p.pc.Close(); err != nil {
+			log.Println("Error closing peer connection:", err)
+		}
+		p.room.sfu.leave(p.room, p)
+		close(p.done)
+	})
+}
+
+// negotiateLocked sends a new offer, or defers it until the client answered
+// the previous one. room.mu must be held.
+func (p *Peer) negotiateLocked() {
+	if p.pc.SignalingState() != webrtc.SignalingStateStable {
+		p.pending = true
+		return
+	}
+	p.pending = false
+
+	p.signalMu.Lock()
+	defer p.signalMu.Unlock()
+
+	offer, err := p.pc.CreateOffer(nil)
+	if err != nil {
+		log.Println("Error creating offer:", err)
+		return
+	}
+	if err := p.pc.SetLocalDescription(offer); err != nil {
+		log.Println("Error setting local description:", err)
+		return
+	}
+	p.signal(Signal{Type: SignalOffer, SDP: offer.SDP})
+}
+
+// forward copies the packets of remote to a local track shared with the rest
+// of the room until remote ends.
+func (p *Peer) forward(remote *webrtc.TrackRemote) {
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, uuid.NewString(), p.ID)
+	if err != nil {
+		log.Println("Error creating local track:", err)
+		return
+	}
+
+	p.room.addTrack(local, p)
+	defer p.room.removeTrack(local.ID())
+
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			return
+		}
+		if p.muted.Load() {
+			continue
+		}
+		if recording := p.room.sfu.Recording(p.RoomID); recording != nil {
+			recording.write(p, remote, packet)
+		}
+		if err := local.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+var (
+	ErrAlreadyRecording = errors.New("room is already being recorded")
+	ErrNotRecording     = errors.New("room is not being recorded")
+)
+
+// Recording writes the Opus audio of every peer of a room to its own Ogg
+// file. Tracks are not mixed: mixing would mean decoding every stream.
+type Recording struct {
+	ID        uuid.UUID
+	RoomID    uuid.UUID
+	StartedBy uuid.UUID
+	StartedAt time.Time
+
+	dir    string
+	mu     sync.Mutex
+	tracks map[string]*recordedTrack
+	order  []*recordedTrack
+	closed bool
+}
+
+// RecordedTrack is one finished file. Tracks that never received audio are
+// not reported and their file is removed.
+type RecordedTrack struct {
+	UserID  uuid.UUID
+	Path    string
+	Packets int
+}
+
+type recordedTrack struct {
+	RecordedTrack
+	writer *oggwriter.OggWriter
+}
+
+// StartRecording records roomID into dir until StopRecording. Peers joining
+// later are recorded as well.
+func (s *SFU) StartRecording(roomID uuid.UUID, userID uuid.UUID, dir string) (*Recording, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s.recMu.Lock()
+	defer s.recMu.Unlock()
+	if _, ok := s.recordings[roomID]; ok {
+		return nil, ErrAlreadyRecording
+	}
+
+	recording := &Recording{
+		ID:        uuid.New(),
+		RoomID:    roomID,
+		StartedBy: userID,
+		StartedAt: time.Now(),
+		dir:       dir,
+		tracks:    make(map[string]*recordedTrack),
+	}
+	s.recordings[roomID] = recording
+	return recording, nil
+}
+
+// StopRecording ends the recording of roomID and returns it with its files.
+func (s *SFU) StopRecording(roomID uuid.UUID) (*Recording, []RecordedTrack, error) {
+	s.recMu.Lock()
+	recording, ok := s.recordings[roomID]
+	delete(s.recordings, roomID)
+	s.recMu.Unlock()
+
+	if !ok {
+		return nil, nil, ErrNotRecording
+	}
+	return recording, recording.stop(), nil
+}
+
+// Recording returns the active recording of roomID, if any.
+func (s *SFU) Recording(roomID uuid.UUID) *Recording {
+	s.recMu.RLock()
+	defer s.recMu.RUnlock()
+	return s.recordings[roomID]
+}
+
+// write appends packet to the file of the track it belongs to.
+func (r *Recording) write(peer *Peer, remote *webrtc.TrackRemote, packet *rtp.Packet) {
+	if remote.Kind() != webrtc.RTPCodecTypeAudio || remote.Codec().MimeType != webrtc.MimeTypeOpus {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	key := peer.ID + "/" + remote.ID()
+	track, ok := r.tracks[key]
+	if !ok {
+		name := fmt.Sprintf("voice-%s-%s-%d.ogg", r.ID, peer.UserID, len(r.order)+1)
+		path := filepath.Join(r.dir, name)
+		writer, err := oggwriter.New(path, 48000, 2)
+		if err != nil {
+			log.Println("Error creating recording file:", err)
+			r.tracks[key] = nil
+			return
+		}
+		track = &recordedTrack{RecordedTrack: RecordedTrack{UserID: peer.UserID, Path: path}, writer: writer}
+		r.tracks[key] = track
+		r.order = append(r.order, track)
+	}
+	if track == nil {
+		return
+	}
+
+	if err := track.writer.WriteRTP(packet); err != nil {
+		log.Println("Error writing recording:", err)
+		return
+	}
+	track.Packets++
+}
+
+func (r *Recording) stop() []RecordedTrack {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+
+	files := make([]RecordedTrack, 0, len(r.order))
+	for _, track := range r.order {
+		if err := track.writer.Close(); err != nil {
+			log.Println("Error closing recording:", err)
+		}
+		if track.Packets == 0 {
+			os.Remove(track.Path)
+			continue
+		}
+		files = append(files, track.RecordedTrack)
+	}
+	return files
+}
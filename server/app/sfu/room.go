@@ -0,0 +1,156 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+// maxSyncAttempts bounds how often a room retries renegotiation in a row
+// before backing off.
+const maxSyncAttempts = 25
+
+// Room forwards the tracks of its peers to each other.
+type Room struct {
+	id     uuid.UUID
+	sfu    *SFU
+	mu     sync.Mutex
+	peers  map[*Peer]bool
+	tracks map[string]*roomTrack
+}
+
+type roomTrack struct {
+	local *webrtc.TrackLocalStaticRTP
+	owner *Peer
+}
+
+func newRoom(s *SFU, id uuid.UUID) *Room {
+	return &Room{
+		id:     id,
+		sfu:    s,
+		peers:  make(map[*Peer]bool),
+		tracks: make(map[string]*roomTrack),
+	}
+}
+
+func (r *Room) add(peer *Peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers[peer] = true
+}
+
+// remove drops peer and its tracks and reports whether the room is empty.
+func (r *Room) remove(peer *Peer) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.peers, peer)
+	for id, track := range r.tracks {
+		if track.owner == peer {
+			delete(r.tracks, id)
+		}
+	}
+	r.syncLocked()
+	return len(r.peers) == 0
+}
+
+func (r *Room) addTrack(local *webrtc.TrackLocalStaticRTP, owner *Peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tracks[local.ID()] = &roomTrack{local: local, owner: owner}
+	r.syncLocked()
+}
+
+func (r *Room) removeTrack(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tracks, id)
+	r.syncLocked()
+}
+
+func (r *Room) participants() []uuid.UUID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[uuid.UUID]bool)
+	users := make([]uuid.UUID, 0, len(r.peers))
+	for peer := range r.peers {
+		if !seen[peer.UserID] {
+			seen[peer.UserID] = true
+			users = append(users, peer.UserID)
+		}
+	}
+	return users
+}
+
+// syncLocked makes every peer send the tracks of all other peers and
+// renegotiates the ones that changed. r.mu must be held.
+func (r *Room) syncLocked() {
+	for attempt := 0; ; attempt++ {
+		if attempt == maxSyncAttempts {
+			go func() {
+				time.Sleep(3 * time.Second)
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				r.syncLocked()
+			}()
+			return
+		}
+		if !r.attemptSync() {
+			return
+		}
+	}
+}
+
+func (r *Room) attemptSync() (tryAgain bool) {
+	for peer := range r.peers {
+		pc := peer.pc
+		if pc.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			delete(r.peers, peer)
+			return true
+		}
+
+		changed := false
+		sending := make(map[string]bool)
+		for _, sender := range pc.GetSenders() {
+			if sender.Track() == nil {
+				continue
+			}
+			id := sender.Track().ID()
+			sending[id] = true
+			if _, ok := r.tracks[id]; !ok {
+				if err := pc.RemoveTrack(sender); err != nil {
+					return true
+				}
+				changed = true
+			}
+		}
+
+		for id, track := range r.tracks {
+			if track.owner == peer || sending[id] {
+				continue
+			}
+			sender, err := pc.AddTrack(track.local)
+			if err != nil {
+				return true
+			}
+			go drainRTCP(sender)
+			changed = true
+		}
+
+		if changed || peer.pending {
+			peer.negotiateLocked()
+		}
+	}
+	return false
+}
+
+// drainRTCP reads incoming RTCP so the sender's interceptors keep working.
+func drainRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package sfu is a selective forwarding unit: every track a peer publishes in
+// a room is forwarded, untouched, to every other peer of that room.
+package sfu
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v3"
+)
+
+// Signal types exchanged with peers. The SFU always sends the offers.
+const (
+	SignalOffer     = "offer"
+	SignalAnswer    = "answer"
+	SignalCandidate = "candidate"
+)
+
+var ErrUnknownSignal = errors.New("unknown signal type")
+
+// Signal is one signaling message between a peer and the SFU.
+type Signal struct {
+	Type      string                   `json:"type"`
+	SDP       string                   `json:"sdp,omitempty"`
+	Candidate *webrtc.ICECandidateInit `json:"candidate,omitempty"`
+}
+
+type Config struct {
+	ICEServers    []webrtc.ICEServer
+	SettingEngine webrtc.SettingEngine
+}
+
+// SFU owns one room per voice channel.
+type SFU struct {
+	mu     sync.Mutex
+	api    *webrtc.API
+	config webrtc.Configuration
+	rooms  map[uuid.UUID]*Room
+
+	recMu      sync.RWMutex
+	recordings map[uuid.UUID]*Recording
+
+	// statsMu serializes peer connection creation so the stats getter built
+	// by the interceptor can be matched with its connection.
+	statsMu   sync.Mutex
+	newGetter stats.Getter
+
+	// OnJoin and OnLeave are called once per peer, outside of any lock.
+	OnJoin  func(peer *Peer)
+	OnLeave func(peer *Peer)
+}
+
+func New(config Config) (*SFU, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, err
+	}
+
+	s := &SFU{
+		config:     webrtc.Configuration{ICEServers: config.ICEServers},
+		rooms:      make(map[uuid.UUID]*Room),
+		recordings: make(map[uuid.UUID]*Recording),
+	}
+
+	statsFactory, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	statsFactory.OnNewPeerConnection(func(_ string, getter stats.Getter) {
+		s.newGetter = getter
+	})
+	registry.Add(statsFactory)
+
+	s.api = webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(registry),
+		webrtc.WithSettingEngine(config.SettingEngine),
+	)
+	return s, nil
+}
+
+// Join creates a peer for userID in roomID. signal must not block; it
+// receives the offers and candidates to relay to the client.
+func (s *SFU) Join(roomID uuid.UUID, userID uuid.UUID, signal func(Signal)) (*Peer, error) {
+	s.statsMu.Lock()
+	s.newGetter = nil
+	pc, err := s.api.NewPeerConnection(s.config)
+	getter := s.newGetter
+	s.statsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	}); err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	room, ok := s.rooms[roomID]
+	if !ok {
+		room = newRoom(s, roomID)
+		s.rooms[roomID] = room
+	}
+	peer := newPeer(room, userID, pc, signal)
+	peer.stats = getter
+	room.add(peer)
+	s.mu.Unlock()
+
+	if s.OnJoin != nil {
+		s.OnJoin(peer)
+	}
+
+	room.mu.Lock()
+	peer.pending = true
+	room.syncLocked()
+	room.mu.Unlock()
+
+	return peer, nil
+}
+
+// Participants lists the users connected to roomID, once per peer.
+func (s *SFU) Participants(roomID uuid.UUID) []uuid.UUID {
+	s.mu.Lock()
+	room := s.rooms[roomID]
+	s.mu.Unlock()
+	if room == nil {
+		return nil
+	}
+	return room.participants()
+}
+
+// Peer finds the peer with the given ID in roomID.
+func (s *SFU) Peer(roomID uuid.UUID, peerID string) *Peer {
+	for _, peer := range s.Peers(roomID) {
+		if peer.ID == peerID {
+			return peer
+		}
+	}
+	return nil
+}
+
+// Peers returns the peers currently in roomID.
+func (s *SFU) Peers(roomID uuid.UUID) []*Peer {
+	s.mu.Lock()
+	room := s.rooms[roomID]
+	s.mu.Unlock()
+	if room == nil {
+		return nil
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	peers := make([]*Peer, 0, len(room.peers))
+	for peer := range room.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (s *SFU) leave(room *Room, peer *Peer) {
+	s.mu.Lock()
+	empty := room.remove(peer)
+	if empty && s.rooms[room.id] == room {
+		delete(s.rooms, room.id)
+	}
+	s.mu.Unlock()
+
+	if s.OnLeave != nil {
+		s.OnLeave(peer)
+	}
+}
@@ -0,0 +1,84 @@
+package sfu
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+// PeerStats is the connection quality of one peer. Jitter, PacketsReceived
+// and PacketsLost describe the audio the SFU receives from the peer;
+// FractionLost and RoundTripTime come from the receiver reports the peer
+// sends about the audio forwarded to it. Durations are in seconds.
+type PeerStats struct {
+	PeerID          string    `json:"peer_id"`
+	UserID          uuid.UUID `json:"user_id"`
+	State           string    `json:"state"`
+	PacketsReceived uint64    `json:"packets_received"`
+	PacketsLost     int64     `json:"packets_lost"`
+	Jitter          float64   `json:"jitter"`
+	FractionLost    float64   `json:"fraction_lost"`
+	RoundTripTime   float64   `json:"round_trip_time"`
+}
+
+// Stats reports every peer of roomID, ordered by user.
+func (s *SFU) Stats(roomID uuid.UUID) []PeerStats {
+	peers := s.Peers(roomID)
+	result := make([]PeerStats, 0, len(peers))
+	for _, peer := range peers {
+		result = append(result, peer.Stats())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].UserID.String() < result[j].UserID.String()
+	})
+	return result
+}
+
+func (p *Peer) Stats() PeerStats {
+	result := PeerStats{PeerID: p.ID, UserID: p.UserID, State: p.pc.ConnectionState().String()}
+
+	if p.stats != nil {
+		for _, receiver := range p.pc.GetReceivers() {
+			track := receiver.Track()
+			if track == nil {
+				continue
+			}
+			stats := p.stats.Get(uint32(track.SSRC()))
+			if stats == nil {
+				continue
+			}
+			inbound := stats.InboundRTPStreamStats
+			result.PacketsReceived += inbound.PacketsReceived
+			result.PacketsLost += inbound.PacketsLost
+			if clockRate := track.Codec().ClockRate; clockRate > 0 {
+				result.Jitter = max(result.Jitter, inbound.Jitter/float64(clockRate))
+			}
+		}
+
+		for _, sender := range p.pc.GetSenders() {
+			for _, encoding := range sender.GetParameters().Encodings {
+				stats := p.stats.Get(uint32(encoding.SSRC))
+				if stats == nil {
+					continue
+				}
+				remote := stats.RemoteInboundRTPStreamStats
+				result.FractionLost = max(result.FractionLost, remote.FractionLost)
+				if remote.RoundTripTimeMeasurements > 0 {
+					result.RoundTripTime = max(result.RoundTripTime, remote.RoundTripTime.Seconds())
+				}
+			}
+		}
+	}
+
+	// Without RTCP round trips yet, fall back to the ICE connectivity checks.
+	if result.RoundTripTime == 0 {
+		for _, report := range p.pc.GetStats() {
+			if pair, ok := report.(webrtc.ICECandidatePairStats); ok && pair.Nominated {
+				result.RoundTripTime = pair.CurrentRoundTripTime
+			}
+		}
+	}
+
+	return result
+}
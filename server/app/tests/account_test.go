@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"app/db"
+	"app/db/models"
+	"app/services"
+	"app/testutils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func accountRequest(handler gin.HandlerFunc, body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/", handler)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPasswordRuleIsShared(t *testing.T) {
+	assert.True(t, models.ValidPassword("abcd1"))
+	assert.False(t, models.ValidPassword("abc1"))
+	assert.False(t, models.ValidPassword("abcdef"))
+
+	// The payloads bound by gin follow the same rule as the User model
+	for _, password := range []string{"abc1", "abcdef"} {
+		w := accountRequest(services.ResetPasswordHandler(), `{"token":"x","newPassword":"`+password+`"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, password)
+		assert.Contains(t, w.Body.String(), "Invalid input")
+	}
+}
+
+func TestAccountEmailsThrottleSilently(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+
+	user := models.User{Pseudo: "throttled", Email: "throttled@example.com", Password: "password123"}
+	assert.Nil(t, db.GetDB().Create(&user).Error)
+
+	unknown := accountRequest(services.ForgotPassword(), `{"email":"nobody@example.com"}`)
+	assert.Equal(t, http.StatusOK, unknown.Code)
+
+	// The second request is within the cooldown: ignored, with the same answer
+	for i := 0; i < 2; i++ {
+		w := accountRequest(services.ForgotPassword(), `{"email":"throttled@example.com"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, unknown.Body.String(), w.Body.String())
+	}
+
+	var sent int64
+	db.GetDB().Model(&models.UserToken{}).Where("user_id = ?", user.ID).Count(&sent)
+	assert.Equal(t, int64(1), sent)
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/testutils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func signAccessToken(t *testing.T, key string, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+	assert.Nil(t, err)
+	return token
+}
+
+func accessClaims(expiresAt time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"jti": uuid.NewString(),
+		"aud": []string{"user"},
+		"sid": uuid.NewString(),
+		"exp": expiresAt.Unix(),
+	}
+}
+
+func TestParseAccessTokenRejectsExpiredToken(t *testing.T) {
+	token := signAccessToken(t, os.Getenv("JWT_KEY"), accessClaims(time.Now().Add(-time.Minute)))
+
+	_, err := controllers.ParseAccessToken(token)
+	assert.ErrorIs(t, err, controllers.ErrInvalidToken)
+}
+
+func TestParseAccessTokenRejectsForgedSignature(t *testing.T) {
+	token := signAccessToken(t, "not-the-key"+os.Getenv("JWT_KEY"), accessClaims(time.Now().Add(time.Minute)))
+
+	_, err := controllers.ParseAccessToken(token)
+	assert.ErrorIs(t, err, controllers.ErrInvalidToken)
+}
+
+func TestParseAccessTokenRequiresSession(t *testing.T) {
+	claims := accessClaims(time.Now().Add(time.Minute))
+	delete(claims, "sid")
+	token := signAccessToken(t, os.Getenv("JWT_KEY"), claims)
+
+	_, err := controllers.ParseAccessToken(token)
+	assert.ErrorIs(t, err, controllers.ErrInvalidToken)
+}
+
+func TestTokenAuthMiddlewareRejectsExpiredToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/private", controllers.TokenAuthMiddleware("user"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/private", nil)
+	req.Header.Set("Authorization", "Bearer "+signAccessToken(t, os.Getenv("JWT_KEY"), accessClaims(time.Now().Add(-time.Minute))))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestHashTokenIsStable(t *testing.T) {
+	assert.Equal(t, controllers.HashToken("refresh"), controllers.HashToken("refresh"))
+	assert.NotEqual(t, controllers.HashToken("refresh"), controllers.HashToken("refresh2"))
+	assert.Len(t, controllers.HashToken("refresh"), 64)
+}
+
+func TestLegacyUnverifiedUserCanLogIn(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+	t.Setenv("ALLOW_UNVERIFIED_LOGIN", "false")
+
+	legacy := models.User{Pseudo: "legacy", Email: "legacy@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&legacy).Error)
+	assert.Nil(t, db.VerifyLegacyUsers(database))
+
+	// Registered after the migration: still has to verify their email
+	recent := models.User{Pseudo: "recent", Email: "recent@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&recent).Error)
+
+	r := policyEngine()
+	assert.Equal(t, http.StatusOK, policyRequest(r, http.MethodPost, "/login", `{"email":"legacy@example.com","password":"password123"}`, ""))
+	assert.Equal(t, http.StatusForbidden, policyRequest(r, http.MethodPost, "/login", `{"email":"recent@example.com","password":"password123"}`, ""))
+}
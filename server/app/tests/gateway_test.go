@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/services"
+	"app/testutils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatewayRejectsMissingToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(services.WsHandler))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestGatewayRejectsForgedToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(services.WsHandler))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?token=not.a.jwt"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestChannelSocketRejectsForgedToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		services.ChannelWsHandler(w, r, uuid.NewString())
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?token=not.a.jwt"
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestChannelSocketRefusesOutsiders(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+
+	owner := models.User{Pseudo: "socket-owner", Email: "socket-owner@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&owner).Error)
+	outsider := models.User{Pseudo: "socket-outsider", Email: "socket-outsider@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&outsider).Error)
+	media := models.Media{FileName: "socket", MimeType: "image/png", UserID: owner.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: "Socket", Visibility: "private", MediaID: media.ID, UserID: owner.ID}
+	assert.Nil(t, database.Create(&server).Error)
+	channel := models.Channel{Name: "general", Type: "text", ServerID: server.ID}
+	assert.Nil(t, database.Create(&channel).Error)
+
+	tokens, err := controllers.IssueTokens(outsider, "test", "127.0.0.1")
+	assert.Nil(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		services.ChannelWsHandler(w, r, channel.ID.String())
+	}))
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "?token=" + tokens.Token
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
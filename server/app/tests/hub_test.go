@@ -0,0 +1,380 @@
+package tests
+
+import (
+	"app/hub"
+	"app/protocol"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func startHubServer(t *testing.T, h *hub.Hub, topic string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		client := hub.NewClient(h, conn, uuid.New())
+		h.Register(client)
+		h.Subscribe(client, topic)
+		go client.WritePump()
+		client.ReadPump(func([]byte) {})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialHub(t *testing.T, server *httptest.Server) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) protocol.Event {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	var event protocol.Event
+	if err := json.Unmarshal(msg, &event); err != nil {
+		t.Fatalf("invalid event %s: %v", msg, err)
+	}
+	return event
+}
+
+func TestHubBroadcastsOnlyToTopicSubscribers(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	channelA := hub.ChannelTopic(uuid.New())
+	channelB := hub.ChannelTopic(uuid.New())
+	connA := dialHub(t, startHubServer(t, h, channelA))
+	connB := dialHub(t, startHubServer(t, h, channelB))
+
+	assert.Eventually(t, func() bool {
+		return h.Subscribers(channelA) == 1 && h.Subscribers(channelB) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	h.Publish(channelA, protocol.NewEvent("test", "hello"))
+
+	event := readEvent(t, connA)
+	assert.Equal(t, "test", event.Type)
+	assert.Equal(t, "hello", event.Data)
+
+	connB.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := connB.ReadMessage()
+	assert.NotNil(t, err)
+}
+
+func TestHubConcurrentPublishers(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	topic := hub.ServerTopic(uuid.New())
+	server := startHubServer(t, h, topic)
+
+	const clients = 5
+	const publishers = 8
+	const perPublisher = 20
+
+	conns := make([]*websocket.Conn, clients)
+	for i := range conns {
+		conns[i] = dialHub(t, server)
+	}
+	assert.Eventually(t, func() bool {
+		return h.Subscribers(topic) == clients
+	}, time.Second, 10*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perPublisher; i++ {
+				h.Publish(topic, protocol.NewEvent("test", fmt.Sprintf("%d-%d", p, i)))
+			}
+		}(p)
+	}
+
+	var readers sync.WaitGroup
+	received := make([]int, clients)
+	for i, conn := range conns {
+		readers.Add(1)
+		go func(i int, conn *websocket.Conn) {
+			defer readers.Done()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			for received[i] < publishers*perPublisher {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+				received[i]++
+			}
+		}(i, conn)
+	}
+
+	wg.Wait()
+	readers.Wait()
+
+	for i := range conns {
+		assert.Equal(t, publishers*perPublisher, received[i])
+	}
+}
+
+func TestHubEvictsSlowConsumer(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	topic := hub.ChannelTopic(uuid.New())
+
+	// No WritePump is started, so nothing ever drains the send queue.
+	slow := hub.NewClient(h, nil, uuid.New())
+	h.Register(slow)
+	h.Subscribe(slow, topic)
+	assert.Equal(t, 1, h.Subscribers(topic))
+
+	for i := 0; i <= hub.SendBufferSize; i++ {
+		h.Publish(topic, protocol.NewEvent("test", "payload"))
+	}
+
+	assert.Eventually(t, func() bool {
+		return h.Subscribers(topic) == 0 && h.Connected() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHubUnregisterLeavesTopics(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	channel := hub.ChannelTopic(uuid.New())
+	server := hub.ServerTopic(uuid.New())
+
+	client := hub.NewClient(h, nil, uuid.New())
+	h.Register(client)
+	h.Subscribe(client, channel)
+	h.Subscribe(client, server)
+	assert.Equal(t, 1, h.Subscribers(channel))
+	assert.Equal(t, 1, h.Subscribers(server))
+
+	h.Unsubscribe(client, channel)
+	assert.Equal(t, 0, h.Subscribers(channel))
+	assert.Equal(t, 1, h.Subscribers(server))
+
+	h.Unregister(client)
+	assert.Equal(t, 0, h.Subscribers(server))
+	assert.Equal(t, 0, h.Connected())
+}
+
+func TestHubUnsubscribeUser(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	server := hub.ServerTopic(uuid.New())
+	kicked := uuid.New()
+
+	phone := hub.NewClient(h, nil, kicked)
+	laptop := hub.NewClient(h, nil, kicked)
+	other := hub.NewClient(h, nil, uuid.New())
+	for _, client := range []*hub.Client{phone, laptop, other} {
+		h.Register(client)
+		h.Subscribe(client, server)
+	}
+	assert.Equal(t, 3, h.Subscribers(server))
+
+	h.UnsubscribeUser(kicked, server)
+	assert.Equal(t, 1, h.Subscribers(server))
+	assert.Equal(t, 3, h.Connected())
+}
+
+func TestHubStampsSequencePerConnection(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	shared := hub.ChannelTopic(uuid.New())
+	only := hub.ChannelTopic(uuid.New())
+	connA := dialHub(t, startHubServer(t, h, shared))
+	connB := dialHub(t, startHubServer(t, h, only))
+	assert.Eventually(t, func() bool {
+		return h.Subscribers(shared) == 1 && h.Subscribers(only) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	h.Publish(only, protocol.NewEvent("test", "b1"))
+	h.Publish(shared, protocol.NewEvent("test", "a1"))
+	h.Publish(only, protocol.NewEvent("test", "b2"))
+	h.Publish(shared, protocol.NewEvent("test", "a2"))
+
+	first, second := readEvent(t, connA), readEvent(t, connA)
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.Equal(t, uint64(2), second.Seq)
+	assert.Equal(t, protocol.Version, first.V)
+	assert.Equal(t, protocol.OpEvent, first.Op)
+
+	first, second = readEvent(t, connB), readEvent(t, connB)
+	assert.Equal(t, "b1", first.Data)
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.Equal(t, uint64(2), second.Seq)
+}
+
+func detachedSession(t *testing.T, h *hub.Hub, userID uuid.UUID, topic string) *hub.Client {
+	client := hub.NewClient(h, nil, userID)
+	client.EnableResume()
+	h.Register(client)
+	h.Subscribe(client, topic)
+	h.Publish(topic, protocol.NewEvent("test", "before"))
+	h.Unregister(client)
+
+	assert.Eventually(t, func() bool { return h.Connected() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, h.Subscribers(topic))
+	return client
+}
+
+func TestHubResumeReplaysMissedEvents(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	userID := uuid.New()
+	topic := hub.ChannelTopic(uuid.New())
+	previous := detachedSession(t, h, userID, topic)
+
+	h.Publish(topic, protocol.NewEvent("test", "missed 1"))
+	h.Publish(topic, protocol.NewEvent("test", "missed 2"))
+
+	resumed := hub.NewClient(h, nil, userID)
+	resumed.EnableResume()
+	h.Register(resumed)
+
+	replayed, err := h.Resume(resumed, previous.SessionID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.Equal(t, previous.SessionID, resumed.SessionID)
+	assert.Equal(t, 1, h.Subscribers(topic))
+	assert.Equal(t, 1, h.Connected())
+
+	_, err = h.Resume(hub.NewClient(h, nil, userID), previous.SessionID, 1)
+	assert.ErrorIs(t, err, hub.ErrInvalidSession)
+}
+
+func TestHubResumeRejectsOtherUser(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	topic := hub.ChannelTopic(uuid.New())
+	previous := detachedSession(t, h, uuid.New(), topic)
+
+	intruder := hub.NewClient(h, nil, uuid.New())
+	intruder.EnableResume()
+	h.Register(intruder)
+
+	_, err := h.Resume(intruder, previous.SessionID, 0)
+	assert.ErrorIs(t, err, hub.ErrInvalidSession)
+	assert.Equal(t, 1, h.Subscribers(topic))
+}
+
+func TestHubResumeRejectsGap(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	userID := uuid.New()
+	topic := hub.ChannelTopic(uuid.New())
+	previous := detachedSession(t, h, userID, topic)
+
+	for i := 0; i < hub.BacklogSize+5; i++ {
+		h.Publish(topic, protocol.NewEvent("test", i))
+	}
+
+	resumed := hub.NewClient(h, nil, userID)
+	resumed.EnableResume()
+	h.Register(resumed)
+
+	_, err := h.Resume(resumed, previous.SessionID, 1)
+	assert.ErrorIs(t, err, hub.ErrInvalidSession)
+}
+
+func TestHubCloseDeliversPendingEvents(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	topic := hub.VoiceTopic(uuid.New())
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := hub.NewClient(h, conn, uuid.New())
+		h.Register(client)
+		h.Subscribe(client, topic)
+		h.Publish(topic, protocol.NewEvent("test", "last words"))
+		h.Close(client)
+		client.WritePump()
+	}))
+	defer server.Close()
+
+	conn := dialHub(t, server)
+	event := readEvent(t, conn)
+	assert.Equal(t, "last words", event.Data)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.NotNil(t, err)
+	assert.Eventually(t, func() bool { return h.Connected() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestHubCloseTopicRemovesResumableClients(t *testing.T) {
+	h := hub.New()
+	go h.Run()
+	defer h.Stop()
+
+	sessionID := uuid.New()
+	topic := hub.AuthTopic(sessionID)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := hub.NewClient(h, conn, uuid.New())
+		client.EnableResume()
+		h.Register(client)
+		h.Subscribe(client, topic)
+		h.Subscribe(client, hub.UserTopic(client.UserID))
+		h.Publish(topic, protocol.NewEvent(protocol.EventSessionRevoked, protocol.SessionRevoked{SessionID: sessionID}))
+		h.CloseTopic(topic)
+		client.WritePump()
+	}))
+	defer server.Close()
+
+	conn := dialHub(t, server)
+	event := readEvent(t, conn)
+	assert.Equal(t, protocol.EventSessionRevoked, event.Type)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	assert.NotNil(t, err)
+	assert.Eventually(t, func() bool { return h.Subscribers(topic) == 0 && h.Connected() == 0 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"app/sfu"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(key string) string { return values[key] }
+}
+
+func TestICESettingsDefaultToNoServers(t *testing.T) {
+	settings, err := sfu.SettingsFromEnv(env(nil))
+	assert.Nil(t, err)
+	assert.Nil(t, settings.TURN)
+	assert.Empty(t, settings.ICE.Servers())
+}
+
+func TestICESettingsStaticCredentials(t *testing.T) {
+	settings, err := sfu.SettingsFromEnv(env(map[string]string{
+		"STUN_URLS":     "stun:stun.example.com:3478, stun:backup.example.com:3478",
+		"TURN_URLS":     "turn:turn.example.com:3478?transport=udp",
+		"TURN_USERNAME": "voice",
+		"TURN_PASSWORD": "secret",
+	}))
+	assert.Nil(t, err)
+
+	servers := settings.ICE.Servers()
+	assert.Len(t, servers, 2)
+	assert.Equal(t, []string{"stun:stun.example.com:3478", "stun:backup.example.com:3478"}, servers[0].URLs)
+	assert.Equal(t, "voice", servers[1].Username)
+	assert.Equal(t, "secret", servers[1].Credential)
+
+	// The SFU itself only gets the STUN servers.
+	assert.Len(t, settings.Config.ICEServers, 1)
+}
+
+func TestICESettingsTimeLimitedCredentials(t *testing.T) {
+	settings, err := sfu.SettingsFromEnv(env(map[string]string{
+		"TURN_URLS":           "turn:turn.example.com:3478",
+		"TURN_SECRET":         "shared",
+		"TURN_CREDENTIAL_TTL": "1h",
+	}))
+	assert.Nil(t, err)
+
+	servers := settings.ICE.Servers()
+	assert.Len(t, servers, 1)
+
+	auth := turn.NewLongTermAuthHandler("shared", nil)
+	key, ok := auth(servers[0].Username, "app", nil)
+	assert.True(t, ok)
+	assert.Equal(t, turn.GenerateAuthKey(servers[0].Username, "app", servers[0].Credential.(string)), key)
+
+	// Another secret derives another key, so the credential is rejected.
+	otherKey, _ := turn.NewLongTermAuthHandler("other", nil)(servers[0].Username, "app", nil)
+	assert.NotEqual(t, key, otherKey)
+}
+
+func TestICESettingsRejectInvalidEmbeddedTURN(t *testing.T) {
+	_, err := sfu.SettingsFromEnv(env(map[string]string{"TURN_EMBEDDED": "true", "TURN_SECRET": "s"}))
+	assert.ErrorIs(t, err, sfu.ErrTURNPublicIP)
+
+	_, err = sfu.SettingsFromEnv(env(map[string]string{"TURN_EMBEDDED": "true", "TURN_PUBLIC_IP": "203.0.113.7"}))
+	assert.ErrorIs(t, err, sfu.ErrTURNCredentials)
+
+	_, err = sfu.SettingsFromEnv(env(map[string]string{"VOICE_UDP_PORT_MIN": "40000"}))
+	assert.NotNil(t, err)
+}
+
+func TestICESettingsEmbeddedTURNAdvertisesItself(t *testing.T) {
+	settings, err := sfu.SettingsFromEnv(env(map[string]string{
+		"TURN_EMBEDDED":  "true",
+		"TURN_PUBLIC_IP": "203.0.113.7",
+		"TURN_LISTEN":    "0.0.0.0:3479",
+		"TURN_SECRET":    "shared",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, "app", settings.TURN.Realm)
+	assert.Equal(t, []string{"turn:203.0.113.7:3479?transport=udp"}, settings.ICE.TURNURLs)
+}
+
+func TestEmbeddedTURNAllocatesRelay(t *testing.T) {
+	server, err := sfu.StartTURN(sfu.TURNConfig{
+		Listen:   "127.0.0.1:0",
+		PublicIP: "127.0.0.1",
+		Realm:    "app",
+		Username: "voice",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("start turn: %v", err)
+	}
+	defer server.Close()
+
+	allocate := func(password string) error {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		defer conn.Close()
+
+		client, err := turn.NewClient(&turn.ClientConfig{
+			STUNServerAddr: server.Addr().String(),
+			TURNServerAddr: server.Addr().String(),
+			Username:       "voice",
+			Password:       password,
+			Realm:          "app",
+			Conn:           conn,
+			RTO:            100 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("new client: %v", err)
+		}
+		defer client.Close()
+		if err := client.Listen(); err != nil {
+			t.Fatalf("client listen: %v", err)
+		}
+
+		relay, err := client.Allocate()
+		if err != nil {
+			return err
+		}
+		defer relay.Close()
+		assert.True(t, relay.LocalAddr().(*net.UDPAddr).IP.IsLoopback())
+		return nil
+	}
+
+	assert.Nil(t, allocate("secret"))
+	assert.NotNil(t, allocate("wrong"))
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"app/mailer"
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTemplatesInEveryLanguage(t *testing.T) {
+	data := struct {
+		Pseudo string
+		Link   string
+	}{"<alice>", "https://example.com/verify/abc"}
+
+	fr, err := mailer.Render("verify_email", "fr", data)
+	assert.Nil(t, err)
+	assert.Equal(t, "Activez votre compte", fr.Subject)
+	assert.Contains(t, fr.Text, "Bonjour <alice>")
+	assert.Contains(t, fr.HTML, "Bonjour &lt;alice&gt;")
+	assert.Contains(t, fr.HTML, `href="https://example.com/verify/abc"`)
+
+	en, err := mailer.Render("verify_email", "en", data)
+	assert.Nil(t, err)
+	assert.Equal(t, "Activate your account", en.Subject)
+
+	fallback, err := mailer.Render("verify_email", "de", data)
+	assert.Nil(t, err)
+	assert.Equal(t, fr.Subject, fallback.Subject)
+
+	_, err = mailer.Render("missing", "fr", data)
+	assert.NotNil(t, err)
+}
+
+func TestRenderInvitation(t *testing.T) {
+	msg, err := mailer.Render("invitation", "en", struct {
+		Pseudo string
+		Sender string
+		Server string
+		Link   string
+		Expire time.Time
+	}{"bob", "alice", "Go fans", "", time.Date(2024, 6, 1, 18, 30, 0, 0, time.UTC)})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice invited you to Go fans", msg.Subject)
+	assert.Contains(t, msg.Text, "June 1, 2024 at 18:30")
+	assert.NotContains(t, msg.Text, "Join the server:")
+}
+
+func TestLanguageFromAcceptLanguage(t *testing.T) {
+	assert.Equal(t, "en", mailer.Language("en-US,en;q=0.9"))
+	assert.Equal(t, "fr", mailer.Language("fr-FR"))
+	assert.Equal(t, "en", mailer.Language("de-DE, en;q=0.5"))
+	assert.Equal(t, mailer.DefaultLanguage, mailer.Language(""))
+}
+
+func TestMessageRejectsHeaderInjection(t *testing.T) {
+	_, err := mailer.Message{From: "app@example.com", To: "bob@example.com\r\nBcc: eve@example.com", Subject: "hi"}.Bytes()
+	assert.ErrorIs(t, err, mailer.ErrInvalidAddress)
+
+	data, err := mailer.Message{From: "app@example.com", To: "bob@example.com", Subject: "hi\r\nBcc: eve@example.com", Text: "x"}.Bytes()
+	assert.Nil(t, err)
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	assert.Nil(t, err)
+	assert.Empty(t, parsed.Header.Get("Bcc"))
+}
+
+func TestFileMailerWritesMultipartMessage(t *testing.T) {
+	dir := t.TempDir()
+	err := mailer.FileMailer{Dir: dir}.Send(mailer.Message{
+		From:    "app@example.com",
+		To:      "bob@example.com",
+		Subject: "Réinitialisation",
+		Text:    "Bonjour",
+		HTML:    "<p>Bonjour</p>",
+	})
+	assert.Nil(t, err)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.Len(t, files, 1)
+	raw, _ := os.ReadFile(files[0])
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	assert.Nil(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Réinitialisation", subject)
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+}
+
+// fakeSMTP accepts one message and hands its DATA to received.
+func fakeSMTP(t *testing.T, received chan<- string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return listener
+}
+
+func TestSMTPMailerSendsMessage(t *testing.T) {
+	received := make(chan string, 1)
+	listener := fakeSMTP(t, received)
+	defer listener.Close()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	err := mailer.SMTPMailer{Host: host, Port: port}.Send(mailer.Message{
+		From:    "app@example.com",
+		To:      "bob@example.com",
+		Subject: "Hello",
+		Text:    "Hello Bob",
+	})
+	assert.Nil(t, err)
+
+	select {
+	case data := <-received:
+		assert.Contains(t, data, "To: <bob@example.com>")
+		assert.Contains(t, data, "Hello Bob")
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestMailerFromEnv(t *testing.T) {
+	env := func(values map[string]string) func(string) string {
+		return func(key string) string { return values[key] }
+	}
+
+	m, from, err := mailer.FromEnv(env(map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "2525", "SMTP_USER": "login"}))
+	assert.Nil(t, err)
+	assert.IsType(t, mailer.SMTPMailer{}, m)
+	assert.Equal(t, "noreply@localhost", from)
+
+	m, from, err = mailer.FromEnv(env(map[string]string{"MAIL_DIR": "/tmp/mail", "MAIL_FROM": "app@example.com"}))
+	assert.Nil(t, err)
+	assert.Equal(t, mailer.FileMailer{Dir: "/tmp/mail"}, m)
+	assert.Equal(t, "app@example.com", from)
+
+	_, _, err = mailer.FromEnv(env(map[string]string{"MAILER": "smtp"}))
+	assert.NotNil(t, err)
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, mailer.Backoff(1))
+	assert.Equal(t, time.Minute, mailer.Backoff(2))
+	assert.Equal(t, 2*time.Minute, mailer.Backoff(3))
+	assert.Equal(t, 2*time.Hour, mailer.Backoff(20))
+}
@@ -0,0 +1,246 @@
+package tests
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/routes"
+	"app/services"
+	"app/testutils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostMessageRequiresToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.MessageRoutes(r)
+
+	body := `{"content":"hello","type":"text","channelId":"` + uuid.NewString() + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCreateMessageRejectsEmptyContent(t *testing.T) {
+	_, err := services.CreateMessage(uuid.New(), uuid.New(), services.MessageDraft{Type: "text"})
+	assert.ErrorIs(t, err, services.ErrInvalidMessage)
+
+	_, err = services.CreateMessage(uuid.New(), uuid.New(), services.MessageDraft{Content: "hello"})
+	assert.ErrorIs(t, err, services.ErrInvalidMessage)
+}
+
+func TestEditAndDeleteMessageRequireToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.MessageRoutes(r)
+
+	path := "/messages/" + uuid.NewString()
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"content":"edited"}`))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, method)
+	}
+}
+
+func TestEditMessageRejectsEmptyContent(t *testing.T) {
+	_, err := services.EditMessage(uuid.New(), uuid.New(), "")
+	assert.ErrorIs(t, err, services.ErrInvalidMessage)
+}
+
+func TestMessageThreadRequiresToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.MessageRoutes(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/messages/"+uuid.NewString()+"/thread", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// messageFixture creates a server of author with a text channel, a message of
+// author in it and a user who is not on the server.
+func messageFixture(t *testing.T, prefix string) (models.User, models.User, models.Server, models.Message) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+
+	author := models.User{Pseudo: prefix + "-author", Email: prefix + "-author@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&author).Error)
+	outsider := models.User{Pseudo: prefix + "-outsider", Email: prefix + "-outsider@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&outsider).Error)
+	media := models.Media{FileName: prefix, MimeType: "image/png", UserID: author.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: prefix, Visibility: "private", MediaID: media.ID, UserID: author.ID}
+	assert.Nil(t, database.Create(&server).Error)
+	assert.Nil(t, database.Create(&models.OnServer{ServerID: server.ID, UserID: author.ID}).Error)
+	channel := models.Channel{Name: "general", Type: "text", ServerID: server.ID}
+	assert.Nil(t, database.Create(&channel).Error)
+	message := models.Message{Content: "first", Type: "text", SentAt: time.Now(), UserID: author.ID, ChannelID: channel.ID}
+	assert.Nil(t, database.Create(&message).Error)
+
+	return author, outsider, server, message
+}
+
+func messageRequest(t *testing.T, method string, path string, body string, user models.User) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.MessageRoutes(r)
+	routes.ReportRoutes(r)
+	routes.ChannelRoutes(r)
+
+	tokens, err := controllers.IssueTokens(user, "test", "127.0.0.1")
+	assert.Nil(t, err)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestEditMessageKeepsRevision(t *testing.T) {
+	author, _, _, message := messageFixture(t, "revision")
+
+	edited, err := services.EditMessage(author.ID, message.ID, "second")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", edited.Content)
+	assert.NotNil(t, edited.EditedAt)
+
+	var revisions []models.MessageRevision
+	assert.Nil(t, db.GetDB().Where("message_id = ?", message.ID).Find(&revisions).Error)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, "first", revisions[0].Content)
+	assert.Equal(t, author.ID, revisions[0].EditorID)
+
+	// Saving the same content is not an edit
+	_, err = services.EditMessage(author.ID, message.ID, "second")
+	assert.Nil(t, err)
+	var count int64
+	assert.Nil(t, db.GetDB().Model(&models.MessageRevision{}).Where("message_id = ?", message.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestEditAndDeleteMessageRefuseNonAuthors(t *testing.T) {
+	_, outsider, _, message := messageFixture(t, "non-author")
+	path := "/messages/" + message.ID.String()
+
+	w := messageRequest(t, http.MethodPut, path, `{"content":"hijacked"}`, outsider)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = messageRequest(t, http.MethodDelete, path, "", outsider)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var stored models.Message
+	assert.Nil(t, db.GetDB().Where("id = ?", message.ID).First(&stored).Error)
+	assert.Equal(t, "first", stored.Content)
+}
+
+func TestReportShowsDeletedMessageWithRevisions(t *testing.T) {
+	author, outsider, server, message := messageFixture(t, "report")
+	report := models.Report{Message: "spam", Status: "pending", MessageID: &message.ID, UserID: outsider.ID, ServerID: server.ID}
+	assert.Nil(t, db.GetDB().Create(&report).Error)
+
+	_, err := services.EditMessage(author.ID, message.ID, "second")
+	assert.Nil(t, err)
+	_, err = services.DeleteMessage(author.ID, message.ID)
+	assert.Nil(t, err)
+
+	w := messageRequest(t, http.MethodGet, "/servers/"+server.ID.String()+"/reports/pending", "", author)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Data []models.Report `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Len(t, body.Data, 1)
+	reported := body.Data[0].ReportedMessage
+	assert.Equal(t, message.ID, reported.ID)
+	assert.Equal(t, "second", reported.Content)
+	assert.True(t, reported.DeletedAt.Valid)
+	assert.Len(t, reported.Revisions, 1)
+	assert.Equal(t, "first", reported.Revisions[0].Content)
+	assert.Equal(t, author.ID, reported.Revisions[0].Editor.ID)
+
+	// Outsiders cannot read the reports of the server
+	w = messageRequest(t, http.MethodGet, "/servers/"+server.ID.String()+"/reports/pending", "", outsider)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestMessageThreadRefusesOutsiders(t *testing.T) {
+	author, outsider, _, root := messageFixture(t, "thread")
+	path := "/messages/" + root.ID.String() + "/thread"
+
+	w := messageRequest(t, http.MethodGet, path, "", outsider)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = messageRequest(t, http.MethodGet, path, "", author)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestDeleteReplyRecountsThread(t *testing.T) {
+	author, _, _, root := messageFixture(t, "recount")
+	first, err := services.CreateMessage(author.ID, root.ChannelID, services.MessageDraft{Content: "one", Type: "text", ThreadID: &root.ID})
+	assert.Nil(t, err)
+	second, err := services.CreateMessage(author.ID, root.ChannelID, services.MessageDraft{Content: "two", Type: "text", ThreadID: &root.ID})
+	assert.Nil(t, err)
+
+	reload := func() models.Message {
+		var stored models.Message
+		assert.Nil(t, db.GetDB().Where("id = ?", root.ID).First(&stored).Error)
+		return stored
+	}
+	assert.Equal(t, 2, reload().ReplyCount)
+
+	_, err = services.DeleteMessage(author.ID, second.ID)
+	assert.Nil(t, err)
+	stored := reload()
+	assert.Equal(t, 1, stored.ReplyCount)
+	assert.WithinDuration(t, first.SentAt, *stored.LastReplyAt, time.Millisecond)
+
+	_, err = services.DeleteMessage(author.ID, first.ID)
+	assert.Nil(t, err)
+	stored = reload()
+	assert.Equal(t, 0, stored.ReplyCount)
+	assert.Nil(t, stored.LastReplyAt)
+}
+
+func TestDeleteThreadRootDeletesReplies(t *testing.T) {
+	author, _, _, root := messageFixture(t, "cascade")
+	reply, err := services.CreateMessage(author.ID, root.ChannelID, services.MessageDraft{Content: "reply", Type: "text", ThreadID: &root.ID})
+	assert.Nil(t, err)
+
+	_, err = services.DeleteMessage(author.ID, root.ID)
+	assert.Nil(t, err)
+
+	var count int64
+	assert.Nil(t, db.GetDB().Model(&models.Message{}).Where("id = ?", reply.ID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+	assert.Nil(t, db.GetDB().Unscoped().Model(&models.Message{}).Where("id = ? AND deleted_at IS NOT NULL", reply.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	w := messageRequest(t, http.MethodGet, "/messages/"+root.ID.String()+"/thread", "", author)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestMessageReadsRefuseOutsiders(t *testing.T) {
+	author, outsider, _, message := messageFixture(t, "reads")
+
+	for _, path := range []string{"/messages/" + message.ID.String(), "/channels/" + message.ChannelID.String() + "/messages"} {
+		assert.Equal(t, http.StatusForbidden, messageRequest(t, http.MethodGet, path, "", outsider).Code, path)
+		assert.Equal(t, http.StatusOK, messageRequest(t, http.MethodGet, path, "", author).Code, path)
+	}
+}
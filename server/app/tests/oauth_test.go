@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"app/oauth"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGitHub stands in for github.com and api.github.com. It only hands out a
+// token for code when the verifier matches the challenge of the authorize step.
+func fakeGitHub(t *testing.T, challenge *string, emails string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.ParseForm())
+		if r.Form.Get("code") != "good-code" || oauth.Challenge(r.Form.Get("code_verifier")) != *challenge {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": 42, "login": "octocat", "avatar_url": "https://example.com/a.png", "email": "public@example.com"}`))
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(emails))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestOAuthGitHubExchangeWithPKCE(t *testing.T) {
+	var challenge string
+	server := fakeGitHub(t, &challenge, `[
+		{"email": "secondary@example.com", "primary": false, "verified": true},
+		{"email": "Octo@Example.com", "primary": true, "verified": true}
+	]`)
+	defer server.Close()
+
+	github := oauth.NewGitHub(oauth.GitHubConfig{ClientID: "id", ClientSecret: "secret", BaseURL: server.URL, APIURL: server.URL})
+	store := oauth.NewStateStore()
+	state, challenge, err := store.Begin(oauth.Flow{Provider: "github"})
+	assert.Nil(t, err)
+
+	authURL, err := url.Parse(github.AuthCodeURL(state, challenge))
+	assert.Nil(t, err)
+	assert.Equal(t, "/login/oauth/authorize", authURL.Path)
+	assert.Equal(t, state, authURL.Query().Get("state"))
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+
+	flow, err := store.Complete("github", state)
+	assert.Nil(t, err)
+
+	_, err = github.Exchange(context.Background(), "good-code", "wrong-verifier")
+	assert.ErrorIs(t, err, oauth.ErrExchangeFailed)
+
+	identity, err := github.Exchange(context.Background(), "good-code", flow.Verifier)
+	assert.Nil(t, err)
+	assert.Equal(t, oauth.Identity{
+		Provider:   "github",
+		ProviderID: "42",
+		Email:      "octo@example.com",
+		Name:       "octocat",
+		AvatarURL:  "https://example.com/a.png",
+	}, identity)
+}
+
+func TestOAuthGitHubRequiresVerifiedEmail(t *testing.T) {
+	var challenge string
+	server := fakeGitHub(t, &challenge, `[{"email": "octo@example.com", "primary": true, "verified": false}]`)
+	defer server.Close()
+
+	github := oauth.NewGitHub(oauth.GitHubConfig{BaseURL: server.URL, APIURL: server.URL})
+	store := oauth.NewStateStore()
+	state, challenge, _ := store.Begin(oauth.Flow{Provider: "github"})
+	flow, _ := store.Complete("github", state)
+
+	_, err := github.Exchange(context.Background(), "good-code", flow.Verifier)
+	assert.ErrorIs(t, err, oauth.ErrNoVerifiedEmail)
+}
+
+func TestOAuthStateIsSingleUse(t *testing.T) {
+	store := oauth.NewStateStore()
+	linkUser := uuid.New()
+	state, _, err := store.Begin(oauth.Flow{Provider: "github", LinkUserID: linkUser})
+	assert.Nil(t, err)
+
+	_, err = store.Complete("gitlab", state)
+	assert.ErrorIs(t, err, oauth.ErrInvalidState)
+
+	state, _, _ = store.Begin(oauth.Flow{Provider: "github", LinkUserID: linkUser})
+	flow, err := store.Complete("github", state)
+	assert.Nil(t, err)
+	assert.Equal(t, linkUser, flow.LinkUserID)
+
+	_, err = store.Complete("github", state)
+	assert.ErrorIs(t, err, oauth.ErrInvalidState)
+
+	_, err = store.Complete("github", "forged")
+	assert.ErrorIs(t, err, oauth.ErrInvalidState)
+}
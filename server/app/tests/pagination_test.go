@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/helpers"
+	"app/routes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func parseCursor(query string) (helpers.Cursor, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/channels/x/messages?"+query, nil)
+	return helpers.ParseCursor(c)
+}
+
+func TestParseCursorDefaults(t *testing.T) {
+	cursor, err := parseCursor("")
+	assert.Nil(t, err)
+	assert.Equal(t, helpers.CursorLatest, cursor.Direction)
+	assert.Equal(t, helpers.DefaultPageSize, cursor.Limit)
+}
+
+func TestParseCursorCapsLimit(t *testing.T) {
+	cursor, err := parseCursor("limit=5000")
+	assert.Nil(t, err)
+	assert.Equal(t, helpers.MaxPageSize, cursor.Limit)
+
+	cursor, err = parseCursor("limit=20")
+	assert.Nil(t, err)
+	assert.Equal(t, 20, cursor.Limit)
+
+	for _, limit := range []string{"0", "-3", "ten"} {
+		_, err = parseCursor("limit=" + limit)
+		assert.NotNil(t, err, limit)
+	}
+}
+
+func TestParseCursorDirections(t *testing.T) {
+	id := uuid.New()
+	for _, direction := range []string{helpers.CursorBefore, helpers.CursorAfter, helpers.CursorAround} {
+		cursor, err := parseCursor(direction + "=" + id.String())
+		assert.Nil(t, err)
+		assert.Equal(t, direction, cursor.Direction)
+		assert.Equal(t, id, cursor.ID)
+	}
+
+	_, err := parseCursor("before=" + id.String() + "&after=" + id.String())
+	assert.NotNil(t, err)
+
+	_, err = parseCursor("around=not-an-id")
+	assert.NotNil(t, err)
+}
+
+func TestChannelHistoryPages(t *testing.T) {
+	author, _, server, _ := messageFixture(t, "history")
+	database := db.GetDB()
+	channel := models.Channel{Name: "history", Type: "text", ServerID: server.ID}
+	assert.Nil(t, database.Create(&channel).Error)
+
+	// Three of the messages share their timestamp: the id breaks the tie
+	start := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	createdAt := []time.Time{start, start.Add(time.Minute), start.Add(time.Minute), start.Add(time.Minute), start.Add(2 * time.Minute)}
+	var messages []models.Message
+	for i, at := range createdAt {
+		message := models.Message{Content: strconv.Itoa(i), Type: "text", SentAt: at, UserID: author.ID, ChannelID: channel.ID}
+		message.CreatedAt = at
+		assert.Nil(t, database.Create(&message).Error)
+		messages = append(messages, message)
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].ID.String() < messages[j].ID.String()
+	})
+	ids := func(page []models.Message) []uuid.UUID {
+		result := []uuid.UUID{}
+		for _, message := range page {
+			result = append(result, message.ID)
+		}
+		return result
+	}
+
+	tokens, err := controllers.IssueTokens(author, "test", "127.0.0.1")
+	assert.Nil(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.ChannelRoutes(r)
+	page := func(query string) (int, []uuid.UUID) {
+		req := httptest.NewRequest(http.MethodGet, "/channels/"+channel.ID.String()+"/messages?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var body []models.Message
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, ids(body)
+	}
+
+	code, got := page("limit=3")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ids(messages[2:]), got)
+
+	_, got = page("before=" + messages[2].ID.String())
+	assert.Equal(t, ids(messages[:2]), got)
+	_, got = page("before=" + messages[0].ID.String())
+	assert.Empty(t, got)
+
+	// Pages stop and resume inside the run of equal timestamps
+	_, got = page("after=" + messages[1].ID.String() + "&limit=2")
+	assert.Equal(t, ids(messages[2:4]), got)
+	_, got = page("after=" + messages[3].ID.String() + "&limit=2")
+	assert.Equal(t, ids(messages[4:]), got)
+	_, got = page("after=" + messages[4].ID.String())
+	assert.Empty(t, got)
+
+	_, got = page("around=" + messages[2].ID.String() + "&limit=4")
+	assert.Equal(t, ids(messages[:4]), got)
+
+	code, _ = page("before=" + uuid.NewString())
+	assert.Equal(t, http.StatusNotFound, code)
+}
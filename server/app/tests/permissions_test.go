@@ -0,0 +1,524 @@
+package tests
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/permissions"
+	"app/services"
+	"app/testutils"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissionEvaluate(t *testing.T) {
+	user := uuid.New()
+	admin := permissions.Role{ID: uuid.New(), Label: "admin", Position: 10, Powers: map[string]int{"banUser": 1, "sendMessage": 99}}
+	moderator := permissions.Role{ID: uuid.New(), Label: "modo", Position: 5, Powers: map[string]int{"kickUser": 1, "sendMessage": 70}}
+	helper := permissions.Role{ID: uuid.New(), Label: "helper", Position: 5, Powers: map[string]int{"kickUser": 0, "sendMessage": 40}}
+	member := permissions.Role{ID: uuid.New(), Label: "membre", Position: 0, Powers: map[string]int{"kickUser": 0, "sendMessage": 10}}
+	other := permissions.Role{ID: uuid.New(), Label: "other", Position: 20}
+
+	channel := func(threshold int, overwrites ...permissions.Overwrite) *permissions.Channel {
+		return &permissions.Channel{
+			ID:         uuid.New(),
+			Thresholds: map[string]int{"sendMessage": threshold, "accessChannel": 0},
+			Overwrites: overwrites,
+		}
+	}
+	roleOverwrite := func(role permissions.Role, permission string, allow bool) permissions.Overwrite {
+		return permissions.Overwrite{ID: uuid.New(), RoleID: role.ID, Permission: permission, Allow: allow}
+	}
+	userOverwrite := func(permission string, allow bool) permissions.Overwrite {
+		return permissions.Overwrite{ID: uuid.New(), UserID: user, Permission: permission, Allow: allow}
+	}
+
+	tests := []struct {
+		name       string
+		member     permissions.Member
+		permission string
+		channel    *permissions.Channel
+		allowed    bool
+		reason     string
+		power      int
+	}{
+		{
+			name:       "not a member",
+			member:     permissions.Member{UserID: user, Roles: []permissions.Role{admin}},
+			permission: "banUser",
+			allowed:    false,
+			reason:     permissions.ReasonNotMember,
+			power:      1,
+		},
+		{
+			name:       "owner without roles",
+			member:     permissions.Member{UserID: user, IsMember: true, IsOwner: true},
+			permission: "banUser",
+			allowed:    true,
+			reason:     permissions.ReasonOwner,
+		},
+		{
+			name:       "owner ignores overwrites",
+			member:     permissions.Member{UserID: user, IsMember: true, IsOwner: true, Roles: []permissions.Role{member}},
+			permission: "sendMessage",
+			channel:    channel(50, userOverwrite("sendMessage", false)),
+			allowed:    true,
+			reason:     permissions.ReasonOwner,
+			power:      10,
+		},
+		{
+			name:       "member without roles",
+			member:     permissions.Member{UserID: user, IsMember: true},
+			permission: "kickUser",
+			allowed:    false,
+			reason:     permissions.ReasonRoles,
+		},
+		{
+			name:       "membership without roles",
+			member:     permissions.Member{UserID: user, IsMember: true},
+			permission: permissions.Membership,
+			allowed:    true,
+			reason:     permissions.ReasonMember,
+		},
+		{
+			name:       "membership needs to be a member",
+			member:     permissions.Member{UserID: user, Roles: []permissions.Role{member}},
+			permission: permissions.Membership,
+			allowed:    false,
+			reason:     permissions.ReasonNotMember,
+		},
+		{
+			name:       "boolean permission granted",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{moderator}},
+			permission: "kickUser",
+			allowed:    true,
+			reason:     permissions.ReasonRoles,
+			power:      1,
+		},
+		{
+			name:       "boolean permission not granted",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member}},
+			permission: "kickUser",
+			allowed:    false,
+			reason:     permissions.ReasonRoles,
+		},
+		{
+			name:       "any role may grant",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member, helper, moderator}},
+			permission: "kickUser",
+			allowed:    true,
+			reason:     permissions.ReasonRoles,
+			power:      1,
+		},
+		{
+			name:       "unknown permission",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{moderator}},
+			permission: "doesNotExist",
+			allowed:    false,
+			reason:     permissions.ReasonRoles,
+		},
+		{
+			name:       "channel power below threshold",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member, helper}},
+			permission: "sendMessage",
+			channel:    channel(50),
+			allowed:    false,
+			reason:     permissions.ReasonRoles,
+			power:      40,
+		},
+		{
+			name:       "highest power of all roles reaches threshold",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member, moderator}},
+			permission: "sendMessage",
+			channel:    channel(50),
+			allowed:    true,
+			reason:     permissions.ReasonRoles,
+			power:      70,
+		},
+		{
+			name:       "threshold zero lets everyone in",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member}},
+			permission: "accessChannel",
+			channel:    channel(50),
+			allowed:    true,
+			reason:     permissions.ReasonRoles,
+		},
+		{
+			name:       "channel without threshold",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{admin}},
+			permission: "editChannel",
+			channel:    channel(0),
+			allowed:    false,
+			reason:     permissions.ReasonNoThreshold,
+		},
+		{
+			name:       "role overwrite denies",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member, moderator}},
+			permission: "sendMessage",
+			channel:    channel(0, roleOverwrite(moderator, "sendMessage", false)),
+			allowed:    false,
+			reason:     permissions.ReasonRoleOverwrite,
+			power:      70,
+		},
+		{
+			name:       "role overwrite allows",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member}},
+			permission: "sendMessage",
+			channel:    channel(99, roleOverwrite(member, "sendMessage", true)),
+			allowed:    true,
+			reason:     permissions.ReasonRoleOverwrite,
+			power:      10,
+		},
+		{
+			name:       "overwrite of the highest role wins",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member, moderator}},
+			permission: "sendMessage",
+			channel:    channel(0, roleOverwrite(member, "sendMessage", false), roleOverwrite(moderator, "sendMessage", true)),
+			allowed:    true,
+			reason:     permissions.ReasonRoleOverwrite,
+			power:      70,
+		},
+		{
+			name:       "allow wins between roles at the same position",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{helper, moderator}},
+			permission: "sendMessage",
+			channel:    channel(99, roleOverwrite(moderator, "sendMessage", false), roleOverwrite(helper, "sendMessage", true)),
+			allowed:    true,
+			reason:     permissions.ReasonRoleOverwrite,
+			power:      70,
+		},
+		{
+			name:       "overwrite of a role not held is ignored",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member}},
+			permission: "sendMessage",
+			channel:    channel(0, roleOverwrite(other, "sendMessage", false)),
+			allowed:    true,
+			reason:     permissions.ReasonRoles,
+			power:      10,
+		},
+		{
+			name:       "overwrite of another permission is ignored",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member}},
+			permission: "sendMessage",
+			channel:    channel(0, roleOverwrite(member, "accessChannel", false), userOverwrite("accessChannel", false)),
+			allowed:    true,
+			reason:     permissions.ReasonRoles,
+			power:      10,
+		},
+		{
+			name:       "user overwrite beats role overwrite",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member}},
+			permission: "sendMessage",
+			channel:    channel(0, roleOverwrite(member, "sendMessage", false), userOverwrite("sendMessage", true)),
+			allowed:    true,
+			reason:     permissions.ReasonUserOverwrite,
+			power:      10,
+		},
+		{
+			name:       "user overwrite denies an admin",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{admin}},
+			permission: "sendMessage",
+			channel:    channel(0, userOverwrite("sendMessage", false)),
+			allowed:    false,
+			reason:     permissions.ReasonUserOverwrite,
+			power:      99,
+		},
+		{
+			name:       "boolean permission overwritten in a channel",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{moderator}},
+			permission: "kickUser",
+			channel:    channel(0, roleOverwrite(moderator, "kickUser", false)),
+			allowed:    false,
+			reason:     permissions.ReasonRoleOverwrite,
+			power:      1,
+		},
+		{
+			name:       "admin permission comes from the admin role",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{member, admin}},
+			permission: permissions.Admin,
+			allowed:    true,
+			reason:     permissions.ReasonRoles,
+			power:      1,
+		},
+		{
+			name:       "admin permission without the admin role",
+			member:     permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{moderator}},
+			permission: permissions.Admin,
+			allowed:    false,
+			reason:     permissions.ReasonRoles,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := test.member.Evaluate(test.permission, test.channel)
+			assert.Equal(t, test.allowed, decision.Allowed)
+			assert.Equal(t, test.reason, decision.Reason)
+			assert.Equal(t, test.power, decision.Power)
+			assert.Len(t, decision.Roles, len(test.member.Roles))
+		})
+	}
+}
+
+func TestPermissionDecisionDetails(t *testing.T) {
+	user := uuid.New()
+	low := permissions.Role{ID: uuid.New(), Label: "membre", Position: 0, Powers: map[string]int{"sendMessage": 10}}
+	high := permissions.Role{ID: uuid.New(), Label: "modo", Position: 3, Powers: map[string]int{"sendMessage": 60}}
+	deny := permissions.Overwrite{ID: uuid.New(), RoleID: high.ID, Permission: "sendMessage"}
+	member := permissions.Member{UserID: user, IsMember: true, Roles: []permissions.Role{low, high}}
+
+	decision := member.Evaluate("sendMessage", &permissions.Channel{
+		Thresholds: map[string]int{"sendMessage": 50},
+		Overwrites: []permissions.Overwrite{deny},
+	})
+
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 50, *decision.Threshold)
+	assert.Equal(t, []permissions.RoleContribution{
+		{RoleID: high.ID, Label: "modo", Position: 3, Power: 60, Grants: true},
+		{RoleID: low.ID, Label: "membre", Position: 0, Power: 10, Grants: false},
+	}, decision.Roles)
+	assert.Equal(t, &permissions.AppliedOverwrite{ID: deny.ID, Kind: "role", Target: high.ID, Allow: false}, decision.Overwrite)
+	assert.Equal(t, 3, member.HighestPosition())
+	assert.Equal(t, -1, permissions.Member{}.HighestPosition())
+}
+
+func TestPermissionHierarchy(t *testing.T) {
+	role := func(position int) permissions.Role {
+		return permissions.Role{ID: uuid.New(), Label: "role", Position: position}
+	}
+	owner := permissions.Member{UserID: uuid.New(), IsMember: true, IsOwner: true}
+	admin := permissions.Member{UserID: uuid.New(), IsMember: true, Roles: []permissions.Role{role(0), role(10)}}
+	otherAdmin := permissions.Member{UserID: uuid.New(), IsMember: true, Roles: []permissions.Role{role(10)}}
+	moderator := permissions.Member{UserID: uuid.New(), IsMember: true, Roles: []permissions.Role{role(5)}}
+	member := permissions.Member{UserID: uuid.New(), IsMember: true, Roles: []permissions.Role{role(0)}}
+	newcomer := permissions.Member{UserID: uuid.New(), IsMember: true}
+	former := permissions.Member{UserID: uuid.New(), Roles: []permissions.Role{role(20)}}
+
+	tests := []struct {
+		name     string
+		actor    permissions.Member
+		target   permissions.Member
+		outranks bool
+	}{
+		{"owner over admin", owner, admin, true},
+		{"admin over owner", admin, owner, false},
+		{"owner over themselves", owner, owner, false},
+		{"highest role counts", admin, moderator, true},
+		{"same rank", admin, otherAdmin, false},
+		{"lower rank", moderator, admin, false},
+		{"role over no role", member, newcomer, true},
+		{"no role over no role", newcomer, newcomer, false},
+		{"former member", former, member, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.outranks, test.actor.Outranks(test.target))
+		})
+	}
+
+	assert.True(t, owner.CanManageRole(1000))
+	assert.True(t, admin.CanManageRole(9))
+	assert.False(t, admin.CanManageRole(10))
+	assert.False(t, newcomer.CanManageRole(0))
+	assert.False(t, former.CanManageRole(0))
+	assert.Equal(t, 10, admin.Rank())
+	assert.Equal(t, -1, newcomer.Rank())
+}
+
+func TestPermissionGrantCap(t *testing.T) {
+	owner := permissions.Member{UserID: uuid.New(), IsMember: true, IsOwner: true}
+	moderator := permissions.Member{UserID: uuid.New(), IsMember: true, Roles: []permissions.Role{
+		{ID: uuid.New(), Label: "modo", Position: 5, Powers: map[string]int{"sendMessage": 50, "kickUser": 1}},
+		{ID: uuid.New(), Label: "membre", Position: 0, Powers: map[string]int{"sendMessage": 70}},
+	}}
+	former := permissions.Member{UserID: uuid.New(), Roles: moderator.Roles}
+
+	assert.True(t, owner.CanGrant("banUser", 1))
+	assert.True(t, moderator.CanGrant("sendMessage", 70))
+	assert.False(t, moderator.CanGrant("sendMessage", 71))
+	assert.True(t, moderator.CanGrant("kickUser", 1))
+	assert.False(t, moderator.CanGrant("banUser", 1))
+	assert.True(t, moderator.CanGrant("banUser", 0))
+	assert.False(t, former.CanGrant("sendMessage", 0))
+}
+
+func TestExplainChannelPermissions(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+	models.CreateInitialPermissions(database)
+	models.CreateInitialChannelPermissions(database)
+
+	owner := models.User{Pseudo: "explain-owner", Email: "explain-owner@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&owner).Error)
+	member := models.User{Pseudo: "explain-member", Email: "explain-member@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&member).Error)
+	media := models.Media{FileName: "explain", MimeType: "image/png", UserID: owner.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: "Explain", Visibility: "private", MediaID: media.ID, UserID: owner.ID}
+	assert.Nil(t, database.Create(&server).Error)
+	assert.Nil(t, database.Create(&models.OnServer{ServerID: server.ID, UserID: member.ID}).Error)
+
+	power := func(role models.Role, label string, value int) {
+		assert.Nil(t, database.Model(&models.RolePermissions{}).
+			Where("role_id = ? AND permissions_id = (SELECT id FROM permissions WHERE label = ?)", role.ID, label).
+			Update("power", value).Error)
+	}
+	low := models.Role{Label: "membre", ServerID: server.ID}
+	assert.Nil(t, database.Create(&low).Error)
+	power(low, "sendMessage", 10)
+	high := models.Role{Label: "modo", ServerID: server.ID, Position: 2}
+	assert.Nil(t, database.Create(&high).Error)
+	power(high, "sendMessage", 40)
+	assert.Nil(t, database.Create(&models.RoleUser{UserID: member.ID, RoleID: low.ID}).Error)
+	assert.Nil(t, database.Create(&models.RoleUser{UserID: member.ID, RoleID: high.ID}).Error)
+
+	channel := models.Channel{Name: "annonces", Type: "text", ServerID: server.ID}
+	assert.Nil(t, database.Create(&channel).Error)
+	var sendMessage models.ChannelPermissions
+	assert.Nil(t, database.Where("label = ?", "sendMessage").First(&sendMessage).Error)
+	assert.Nil(t, database.Create(&models.ChannelChannelPermissions{ChannelID: channel.ID, ChannelPermissionID: sendMessage.ID, Power: 50}).Error)
+
+	explanation, err := services.ExplainMemberPermissions(server.ID, member.ID, &channel.ID, "")
+	assert.Nil(t, err)
+	assert.True(t, explanation.IsMember)
+	assert.False(t, explanation.IsOwner)
+	assert.Len(t, explanation.Decisions, 3)
+
+	decision := explanation.Decisions[2]
+	assert.Equal(t, "sendMessage", decision.Permission)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 40, decision.Power)
+	assert.Equal(t, 50, *decision.Threshold)
+	assert.Equal(t, []permissions.RoleContribution{
+		{RoleID: high.ID, Label: "modo", Position: 2, Power: 40},
+		{RoleID: low.ID, Label: "membre", Position: 0, Power: 10},
+	}, decision.Roles)
+
+	enforced, err := permissions.CheckChannel(member.ID, channel.ID, "sendMessage")
+	assert.Nil(t, err)
+	assert.Equal(t, enforced, decision)
+
+	_, err = services.ExplainMemberPermissions(server.ID, member.ID, &channel.ID, "doesNotExist")
+	assert.ErrorIs(t, err, services.ErrUnknownPermission)
+	_, err = services.ExplainMemberPermissions(uuid.New(), member.ID, &channel.ID, "")
+	assert.ErrorIs(t, err, permissions.ErrChannelNotFound)
+}
+
+func TestRoleAssignmentRequiresTwoFactor(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+
+	owner := models.User{Pseudo: "assign-owner", Email: "assign-owner@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&owner).Error)
+	member := models.User{Pseudo: "assign-member", Email: "assign-member@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&member).Error)
+	media := models.Media{FileName: "assign", MimeType: "image/png", UserID: owner.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: "Assign", Visibility: "private", MediaID: media.ID, UserID: owner.ID, RequireTwoFactor: true}
+	assert.Nil(t, database.Create(&server).Error)
+	assert.Nil(t, database.Create(&models.OnServer{ServerID: server.ID, UserID: member.ID}).Error)
+	role := models.Role{Label: "modo", ServerID: server.ID}
+	assert.Nil(t, database.Create(&role).Error)
+
+	assert.ErrorIs(t, services.AssignRole(owner.ID, server.ID, role.ID, member.ID), services.ErrTwoFactorRequired)
+	assert.ErrorIs(t, services.UnassignRole(owner.ID, server.ID, role.ID, member.ID), services.ErrTwoFactorRequired)
+
+	assert.Nil(t, database.Model(&owner).Update("totp_enabled", true).Error)
+	assert.Nil(t, services.AssignRole(owner.ID, server.ID, role.ID, member.ID))
+}
+
+func TestSpreadRolePositions(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+
+	owner := models.User{Pseudo: "spread-owner", Email: "spread-owner@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&owner).Error)
+	media := models.Media{FileName: "spread", MimeType: "image/png", UserID: owner.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: "Spread", Visibility: "private", MediaID: media.ID, UserID: owner.ID}
+	assert.Nil(t, database.Create(&server).Error)
+
+	// Created before positions existed: every role at 0
+	var roles []models.Role
+	for _, label := range []string{"admin", "modo", "membre", "helper"} {
+		role := models.Role{Label: label, ServerID: server.ID}
+		assert.Nil(t, database.Create(&role).Error)
+		roles = append(roles, role)
+	}
+
+	assert.Nil(t, db.SpreadRolePositions(database))
+
+	positions := map[string]int{}
+	for _, role := range roles {
+		assert.Nil(t, database.First(&role, "id = ?", role.ID).Error)
+		positions[role.Label] = role.Position
+	}
+	assert.Equal(t, map[string]int{"membre": 0, "modo": 1, "helper": 2, "admin": 3}, positions)
+}
+
+func TestRolePermissionGrantsAreCapped(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+	models.CreateInitialPermissions(database)
+
+	owner := models.User{Pseudo: "grant-owner", Email: "grant-owner@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&owner).Error)
+	moderator := models.User{Pseudo: "grant-modo", Email: "grant-modo@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&moderator).Error)
+	media := models.Media{FileName: "grant", MimeType: "image/png", UserID: owner.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: "Grant", Visibility: "private", MediaID: media.ID, UserID: owner.ID}
+	assert.Nil(t, database.Create(&server).Error)
+	assert.Nil(t, database.Create(&models.OnServer{ServerID: server.ID, UserID: moderator.ID}).Error)
+
+	power := func(role models.Role, label string, value int) {
+		assert.Nil(t, database.Model(&models.RolePermissions{}).
+			Where("role_id = ? AND permissions_id = (SELECT id FROM permissions WHERE label = ?)", role.ID, label).
+			Update("power", value).Error)
+	}
+	modo := models.Role{Label: "modo", ServerID: server.ID, Position: 5}
+	assert.Nil(t, database.Create(&modo).Error)
+	power(modo, "createRole", 1)
+	power(modo, "sendMessage", 50)
+	assert.Nil(t, database.Create(&models.RoleUser{UserID: moderator.ID, RoleID: modo.ID}).Error)
+	helper := models.Role{Label: "helper", ServerID: server.ID, Position: 1}
+	assert.Nil(t, database.Create(&helper).Error)
+	power(helper, "accessReport", 1)
+
+	body := func(overrides map[string]int) string {
+		powers := map[string]int{
+			"createChannel": 0, "sendMessage": 0, "accessChannel": 0, "banUser": 0, "kickUser": 0,
+			"createRole": 0, "accessLog": 0, "accessReport": 1, "profileServer": 0, "editChannel": 0,
+			"manageMessages": 0, "muteMembers": 0, "moveMembers": 0, "recordVoice": 0,
+		}
+		for label, value := range overrides {
+			powers[label] = value
+		}
+		encoded, _ := json.Marshal(powers)
+		return string(encoded)
+	}
+
+	r := policyEngine()
+	tokens, err := controllers.IssueTokens(moderator, "test", "127.0.0.1")
+	assert.Nil(t, err)
+	path := "/roles/" + helper.ID.String() + "/permissions"
+
+	assert.Equal(t, http.StatusForbidden, policyRequest(r, http.MethodPut, path, body(map[string]int{"sendMessage": 60}), tokens.Token))
+	assert.Equal(t, http.StatusForbidden, policyRequest(r, http.MethodPut, path, body(map[string]int{"banUser": 1}), tokens.Token))
+	// accessReport stays at 1 although the moderator does not have it
+	assert.Equal(t, http.StatusOK, policyRequest(r, http.MethodPut, path, body(map[string]int{"sendMessage": 50, "createRole": 1}), tokens.Token))
+
+	var granted models.RolePermissions
+	assert.Nil(t, database.Where("role_id = ? AND permissions_id = (SELECT id FROM permissions WHERE label = ?)", helper.ID, "sendMessage").
+		First(&granted).Error)
+	assert.Equal(t, 50, granted.Power)
+}
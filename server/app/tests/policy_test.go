@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"app/controllers"
+	"app/db"
+	"app/db/models"
+	"app/policy"
+	"app/routes"
+	"app/testutils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// policyEngine registers every route like main does.
+func policyEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	routes.Register(r)
+	r.GET("/swagger/*any", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.Static("/uploads", "./upload")
+	return r
+}
+
+// routePath fills the parameters of path, using params for the known ones.
+func routePath(path string, params map[string]string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+		if value, ok := params[segment[1:]]; ok {
+			segments[i] = value
+		} else {
+			segments[i] = uuid.NewString()
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func policyRequest(r *gin.Engine, method string, path string, body string, token string) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestEveryRouteHasAPolicy(t *testing.T) {
+	r := policyEngine()
+	assert.NoError(t, routes.Policies.Check(r.Routes()))
+
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		registered[policy.Key(route.Method, route.Path)] = true
+	}
+	for key := range routes.Policies {
+		assert.True(t, registered[key], "policy for a route that does not exist: %s", key)
+	}
+}
+
+func TestRouterRefusesRouteWithoutPolicy(t *testing.T) {
+	table := policy.Table{"GET /known": policy.Public()}
+	router := table.Router(gin.New())
+
+	assert.NotPanics(t, func() { router.GET("/known", func(c *gin.Context) {}) })
+	assert.PanicsWithValue(t, "policy: no policy for POST /known", func() { router.POST("/known", func(c *gin.Context) {}) })
+
+	engine := gin.New()
+	engine.GET("/unknown", func(c *gin.Context) {})
+	assert.EqualError(t, table.Check(engine.Routes()), "routes without policy: GET /unknown")
+}
+
+func TestPolicyRunsBeforeHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	reached := false
+	policy.Table{"PUT /servers/:id": policy.Server("profileServer")}.Router(engine).
+		PUT("/servers/:id", func(c *gin.Context) { reached = true })
+
+	assert.Equal(t, http.StatusUnauthorized, policyRequest(engine, http.MethodPut, "/servers/"+uuid.NewString(), "{}", ""))
+	assert.False(t, reached)
+}
+
+func TestGuardedRoutesRequireToken(t *testing.T) {
+	r := policyEngine()
+	forged := signAccessToken(t, "not-the-key"+os.Getenv("JWT_KEY"), accessClaims(time.Now().Add(time.Minute)))
+
+	for _, route := range r.Routes() {
+		p, _ := routes.Policies.Lookup(route.Method, route.Path)
+		if p.Auth == "" {
+			continue
+		}
+		path := routePath(route.Path, nil)
+		body := `{"serverId":"` + uuid.NewString() + `","channelId":"` + uuid.NewString() + `"}`
+
+		assert.Equal(t, http.StatusUnauthorized, policyRequest(r, route.Method, path, body, ""), "%s %s without token", route.Method, route.Path)
+		assert.Equal(t, http.StatusUnauthorized, policyRequest(r, route.Method, path, body, forged), "%s %s with a forged token", route.Method, route.Path)
+	}
+}
+
+func TestGuardedRoutesRefuseOutsiders(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+
+	owner := models.User{Pseudo: "policy-owner", Email: "policy-owner@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&owner).Error)
+	outsider := models.User{Pseudo: "policy-outsider", Email: "policy-outsider@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&outsider).Error)
+
+	media := models.Media{FileName: "policy", MimeType: "image/png", UserID: owner.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: "Policy", Visibility: "private", MediaID: media.ID, UserID: owner.ID}
+	assert.Nil(t, database.Create(&server).Error)
+	assert.Nil(t, database.Create(&models.OnServer{ServerID: server.ID, UserID: owner.ID}).Error)
+	channel := models.Channel{Name: "general", Type: "text", ServerID: server.ID}
+	assert.Nil(t, database.Create(&channel).Error)
+	role := models.Role{Label: "modo", ServerID: server.ID}
+	assert.Nil(t, database.Create(&role).Error)
+	report := models.Report{Message: "spam", Status: "pending", UserID: owner.ID, ServerID: server.ID}
+	assert.Nil(t, database.Create(&report).Error)
+
+	tokens, err := controllers.IssueTokens(outsider, "test", "127.0.0.1")
+	assert.Nil(t, err)
+
+	r := policyEngine()
+	for _, route := range r.Routes() {
+		p, _ := routes.Policies.Lookup(route.Method, route.Path)
+		params := map[string]string{"userID": owner.ID.String()}
+		switch {
+		case p.Self:
+			params["id"] = owner.ID.String()
+		case p.Scope == policy.ServerScope:
+			params["id"] = server.ID.String()
+		case p.Scope == policy.ChannelScope:
+			params["id"] = channel.ID.String()
+		case p.Scope == policy.RoleScope:
+			params["id"] = role.ID.String()
+		case p.Scope == policy.ReportScope:
+			params["id"] = report.ID.String()
+		case p.Auth != "admin":
+			// Public routes, and user routes the handlers check
+			continue
+		}
+		body := `{"serverId":"` + server.ID.String() + `","channelId":"` + channel.ID.String() + `"}`
+
+		code := policyRequest(r, route.Method, routePath(route.Path, params), body, tokens.Token)
+		assert.Equal(t, http.StatusForbidden, code, "%s %s as an outsider", route.Method, route.Path)
+	}
+}
+
+func TestDeleteFriendRefusesOutsiders(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+
+	user1 := models.User{Pseudo: "friend-one", Email: "friend-one@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&user1).Error)
+	user2 := models.User{Pseudo: "friend-two", Email: "friend-two@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&user2).Error)
+	outsider := models.User{Pseudo: "friend-outsider", Email: "friend-outsider@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&outsider).Error)
+	friend := models.Friend{Status: "accepted", UserID1: user1.ID, UserID2: user2.ID}
+	assert.Nil(t, database.Create(&friend).Error)
+
+	outsiderTokens, err := controllers.IssueTokens(outsider, "test", "127.0.0.1")
+	assert.Nil(t, err)
+	userTokens, err := controllers.IssueTokens(user2, "test", "127.0.0.1")
+	assert.Nil(t, err)
+
+	r := policyEngine()
+	path := "/friends/" + friend.ID.String()
+	assert.Equal(t, http.StatusForbidden, policyRequest(r, http.MethodDelete, path, "", outsiderTokens.Token))
+	assert.Equal(t, http.StatusNoContent, policyRequest(r, http.MethodDelete, path, "", userTokens.Token))
+}
+
+func TestCreateReportUsesLoggedInReporter(t *testing.T) {
+	testutils.SetupTestDB()
+	db.InitDB()
+	database := db.GetDB()
+
+	owner := models.User{Pseudo: "report-owner", Email: "report-owner@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&owner).Error)
+	reporter := models.User{Pseudo: "report-reporter", Email: "report-reporter@example.com", Password: "password123"}
+	assert.Nil(t, database.Create(&reporter).Error)
+	media := models.Media{FileName: "report", MimeType: "image/png", UserID: owner.ID}
+	assert.Nil(t, database.Create(&media).Error)
+	server := models.Server{Name: "Report", Visibility: "private", MediaID: media.ID, UserID: owner.ID}
+	assert.Nil(t, database.Create(&server).Error)
+	assert.Nil(t, database.Create(&models.OnServer{ServerID: server.ID, UserID: reporter.ID}).Error)
+
+	tokens, err := controllers.IssueTokens(reporter, "test", "127.0.0.1")
+	assert.Nil(t, err)
+
+	// The reporter and the status of the body are ignored
+	body := `{"message":"spam","status":"finished","userID":"` + owner.ID.String() + `","serverID":"` + server.ID.String() + `","reportedID":"` + owner.ID.String() + `"}`
+	r := policyEngine()
+	assert.Equal(t, http.StatusCreated, policyRequest(r, http.MethodPost, "/reports", body, tokens.Token))
+
+	var report models.Report
+	assert.Nil(t, database.Where("server_id = ?", server.ID).First(&report).Error)
+	assert.Equal(t, reporter.ID, report.UserID)
+	assert.Equal(t, "pending", report.Status)
+	assert.Equal(t, owner.ID, *report.ReportedID)
+
+	// Only members may report on a server
+	elsewhere := `{"message":"spam","serverID":"` + uuid.NewString() + `"}`
+	assert.Equal(t, http.StatusForbidden, policyRequest(r, http.MethodPost, "/reports", elsewhere, tokens.Token))
+}
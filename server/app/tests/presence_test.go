@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"app/presence"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceTransitions(t *testing.T) {
+	tracker := presence.NewTracker(presence.NewMemoryStore())
+	userID := uuid.New()
+
+	assert.Equal(t, presence.Offline, tracker.Status(userID))
+
+	status, changed, err := tracker.Update(userID, "phone", false)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, presence.Online, status)
+
+	status, changed, _ = tracker.Update(userID, "laptop", true)
+	assert.False(t, changed)
+	assert.Equal(t, presence.Online, status)
+
+	status, changed, _ = tracker.Update(userID, "phone", true)
+	assert.True(t, changed)
+	assert.Equal(t, presence.Idle, status)
+
+	status, changed, _ = tracker.Disconnect(userID, "phone")
+	assert.False(t, changed)
+	assert.Equal(t, presence.Idle, status)
+
+	status, changed, _ = tracker.Disconnect(userID, "laptop")
+	assert.True(t, changed)
+	assert.Equal(t, presence.Offline, status)
+}
+
+func TestPresenceStatuses(t *testing.T) {
+	tracker := presence.NewTracker(presence.NewMemoryStore())
+	online, idle, offline := uuid.New(), uuid.New(), uuid.New()
+	tracker.Update(online, "a", false)
+	tracker.Update(idle, "b", true)
+
+	statuses := tracker.Statuses([]uuid.UUID{online, idle, offline})
+	assert.Equal(t, presence.Online, statuses[online])
+	assert.Equal(t, presence.Idle, statuses[idle])
+	assert.Equal(t, presence.Offline, statuses[offline])
+}
+
+func TestPresenceConcurrentConnections(t *testing.T) {
+	tracker := presence.NewTracker(presence.NewMemoryStore())
+	userID := uuid.New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(connID string) {
+			defer wg.Done()
+			tracker.Update(userID, connID, false)
+			tracker.Disconnect(userID, connID)
+		}(uuid.NewString())
+	}
+	wg.Wait()
+
+	assert.Equal(t, presence.Offline, tracker.Status(userID))
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"app/protocol"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocolDecodeRejectsMalformedFrames(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+		err  error
+	}{
+		{"not json", `{"op":`, protocol.ErrInvalidFrame},
+		{"missing version", `{"op":"heartbeat"}`, protocol.ErrUnsupportedVersion},
+		{"future version", `{"op":"heartbeat","v":2}`, protocol.ErrUnsupportedVersion},
+		{"unknown op", `{"op":"explode","v":1}`, protocol.ErrUnknownOp},
+		{"legacy map", `{"Content":"hi","UserID":"x"}`, protocol.ErrUnsupportedVersion},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := protocol.Decode([]byte(tc.raw))
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestProtocolDecodeMessageSend(t *testing.T) {
+	frame, err := protocol.Decode([]byte(`{"op":"message_send","v":1,"nonce":"n1","d":{"content":"hello","type":"text"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "n1", frame.Nonce)
+
+	var msg protocol.MessageSend
+	assert.Nil(t, frame.DecodeData(&msg))
+	assert.Nil(t, msg.Validate())
+	assert.Equal(t, "hello", msg.Content)
+}
+
+func TestProtocolDecodeInvalidPayload(t *testing.T) {
+	cases := []string{
+		`{"op":"message_send","v":1}`,
+		`{"op":"message_send","v":1,"d":{"content":42}}`,
+		`{"op":"message_send","v":1,"d":"hello"}`,
+	}
+
+	for _, raw := range cases {
+		frame, err := protocol.Decode([]byte(raw))
+		assert.Nil(t, err)
+
+		var msg protocol.MessageSend
+		assert.ErrorIs(t, frame.DecodeData(&msg), protocol.ErrInvalidPayload)
+		assert.Equal(t, protocol.CodeInvalidPayload, protocol.ErrorCode(protocol.ErrInvalidPayload))
+	}
+
+	frame, _ := protocol.Decode([]byte(`{"op":"message_send","v":1,"d":{"content":""}}`))
+	var msg protocol.MessageSend
+	assert.Nil(t, frame.DecodeData(&msg))
+	assert.NotNil(t, msg.Validate())
+}
+
+func TestProtocolRejectsSpoofedIdentity(t *testing.T) {
+	session := uuid.New()
+	victim := uuid.New()
+
+	cases := []struct {
+		name string
+		raw  string
+		err  error
+	}{
+		{"no claimed identity", `{"content":"hi","type":"text"}`, nil},
+		{"own identity", `{"content":"hi","type":"text","user_id":"` + session.String() + `"}`, nil},
+		{"other user", `{"content":"hi","type":"text","user_id":"` + victim.String() + `"}`, protocol.ErrIdentityMismatch},
+		{"legacy field ignored", `{"content":"hi","type":"text","UserID":"` + victim.String() + `"}`, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			frame, err := protocol.Decode([]byte(`{"op":"message_send","v":1,"d":` + tc.raw + `}`))
+			assert.Nil(t, err)
+
+			var msg protocol.MessageSend
+			assert.Nil(t, frame.DecodeData(&msg))
+			if tc.err == nil {
+				assert.Nil(t, msg.VerifyIdentity(session))
+			} else {
+				assert.ErrorIs(t, msg.VerifyIdentity(session), tc.err)
+				assert.Equal(t, protocol.CodeIdentityMismatch, protocol.ErrorCode(msg.VerifyIdentity(session)))
+			}
+		})
+	}
+}
+
+func TestProtocolRejectsMalformedIdentity(t *testing.T) {
+	frame, err := protocol.Decode([]byte(`{"op":"message_send","v":1,"d":{"content":"hi","type":"text","user_id":"admin"}}`))
+	assert.Nil(t, err)
+
+	var msg protocol.MessageSend
+	assert.ErrorIs(t, frame.DecodeData(&msg), protocol.ErrInvalidPayload)
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"app/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketRefills(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Burst: 3, Per: 3 * time.Second}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 3; i++ {
+		wait, err := store.Take("k", limit, now)
+		assert.Nil(t, err)
+		assert.Zero(t, wait)
+	}
+	wait, _ := store.Take("k", limit, now)
+	assert.Equal(t, time.Second, wait)
+
+	wait, _ = store.Take("other", limit, now)
+	assert.Zero(t, wait, "buckets are per key")
+
+	wait, _ = store.Take("k", limit, now.Add(time.Second))
+	assert.Zero(t, wait)
+	wait, _ = store.Take("k", limit, now.Add(1500*time.Millisecond))
+	assert.Equal(t, 500*time.Millisecond, wait)
+}
+
+func TestLoginLockoutIsProgressive(t *testing.T) {
+	ratelimit.SetStore(ratelimit.NewMemoryStore())
+	lockout := ratelimit.Lockout{Name: "test", Threshold: 3, Base: time.Minute, Max: 4 * time.Minute, Window: time.Hour}
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 2; i++ {
+		wait, err := lockout.Fail("alice", now)
+		assert.Nil(t, err)
+		assert.Zero(t, wait)
+	}
+	wait, _ := lockout.Fail("alice", now)
+	assert.Equal(t, time.Minute, wait)
+
+	wait, _ = lockout.Check("alice", now.Add(30*time.Second))
+	assert.Equal(t, 30*time.Second, wait)
+	wait, _ = lockout.Check("bob", now)
+	assert.Zero(t, wait)
+
+	now = now.Add(time.Minute)
+	wait, _ = lockout.Fail("alice", now)
+	assert.Equal(t, 2*time.Minute, wait)
+	assert.Equal(t, 4*time.Minute, lockout.Duration(10))
+
+	assert.Nil(t, lockout.Succeed("alice"))
+	wait, _ = lockout.Check("alice", now)
+	assert.Zero(t, wait)
+
+	// Failures are forgotten after the window.
+	lockout.Fail("alice", now)
+	lockout.Fail("alice", now)
+	wait, _ = lockout.Fail("alice", now.Add(2*time.Hour))
+	assert.Zero(t, wait)
+}
+
+func TestRateLimitMiddlewareAnswers429(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ratelimit.SetStore(ratelimit.NewMemoryStore())
+
+	r := gin.New()
+	r.POST("/login", ratelimit.PerIP("login", 2, time.Minute), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	request := func(ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = ip + ":1234"
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1").Code)
+
+	w := request("10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, request("10.0.0.2").Code)
+}
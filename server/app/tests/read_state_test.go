@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"app/routes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckChannelRequiresToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.ChannelRoutes(r)
+
+	body := `{"messageId":"` + uuid.NewString() + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/channels/"+uuid.NewString()+"/ack", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
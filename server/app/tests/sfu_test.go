@@ -0,0 +1,265 @@
+package tests
+
+import (
+	"app/sfu"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/assert"
+)
+
+func loopbackSettings() webrtc.SettingEngine {
+	settings := webrtc.SettingEngine{}
+	settings.SetIncludeLoopbackCandidate(true)
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	settings.SetIPFilter(func(ip net.IP) bool { return ip.IsLoopback() })
+	settings.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	return settings
+}
+
+// voiceClient is a Pion peer speaking to the SFU through in-process
+// signaling instead of a websocket.
+type voiceClient struct {
+	pc      *webrtc.PeerConnection
+	peer    *sfu.Peer
+	signals chan sfu.Signal
+	tracks  chan *webrtc.TrackRemote
+	audio   *webrtc.TrackLocalStaticSample
+	done    chan struct{}
+}
+
+func newVoiceClient(t *testing.T, s *sfu.SFU, roomID uuid.UUID) *voiceClient {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		t.Fatalf("register codecs: %v", err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(loopbackSettings()))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("new peer connection: %v", err)
+	}
+
+	audio, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", uuid.NewString())
+	if err != nil {
+		t.Fatalf("new track: %v", err)
+	}
+	if _, err := pc.AddTrack(audio); err != nil {
+		t.Fatalf("add track: %v", err)
+	}
+
+	client := &voiceClient{
+		pc:      pc,
+		signals: make(chan sfu.Signal, 128),
+		tracks:  make(chan *webrtc.TrackRemote, 8),
+		audio:   audio,
+		done:    make(chan struct{}),
+	}
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		client.tracks <- track
+	})
+
+	client.peer, err = s.Join(roomID, uuid.New(), func(signal sfu.Signal) {
+		client.signals <- signal
+	})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		init := candidate.ToJSON()
+		client.peer.HandleSignal(sfu.Signal{Type: sfu.SignalCandidate, Candidate: &init})
+	})
+
+	go client.handleSignals(t)
+	go client.speak()
+	t.Cleanup(client.Close)
+	return client
+}
+
+func (c *voiceClient) handleSignals(t *testing.T) {
+	var pending []webrtc.ICECandidateInit
+	for {
+		select {
+		case <-c.done:
+			return
+		case signal := <-c.signals:
+			switch signal.Type {
+			case sfu.SignalOffer:
+				if err := c.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: signal.SDP}); err != nil {
+					t.Errorf("set remote description: %v", err)
+					return
+				}
+				answer, err := c.pc.CreateAnswer(nil)
+				if err != nil {
+					t.Errorf("create answer: %v", err)
+					return
+				}
+				if err := c.pc.SetLocalDescription(answer); err != nil {
+					t.Errorf("set local description: %v", err)
+					return
+				}
+				for _, candidate := range pending {
+					c.pc.AddICECandidate(candidate)
+				}
+				pending = nil
+				if err := c.peer.HandleSignal(sfu.Signal{Type: sfu.SignalAnswer, SDP: answer.SDP}); err != nil {
+					t.Errorf("send answer: %v", err)
+				}
+			case sfu.SignalCandidate:
+				if c.pc.RemoteDescription() == nil {
+					pending = append(pending, *signal.Candidate)
+					continue
+				}
+				c.pc.AddICECandidate(*signal.Candidate)
+			}
+		}
+	}
+}
+
+// speak sends silent Opus frames every 20ms.
+func (c *voiceClient) speak() {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.audio.WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond})
+		}
+	}
+}
+
+func (c *voiceClient) Close() {
+	select {
+	case <-c.done:
+		return
+	default:
+		close(c.done)
+	}
+	c.peer.Close()
+	c.pc.Close()
+}
+
+func (c *voiceClient) expectAudio(t *testing.T) {
+	select {
+	case track := <-c.tracks:
+		assert.Equal(t, webrtc.MimeTypeOpus, track.Codec().MimeType)
+		track.SetReadDeadline(time.Now().Add(5 * time.Second))
+		packet, _, err := track.ReadRTP()
+		assert.Nil(t, err)
+		if packet != nil {
+			assert.NotEmpty(t, packet.Payload)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("no track forwarded")
+	}
+}
+
+func TestSFUForwardsAudioBetweenPeers(t *testing.T) {
+	s, err := sfu.New(sfu.Config{SettingEngine: loopbackSettings()})
+	if err != nil {
+		t.Fatalf("new sfu: %v", err)
+	}
+
+	var mu sync.Mutex
+	joined, left := 0, 0
+	s.OnJoin = func(*sfu.Peer) { mu.Lock(); joined++; mu.Unlock() }
+	s.OnLeave = func(*sfu.Peer) { mu.Lock(); left++; mu.Unlock() }
+
+	roomID := uuid.New()
+	alice := newVoiceClient(t, s, roomID)
+	bob := newVoiceClient(t, s, roomID)
+	assert.Len(t, s.Participants(roomID), 2)
+
+	bob.expectAudio(t)
+	alice.expectAudio(t)
+
+	assert.Eventually(t, func() bool {
+		stats := s.Stats(roomID)
+		return len(stats) == 2 && stats[0].PacketsReceived > 0 && stats[1].PacketsReceived > 0
+	}, 5*time.Second, 50*time.Millisecond)
+	for _, peer := range s.Stats(roomID) {
+		assert.Equal(t, "connected", peer.State)
+		assert.GreaterOrEqual(t, peer.Jitter, 0.0)
+	}
+
+	alice.Close()
+	assert.Eventually(t, func() bool {
+		return len(s.Participants(roomID)) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	bob.Close()
+	assert.Empty(t, s.Participants(roomID))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, joined)
+	assert.Equal(t, 2, left)
+}
+
+func TestSFURejectsUnknownSignal(t *testing.T) {
+	s, err := sfu.New(sfu.Config{SettingEngine: loopbackSettings()})
+	if err != nil {
+		t.Fatalf("new sfu: %v", err)
+	}
+
+	peer, err := s.Join(uuid.New(), uuid.New(), func(sfu.Signal) {})
+	assert.Nil(t, err)
+	defer peer.Close()
+
+	assert.ErrorIs(t, peer.HandleSignal(sfu.Signal{Type: "hangup"}), sfu.ErrUnknownSignal)
+	assert.ErrorIs(t, peer.HandleSignal(sfu.Signal{Type: sfu.SignalCandidate}), sfu.ErrUnknownSignal)
+}
+
+func TestSFURecordsEachParticipant(t *testing.T) {
+	s, err := sfu.New(sfu.Config{SettingEngine: loopbackSettings()})
+	if err != nil {
+		t.Fatalf("new sfu: %v", err)
+	}
+
+	roomID, owner := uuid.New(), uuid.New()
+	dir := t.TempDir()
+	recording, err := s.StartRecording(roomID, owner, dir)
+	assert.Nil(t, err)
+	assert.Equal(t, owner, recording.StartedBy)
+	assert.Equal(t, recording, s.Recording(roomID))
+
+	_, err = s.StartRecording(roomID, owner, dir)
+	assert.ErrorIs(t, err, sfu.ErrAlreadyRecording)
+
+	alice := newVoiceClient(t, s, roomID)
+	bob := newVoiceClient(t, s, roomID)
+	bob.expectAudio(t)
+	alice.expectAudio(t)
+
+	stopped, files, err := s.StopRecording(roomID)
+	assert.Nil(t, err)
+	assert.Equal(t, recording, stopped)
+	assert.Nil(t, s.Recording(roomID))
+	assert.Len(t, files, 2)
+
+	users := map[uuid.UUID]bool{}
+	for _, file := range files {
+		users[file.UserID] = true
+		assert.Greater(t, file.Packets, 0)
+		data, err := os.ReadFile(file.Path)
+		assert.Nil(t, err)
+		assert.True(t, len(data) > 4 && string(data[:4]) == "OggS")
+	}
+	assert.True(t, users[alice.peer.UserID])
+	assert.True(t, users[bob.peer.UserID])
+
+	_, _, err = s.StopRecording(roomID)
+	assert.ErrorIs(t, err, sfu.ErrNotRecording)
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"app/services"
+	"app/totp"
+	"encoding/base32"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTOTPRFC6238Vectors checks the SHA-1 test vectors of RFC 6238 appendix B.
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, vector := range vectors {
+		step := totp.Step(time.Unix(vector.unix, 0))
+		assert.Equal(t, vector.code, totp.HOTP(key, step, 8), "T=%d", vector.unix)
+	}
+}
+
+func TestTOTPValidateAcceptsAdjacentSteps(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	code, err := totp.Code(secret, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "050471", code)
+
+	step, ok := totp.Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, totp.Step(now), step)
+
+	_, ok = totp.Validate(secret, code, now.Add(totp.Period*time.Second))
+	assert.True(t, ok)
+	_, ok = totp.Validate(secret, code, now.Add(3*totp.Period*time.Second))
+	assert.False(t, ok)
+	_, ok = totp.Validate(secret, "000000", now)
+	assert.False(t, ok)
+	_, ok = totp.Validate("not base32!", code, now)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	assert.Nil(t, err)
+	assert.Len(t, secret, 32)
+
+	uri, err := url.Parse(totp.ProvisioningURI("Unity Hub", "alice@example.com", secret))
+	assert.Nil(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Unity Hub:alice@example.com", uri.Path)
+	assert.Equal(t, secret, uri.Query().Get("secret"))
+	assert.Equal(t, "Unity Hub", uri.Query().Get("issuer"))
+}
+
+func TestTOTPHandlersRefuseOtherAccounts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handlers := map[string]gin.HandlerFunc{
+		"enroll":         services.EnrollTwoFactorHandler(),
+		"confirm":        services.ConfirmTwoFactorHandler(),
+		"disable":        services.DisableTwoFactorHandler(),
+		"recovery-codes": services.RegenerateRecoveryCodesHandler(),
+	}
+	for name, handler := range handlers {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"code":"123456"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = gin.Params{{Key: "id", Value: uuid.NewString()}}
+		c.Set("jwt_claims", jwt.MapClaims{"jti": uuid.NewString()})
+
+		handler(c)
+		assert.Equal(t, http.StatusForbidden, w.Code, name)
+	}
+}
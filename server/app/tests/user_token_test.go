@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"app/controllers"
+	"app/db/models"
+	"app/testutils"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserTokenHashIsUnique(t *testing.T) {
+	db := testutils.SetupTestDB()
+
+	hash := controllers.HashToken(uuid.NewString())
+	token := models.UserToken{
+		UserID:    uuid.New(),
+		Purpose:   models.UserTokenResetPassword,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	assert.Nil(t, db.Create(&token).Error)
+	assert.NotEqual(t, uuid.Nil, token.ID)
+
+	duplicate := models.UserToken{
+		UserID:    uuid.New(),
+		Purpose:   models.UserTokenVerifyEmail,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	assert.NotNil(t, db.Create(&duplicate).Error)
+}
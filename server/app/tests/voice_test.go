@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"app/voice"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVoiceRegistryJoinAndLeave(t *testing.T) {
+	registry := voice.NewRegistry()
+	serverID, channelID, userID := uuid.New(), uuid.New(), uuid.New()
+
+	state, _, replaced := registry.Join(voice.State{UserID: userID, ChannelID: channelID, ServerID: serverID, SessionID: "a"})
+	assert.False(t, replaced)
+	assert.False(t, state.JoinedAt.IsZero())
+	assert.Len(t, registry.Channel(channelID), 1)
+	assert.Len(t, registry.Server(serverID)[channelID], 1)
+
+	_, ok := registry.Leave(userID, "stale")
+	assert.False(t, ok)
+
+	left, ok := registry.Leave(userID, "a")
+	assert.True(t, ok)
+	assert.Equal(t, channelID, left.ChannelID)
+	assert.Empty(t, registry.Channel(channelID))
+}
+
+func TestVoiceRegistryOneChannelPerUser(t *testing.T) {
+	registry := voice.NewRegistry()
+	serverID, first, second, userID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	registry.Join(voice.State{UserID: userID, ChannelID: first, ServerID: serverID, SessionID: "a"})
+	_, previous, replaced := registry.Join(voice.State{UserID: userID, ChannelID: second, ServerID: serverID, SessionID: "b"})
+	assert.True(t, replaced)
+	assert.Equal(t, "a", previous.SessionID)
+	assert.Empty(t, registry.Channel(first))
+	assert.Len(t, registry.Channel(second), 1)
+
+	// The replaced session leaving must not remove the new one.
+	_, ok := registry.Leave(userID, "a")
+	assert.False(t, ok)
+	assert.Len(t, registry.Channel(second), 1)
+}
+
+func TestVoiceRegistrySelfFlags(t *testing.T) {
+	registry := voice.NewRegistry()
+	userID := uuid.New()
+	registry.Join(voice.State{UserID: userID, ChannelID: uuid.New(), SessionID: "a"})
+
+	_, ok := registry.SetSelf(userID, "other", true, false)
+	assert.False(t, ok)
+
+	state, ok := registry.SetSelf(userID, "a", false, true)
+	assert.True(t, ok)
+	assert.True(t, state.SelfDeaf)
+	assert.True(t, state.Muted())
+
+	state, _ = registry.SetSelf(userID, "a", false, false)
+	assert.False(t, state.Muted())
+}
+
+func TestVoiceRegistryServerMuteSurvivesReconnect(t *testing.T) {
+	registry := voice.NewRegistry()
+	serverID, channelID, userID := uuid.New(), uuid.New(), uuid.New()
+
+	_, inVoice := registry.SetServerMute(serverID, userID, true)
+	assert.False(t, inVoice)
+
+	state, _, _ := registry.Join(voice.State{UserID: userID, ChannelID: channelID, ServerID: serverID, SessionID: "a"})
+	assert.True(t, state.ServerMute)
+	assert.True(t, state.Muted())
+
+	registry.Leave(userID, "a")
+	state, _, _ = registry.Join(voice.State{UserID: userID, ChannelID: channelID, ServerID: serverID, SessionID: "b"})
+	assert.True(t, state.ServerMute)
+
+	state, inVoice = registry.SetServerMute(serverID, userID, false)
+	assert.True(t, inVoice)
+	assert.False(t, state.Muted())
+
+	// Mutes are per server.
+	state, _, _ = registry.Join(voice.State{UserID: userID, ChannelID: uuid.New(), ServerID: uuid.New(), SessionID: "c"})
+	assert.False(t, state.ServerMute)
+}
+
+func TestVoiceRegistryChannelOrder(t *testing.T) {
+	registry := voice.NewRegistry()
+	channelID := uuid.New()
+	now := time.Now()
+	late, early := uuid.New(), uuid.New()
+
+	registry.Join(voice.State{UserID: late, ChannelID: channelID, SessionID: "late", JoinedAt: now})
+	registry.Join(voice.State{UserID: early, ChannelID: channelID, SessionID: "early", JoinedAt: now.Add(-time.Minute)})
+
+	states := registry.Channel(channelID)
+	assert.Equal(t, early, states[0].UserID)
+	assert.Equal(t, late, states[1].UserID)
+}
@@ -49,14 +49,22 @@ func MakeTestMigrations() {
 		&models.ActiveRule{},
 		&models.Media{},
 		&models.Channel{},
-		&models.Event{},
 		&models.Server{},
-		&models.EventServer{},
 		&models.Friend{},
 		&models.Feature{},
 		&models.Invitation{},
 		&models.Logs{},
 		&models.Message{},
+		&models.MessageRevision{},
+		&models.ReadState{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.UserToken{},
+		&models.OutboxEmail{},
+		&models.RecoveryCode{},
+		&models.ChannelOverwrite{},
+		&models.ChannelChannelPermissions{},
+		&models.ChannelPermissions{},
 		&models.OnServer{},
 		&models.Permissions{},
 		&models.React{},
@@ -68,6 +76,7 @@ func MakeTestMigrations() {
 		&models.Ban{},
 		&models.Group{},
 		&models.GroupMember{},
+		&models.DataMigration{},
 	)
 
 	if err != nil {
@@ -0,0 +1,172 @@
+// Package voice keeps track of who is connected to which voice channel.
+package voice
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// State is the voice state of one user. A user is in at most one voice
+// channel at a time; SessionID names the SFU peer carrying their audio.
+type State struct {
+	UserID     uuid.UUID `json:"user_id"`
+	ChannelID  uuid.UUID `json:"channel_id"`
+	ServerID   uuid.UUID `json:"server_id"`
+	SessionID  string    `json:"session_id"`
+	SelfMute   bool      `json:"self_mute"`
+	SelfDeaf   bool      `json:"self_deaf"`
+	ServerMute bool      `json:"server_mute"`
+	JoinedAt   time.Time `json:"joined_at"`
+}
+
+// Muted tells whether the user's audio must not be forwarded. Deafened users
+// are muted as well.
+func (s State) Muted() bool {
+	return s.SelfMute || s.SelfDeaf || s.ServerMute
+}
+
+// Registry is safe for concurrent use. Server mutes outlive the voice
+// session so reconnecting does not lift them.
+type Registry struct {
+	mu          sync.Mutex
+	states      map[uuid.UUID]State
+	serverMutes map[uuid.UUID]map[uuid.UUID]bool
+}
+
+var (
+	defaultRegistry *Registry
+	once            sync.Once
+)
+
+// GetRegistry returns the process wide registry.
+func GetRegistry() *Registry {
+	once.Do(func() {
+		defaultRegistry = NewRegistry()
+	})
+	return defaultRegistry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		states:      make(map[uuid.UUID]State),
+		serverMutes: make(map[uuid.UUID]map[uuid.UUID]bool),
+	}
+}
+
+// Join records state, applying any server mute, and returns it along with the
+// session it replaced, if any.
+func (r *Registry) Join(state State) (State, State, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, replaced := r.states[state.UserID]
+	if state.JoinedAt.IsZero() {
+		state.JoinedAt = time.Now()
+	}
+	state.ServerMute = r.serverMutes[state.ServerID][state.UserID]
+	r.states[state.UserID] = state
+	return state, previous, replaced
+}
+
+// Leave forgets the user if sessionID is still their current session.
+func (r *Registry) Leave(userID uuid.UUID, sessionID string) (State, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[userID]
+	if !ok || state.SessionID != sessionID {
+		return State{}, false
+	}
+	delete(r.states, userID)
+	return state, true
+}
+
+// SetSelf updates the flags the user controls on their current session.
+func (r *Registry) SetSelf(userID uuid.UUID, sessionID string, selfMute bool, selfDeaf bool) (State, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[userID]
+	if !ok || state.SessionID != sessionID {
+		return State{}, false
+	}
+	state.SelfMute = selfMute
+	state.SelfDeaf = selfDeaf
+	r.states[userID] = state
+	return state, true
+}
+
+// SetServerMute mutes or unmutes userID on serverID. It returns the user's
+// state when they are currently in a voice channel of that server.
+func (r *Registry) SetServerMute(serverID uuid.UUID, userID uuid.UUID, mute bool) (State, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if mute {
+		if r.serverMutes[serverID] == nil {
+			r.serverMutes[serverID] = make(map[uuid.UUID]bool)
+		}
+		r.serverMutes[serverID][userID] = true
+	} else if mutes := r.serverMutes[serverID]; mutes != nil {
+		delete(mutes, userID)
+		if len(mutes) == 0 {
+			delete(r.serverMutes, serverID)
+		}
+	}
+
+	state, ok := r.states[userID]
+	if !ok || state.ServerID != serverID {
+		return State{}, false
+	}
+	state.ServerMute = mute
+	r.states[userID] = state
+	return state, true
+}
+
+func (r *Registry) Get(userID uuid.UUID) (State, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[userID]
+	return state, ok
+}
+
+// Channel lists the users in channelID, earliest first.
+func (r *Registry) Channel(channelID uuid.UUID) []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	states := []State{}
+	for _, state := range r.states {
+		if state.ChannelID == channelID {
+			states = append(states, state)
+		}
+	}
+	sortByJoin(states)
+	return states
+}
+
+// Server groups the users in voice on serverID by channel, earliest first.
+func (r *Registry) Server(serverID uuid.UUID) map[uuid.UUID][]State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	channels := make(map[uuid.UUID][]State)
+	for _, state := range r.states {
+		if state.ServerID == serverID {
+			channels[state.ChannelID] = append(channels[state.ChannelID], state)
+		}
+	}
+	for _, states := range channels {
+		sortByJoin(states)
+	}
+	return channels
+}
+
+func sortByJoin(states []State) {
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].JoinedAt.Before(states[j].JoinedAt)
+	})
+}